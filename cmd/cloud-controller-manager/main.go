@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/cloud-provider/app"
+	"k8s.io/cloud-provider/app/config"
+	"k8s.io/cloud-provider/names"
+	"k8s.io/cloud-provider/options"
+	"k8s.io/component-base/cli"
+	cliflag "k8s.io/component-base/cli/flag"
+	_ "k8s.io/component-base/metrics/prometheus/clientgo"
+	_ "k8s.io/component-base/metrics/prometheus/version"
+	"k8s.io/klog/v2"
+
+	_ "github.com/kamatera/kamatera-rke2-controller/internal/kamateracloud"
+)
+
+func main() {
+	ccmOptions, err := options.NewCloudControllerManagerOptions()
+	if err != nil {
+		klog.Fatalf("unable to initialize command options: %v", err)
+	}
+
+	command := app.NewCloudControllerManagerCommand(ccmOptions, cloudInitializer, app.DefaultInitFuncConstructors, names.CCMControllerAliases(), cliflag.NamedFlagSets{}, wait.NeverStop)
+	os.Exit(cli.Run(command))
+}
+
+func cloudInitializer(config *config.CompletedConfig) cloudprovider.Interface {
+	cloudConfig := config.ComponentConfig.KubeCloudShared.CloudProvider
+	cloud, err := cloudprovider.InitCloudProvider(cloudConfig.Name, cloudConfig.CloudConfigFile)
+	if err != nil {
+		klog.Fatalf("Cloud provider could not be initialized: %v", err)
+	}
+	if cloud == nil {
+		klog.Fatalf("Cloud provider %q is not registered", cloudConfig.Name)
+	}
+	if !cloud.HasClusterID() {
+		if !config.ComponentConfig.KubeCloudShared.AllowUntaggedCloud {
+			klog.Fatalf("Cloud provider %q does not tag servers with a cluster ID, run with --allow-untagged-cloud", cloudConfig.Name)
+		}
+		klog.Infof("Cloud provider %q does not tag servers with a cluster ID, servers are scoped to the cluster by the cloud config server filter", cloudConfig.Name)
+	}
+	return cloud
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+
+	"github.com/kamatera/kamatera-rke2-controller/internal/kamaterafake"
+)
+
+// The integration tests run the manager as wired by run against an envtest
+// API server and the fake Kamatera API. They need the envtest binaries, see
+// `make test-integration`, and are skipped without KUBEBUILDER_ASSETS.
+
+const integrationTimeout = 30 * time.Second
+
+// integrationLogs receives the manager logs of all tests, since the
+// controller-runtime logger can only be set once per process.
+var integrationLogs = &syncBuffer{}
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func (b *syncBuffer) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.Reset()
+}
+
+type integrationHarness struct {
+	client client.Client
+	api    *kamaterafake.API
+}
+
+// startManager starts an envtest API server and runs the manager against it
+// and api with the given extra flags, until the test ends.
+func startManager(t *testing.T, api *kamaterafake.API, args ...string) *integrationHarness {
+	t.Helper()
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set, run make test-integration")
+	}
+
+	env := &envtest.Environment{}
+	cfg, err := env.Start()
+	if err != nil {
+		t.Fatalf("start envtest: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := env.Stop(); err != nil {
+			t.Errorf("stop envtest: %v", err)
+		}
+	})
+	k8sClient, err := client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+
+	api.SetCredentials("client-id", "secret")
+	apiServer := httptest.NewServer(api)
+	t.Cleanup(apiServer.Close)
+	t.Setenv("KAMATERA_API_URL", apiServer.URL)
+	t.Setenv("KAMATERA_API_CLIENT_ID", "client-id")
+	t.Setenv("KAMATERA_API_SECRET", "secret")
+
+	integrationLogs.Reset()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	flags := append([]string{
+		"-metrics-bind-address=0",
+		"-health-probe-bind-address=0",
+		"-leader-elect",
+		"-leader-election-namespace=default",
+		"-leader-election-id=" + strings.ToLower(strings.ReplaceAll(t.Name(), "_", "-")),
+		"-max-deletions-per-window=5",
+		"-deletion-budget-configmap=default/" + strings.ToLower(strings.ReplaceAll(t.Name(), "_", "-")) + "-deletion-budget",
+		"-kamatera-server-list-interval=200ms",
+		"-kamatera-api-qps=0",
+		"-node-delete-poll-interval=200ms",
+		"-snapshots-log-interval=200ms",
+		"-not-ready-duration=1m",
+	}, args...)
+	go func() {
+		done <- run(ctx, flag.NewFlagSet("controller", flag.ContinueOnError), flags, cfg, integrationLogs)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("run manager: %v", err)
+			}
+		case <-time.After(integrationTimeout):
+			t.Errorf("manager did not stop")
+		}
+		if t.Failed() {
+			t.Logf("manager logs:\n%s", integrationLogs.String())
+		}
+	})
+	return &integrationHarness{client: k8sClient, api: api}
+}
+
+// createNode creates a Node with the given Ready status since an hour ago.
+func (h *integrationHarness) createNode(t *testing.T, name string, ready corev1.ConditionStatus) {
+	t.Helper()
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	if err := h.client.Create(context.Background(), node); err != nil {
+		t.Fatalf("create node %s: %v", name, err)
+	}
+	since := metav1.NewTime(time.Now().Add(-time.Hour))
+	node.Status.Conditions = []corev1.NodeCondition{{
+		Type:               corev1.NodeReady,
+		Status:             ready,
+		Reason:             "KubeletReady",
+		LastHeartbeatTime:  since,
+		LastTransitionTime: since,
+	}}
+	if err := h.client.Status().Update(context.Background(), node); err != nil {
+		t.Fatalf("update node %s status: %v", name, err)
+	}
+}
+
+func (h *integrationHarness) nodeExists(t *testing.T, name string) bool {
+	t.Helper()
+	err := h.client.Get(context.Background(), types.NamespacedName{Name: name}, &corev1.Node{})
+	if apierrors.IsNotFound(err) {
+		return false
+	}
+	if err != nil {
+		t.Fatalf("get node %s: %v", name, err)
+	}
+	return true
+}
+
+// nodeEventReasons returns the reasons of the Events recorded for the Node.
+func (h *integrationHarness) nodeEventReasons(t *testing.T, name string) []string {
+	t.Helper()
+	var events corev1.EventList
+	if err := h.client.List(context.Background(), &events, client.InNamespace(metav1.NamespaceDefault)); err != nil {
+		t.Fatalf("list events: %v", err)
+	}
+	var reasons []string
+	for _, event := range events.Items {
+		if event.InvolvedObject.Kind == "Node" && event.InvolvedObject.Name == name {
+			reasons = append(reasons, event.Reason)
+		}
+	}
+	return reasons
+}
+
+func (h *integrationHarness) waitForNodeEvent(t *testing.T, name string, reason string) {
+	t.Helper()
+	eventually(t, func() error {
+		reasons := h.nodeEventReasons(t, name)
+		for _, recorded := range reasons {
+			if recorded == reason {
+				return nil
+			}
+		}
+		return fmt.Errorf("node %s has events %v, waiting for %s", name, reasons, reason)
+	})
+}
+
+func (h *integrationHarness) waitForNodeDeleted(t *testing.T, name string) {
+	t.Helper()
+	eventually(t, func() error {
+		if h.nodeExists(t, name) {
+			return fmt.Errorf("node %s still exists", name)
+		}
+		return nil
+	})
+}
+
+func eventually(t *testing.T, check func() error) {
+	t.Helper()
+	deadline := time.Now().Add(integrationTimeout)
+	for {
+		err := check()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// assertLogLines waits until each expected line is a subset of a JSON log
+// line of the manager, like assert_log_lines in tests/tests/test_controller.py.
+func assertLogLines(t *testing.T, expected ...map[string]interface{}) {
+	t.Helper()
+	eventually(t, func() error {
+		lines := parseLogLines(integrationLogs.String())
+		for _, want := range expected {
+			if !containsLogLine(lines, normalizeLogLine(want)) {
+				return fmt.Errorf("no log line matches %v", want)
+			}
+		}
+		return nil
+	})
+}
+
+func parseLogLines(logs string) []map[string]interface{} {
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(logs, "\n") {
+		var parsed map[string]interface{}
+		if err := json.Unmarshal([]byte(strings.TrimSpace(line)), &parsed); err == nil {
+			lines = append(lines, parsed)
+		}
+	}
+	return lines
+}
+
+// normalizeLogLine converts the expected values to the types decoded from
+// JSON log lines.
+func normalizeLogLine(line map[string]interface{}) map[string]interface{} {
+	data, _ := json.Marshal(line)
+	var normalized map[string]interface{}
+	_ = json.Unmarshal(data, &normalized)
+	return normalized
+}
+
+func containsLogLine(lines []map[string]interface{}, want map[string]interface{}) bool {
+	for _, line := range lines {
+		matched := true
+		for key, value := range want {
+			if !reflect.DeepEqual(line[key], value) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func TestIntegrationDeletesNotReadyNodesOfPoweredOffServers(t *testing.T) {
+	api := kamaterafake.New(
+		kamaterafake.Server{ID: "1", Name: "worker1", Datacenter: "EU", Power: "on"},
+		kamaterafake.Server{ID: "2", Name: "worker2", Datacenter: "EU", Power: "on"},
+	)
+	h := startManager(t, api)
+	h.createNode(t, "worker1", corev1.ConditionFalse)
+	h.createNode(t, "worker2", corev1.ConditionTrue)
+	h.createNode(t, "worker3", corev1.ConditionUnknown)
+
+	assertLogLines(t,
+		map[string]interface{}{"logger": "controllers.KamateraServers", "msg": "kamatera server observed", "name": "worker1", "datacenter": "EU", "power": "on"},
+		map[string]interface{}{"logger": "controllers.NodeList", "msg": "node added", "node": "worker1", "ready": "False", "matchedServer": true, "serverName": "worker1", "serverPower": "on"},
+		map[string]interface{}{"logger": "controllers.SnapshotLogger", "msg": "snapshot node/server match", "nodeName": "worker2", "nodeReady": "True", "serverName": "worker2"},
+		map[string]interface{}{"logger": "controllers.NodeDelete", "msg": "deleted node due to NotReady timeout and Kamatera server unknown", "node": "worker3"},
+	)
+	h.waitForNodeDeleted(t, "worker3")
+	if !h.nodeExists(t, "worker1") {
+		t.Fatalf("expected NotReady worker1 with a powered on server to be kept")
+	}
+
+	api.SetPower("worker1", "off")
+	assertLogLines(t,
+		map[string]interface{}{"logger": "controllers.KamateraServers", "msg": "server power changed", "name": "worker1", "oldPower": "on", "newPower": "off"},
+		map[string]interface{}{"logger": "controllers.NodeDelete", "msg": "deleted node due to NotReady timeout and Kamatera server powered off", "node": "worker1"},
+	)
+	h.waitForNodeDeleted(t, "worker1")
+	h.waitForNodeEvent(t, "worker1", "KamateraServerPowerChanged")
+	h.waitForNodeEvent(t, "worker1", "Deleted")
+	if !h.nodeExists(t, "worker2") {
+		t.Fatalf("expected Ready worker2 to be kept")
+	}
+}
+
+func TestIntegrationKeepsNodesWhileServerListIsSuspicious(t *testing.T) {
+	api := kamaterafake.New(kamaterafake.Server{ID: "1", Name: "worker1", Datacenter: "EU", Power: "off"})
+	api.AddFault(kamaterafake.Fault{Path: "/service/servers", Message: kamaterafake.NoServersFoundMessage})
+	h := startManager(t, api)
+	h.createNode(t, "worker1", corev1.ConditionFalse)
+
+	h.waitForNodeEvent(t, "worker1", "DeletionSkipped")
+	assertLogLines(t,
+		map[string]interface{}{"logger": "controllers.KamateraServers", "msg": "suspicious Kamatera server list, not deleting nodes whose server is absent", "reason": "the filtered Kamatera server list is empty"},
+		map[string]interface{}{"logger": "controllers.NodeDelete", "msg": "not deleting node without a matching Kamatera server because the server list is suspicious", "node": "worker1"},
+	)
+	if !h.nodeExists(t, "worker1") {
+		t.Fatalf("expected worker1 to be kept while the server list is suspicious")
+	}
+
+	api.ClearFaults()
+	assertLogLines(t,
+		map[string]interface{}{"logger": "controllers.KamateraServers", "msg": "Kamatera server list is no longer suspicious"},
+		map[string]interface{}{"logger": "controllers.NodeDelete", "msg": "deleted node due to NotReady timeout and Kamatera server powered off", "node": "worker1"},
+	)
+	h.waitForNodeDeleted(t, "worker1")
+}
+
+func TestIntegrationDryRunRecordsWouldBeDeletions(t *testing.T) {
+	api := kamaterafake.New(kamaterafake.Server{ID: "1", Name: "kamatera-worker1", Datacenter: "EU", Power: "off"})
+	h := startManager(t, api, "-dry-run", "-match-node-to-server-template=kamatera-%s")
+	h.createNode(t, "worker1", corev1.ConditionFalse)
+
+	h.waitForNodeEvent(t, "worker1", "DryRunDelete")
+	assertLogLines(t,
+		map[string]interface{}{"logger": "setup", "msg": "dry-run mode enabled, nodes will not be remediated, drained or deleted"},
+		map[string]interface{}{"logger": "controllers.NodeDelete", "msg": "dry-run: would delete node due to NotReady timeout and Kamatera server powered off", "node": "worker1"},
+	)
+	if !h.nodeExists(t, "worker1") {
+		t.Fatalf("expected worker1 not to be deleted in dry-run mode")
+	}
+}
+
+func TestRunRejectsInvalidFlags(t *testing.T) {
+	err := run(context.Background(), flag.NewFlagSet("controller", flag.ContinueOnError), []string{"-not-ready-duration=0"}, nil, integrationLogs)
+	if err == nil || !strings.Contains(err.Error(), "--not-ready-duration must be greater than 0") {
+		t.Fatalf("expected an invalid flag error, got %v", err)
+	}
+}
@@ -1,16 +1,26 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/go-logr/logr"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
 	nodecontroller "github.com/kamatera/kamatera-rke2-controller/internal/controller"
@@ -23,10 +33,19 @@ func init() {
 }
 
 func main() {
+	if err := run(ctrl.SetupSignalHandler(), flag.CommandLine, os.Args[1:], nil, os.Stderr); err != nil {
+		os.Exit(1)
+	}
+}
+
+// run sets up and runs the manager until ctx is done. The flags are registered
+// on fs and parsed from args. A nil restConfig is loaded from the kubeconfig.
+func run(ctx context.Context, fs *flag.FlagSet, args []string, restConfig *rest.Config, logOutput io.Writer) error {
 	var metricsAddr string
 	var healthProbeAddr string
 	var enableLeaderElection bool
 	var leaderElectionID string
+	var leaderElectionNamespace string
 	var notReadyDuration time.Duration
 	var allowControlPlane bool
 	var kamateraServerListInterval time.Duration
@@ -38,74 +57,246 @@ func main() {
 	var nodeTrackedAnnotations string
 	var matchNodeToServerTemplate string
 	var matchServerToNodeTemplate string
+	var matchStrategyValue string
+	var matchRulesFile string
+	var remediationModeValue string
+	var remediationNotReadyDuration time.Duration
+	var remediationMaxAttempts int
+	var remediationCooldown time.Duration
+	var drainBeforeDelete bool
+	var drainTimeout time.Duration
+	var dryRun bool
+	var maxDeletionsPerWindow int
+	var deletionWindow time.Duration
+	var maxDeletionPercent int
+	var deletionBudgetConfigMap string
+	var maxServerSnapshotStaleness time.Duration
+	var absentServerPolicyValue string
+	var absentServerConsecutivePolls int
+	var allowEmptyServerList bool
+	var maxServerListDropPercent int
+	var serverListDropConfirmations int
+	var kamateraAPIQPS float64
+	var kamateraAPIBurst int
+	var setProviderID bool
+	var matchPreferProviderID bool
+	var syncNodeLabels bool
+	var syncNodeAddresses bool
+	var cloudControllerManagerLease string
+	var externalNetworks string
+	var internalNetworks string
 
 	zapOpts := zap.Options{Development: false}
-	zapOpts.BindFlags(flag.CommandLine)
-
-	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
-	flag.StringVar(&healthProbeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
-	flag.BoolVar(&enableLeaderElection, "leader-elect", false, "Enable leader election for controller manager.")
-	flag.StringVar(&leaderElectionID, "leader-election-id", "kamatera-rke2-controller.kamatera.io", "Leader election ID to use for the controller manager.")
-
-	flag.DurationVar(&notReadyDuration, "not-ready-duration", 15*time.Minute, "Minimum time a Node must be NotReady before deletion is considered.")
-	flag.BoolVar(&allowControlPlane, "allow-control-plane", false, "Allow deleting nodes labeled as control-plane/master/etcd.")
-	flag.DurationVar(&kamateraServerListInterval, "kamatera-server-list-interval", time.Minute, "Interval for polling Kamatera server list.")
-	flag.DurationVar(&nodeDeletePollInterval, "node-delete-poll-interval", time.Minute, "Interval for polling Kubernetes Nodes for deletion eligibility.")
-	flag.DurationVar(&snapshotsLogInterval, "snapshots-log-interval", time.Minute, "Interval for logging current node and Kamatera server snapshots.")
-	flag.StringVar(&kamateraServerDatacenters, "kamatera-server-datacenters", "", "Comma-separated Kamatera datacenters to include. Empty includes all datacenters.")
-	flag.StringVar(&kamateraServerNameGlob, "kamatera-server-name-glob", "", "Glob pattern for Kamatera server names. Empty includes all names.")
-	flag.StringVar(&nodeTrackedTaints, "node-tracked-taints", nodecontroller.DefaultTrackedTaintsCSV(), "Comma-separated node taint keys to track in node snapshots.")
-	flag.StringVar(&nodeTrackedAnnotations, "node-tracked-annotations", "", "Comma-separated node annotation keys to track in node snapshots.")
-	flag.StringVar(&matchNodeToServerTemplate, "match-node-to-server-template", "", "Template applied to Node name to produce matching Kamatera server name. Must contain exactly one %s. Mutually exclusive with --match-server-to-node-template.")
-	flag.StringVar(&matchServerToNodeTemplate, "match-server-to-node-template", "", "Template applied to Kamatera server name to produce matching Node name. Must contain exactly one %s. Mutually exclusive with --match-node-to-server-template.")
-
-	flag.Parse()
-
-	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&zapOpts)))
+	zapOpts.BindFlags(fs)
+
+	fs.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
+	fs.StringVar(&healthProbeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
+	fs.BoolVar(&enableLeaderElection, "leader-elect", false, "Enable leader election for controller manager.")
+	fs.StringVar(&leaderElectionID, "leader-election-id", "kamatera-rke2-controller.kamatera.io", "Leader election ID to use for the controller manager.")
+	fs.StringVar(&leaderElectionNamespace, "leader-election-namespace", "", "Namespace of the leader election lease. Empty uses the namespace the controller runs in, which must be set when running outside a cluster.")
+
+	fs.DurationVar(&notReadyDuration, "not-ready-duration", 15*time.Minute, "Minimum time a Node must be NotReady before deletion is considered.")
+	fs.BoolVar(&allowControlPlane, "allow-control-plane", false, "Allow deleting nodes labeled as control-plane/master/etcd.")
+	fs.DurationVar(&kamateraServerListInterval, "kamatera-server-list-interval", time.Minute, "Interval for polling Kamatera server list.")
+	fs.DurationVar(&nodeDeletePollInterval, "node-delete-poll-interval", time.Minute, "Interval for polling Kubernetes Nodes for deletion eligibility.")
+	fs.DurationVar(&snapshotsLogInterval, "snapshots-log-interval", time.Minute, "Interval for logging current node and Kamatera server snapshots.")
+	fs.StringVar(&kamateraServerDatacenters, "kamatera-server-datacenters", "", "Comma-separated Kamatera datacenters to include. Empty includes all datacenters.")
+	fs.StringVar(&kamateraServerNameGlob, "kamatera-server-name-glob", "", "Glob pattern for Kamatera server names. Empty includes all names.")
+	fs.StringVar(&nodeTrackedTaints, "node-tracked-taints", nodecontroller.DefaultTrackedTaintsCSV(), "Comma-separated node taint keys to track in node snapshots.")
+	fs.StringVar(&nodeTrackedAnnotations, "node-tracked-annotations", "", "Comma-separated node annotation keys to track in node snapshots.")
+	fs.StringVar(&matchNodeToServerTemplate, "match-node-to-server-template", "", "Template applied to Node name to produce matching Kamatera server name: a format with exactly one %s, or a Go template with the name as dot. Mutually exclusive with --match-server-to-node-template.")
+	fs.StringVar(&matchServerToNodeTemplate, "match-server-to-node-template", "", "Template applied to Kamatera server name to produce matching Node name: a format with exactly one %s, or a Go template with the name as dot. Mutually exclusive with --match-node-to-server-template.")
+	fs.StringVar(&matchRulesFile, "match-rules-file", "", "YAML file with ordered node/server name matching rules, each optionally scoped by node label selector and server datacenters. Mutually exclusive with the match templates.")
+	fs.StringVar(&matchStrategyValue, "match-strategy", "name", "How to match Nodes to Kamatera servers: name, ip (Node InternalIP/ExternalIP addresses shared with exactly one server) or name-then-ip.")
+	fs.BoolVar(&setProviderID, "set-provider-id", false, "Set spec.providerID to kamatera://<datacenter>/<server-id> on Nodes without a providerID once they are matched to a unique Kamatera server.")
+	fs.BoolVar(&syncNodeLabels, "sync-node-labels", false, "Set topology, instance type and kamatera.io labels on matched Nodes from their Kamatera server attributes.")
+	fs.BoolVar(&syncNodeAddresses, "sync-node-addresses", false, "Set status.addresses of matched Nodes from the IPs of their Kamatera server's networks.")
+	fs.StringVar(&externalNetworks, "external-networks", "wan*", "Comma-separated globs of Kamatera network names whose IPs are ExternalIP Node addresses.")
+	fs.StringVar(&cloudControllerManagerLease, "cloud-controller-manager-lease", "kube-system/cloud-controller-manager", "Namespace/name of the leader election Lease of a cloud-controller-manager. --sync-node-addresses does not run while it is held.")
+	fs.StringVar(&internalNetworks, "internal-networks", "*", "Comma-separated globs of Kamatera network names whose IPs are InternalIP Node addresses. Networks matching --external-networks are external.")
+	fs.BoolVar(&matchPreferProviderID, "match-prefer-provider-id", true, "Match Nodes with a kamatera:// providerID to Kamatera servers by server ID instead of by name.")
+
+	fs.StringVar(&remediationModeValue, "remediation-mode", "none", "Remediation for NotReady nodes whose Kamatera server is still powered on: none, reboot or power-cycle.")
+	fs.DurationVar(&remediationNotReadyDuration, "remediation-not-ready-duration", 10*time.Minute, "Minimum time a Node must be NotReady before its powered-on Kamatera server is remediated.")
+	fs.IntVar(&remediationMaxAttempts, "remediation-max-attempts", 2, "Maximum remediation attempts per NotReady node before falling back to deleting the Node.")
+	fs.DurationVar(&remediationCooldown, "remediation-cooldown", 15*time.Minute, "Minimum time between remediation attempts of a node, and before falling back to deletion after the last attempt.")
+	fs.BoolVar(&drainBeforeDelete, "drain-before-delete", false, "Cordon a Node and evict its pods before deleting it.")
+	fs.DurationVar(&drainTimeout, "drain-timeout", 5*time.Minute, "Maximum time to wait for pod evictions before deleting a Node anyway.")
+	fs.BoolVar(&dryRun, "dry-run", false, "Run all deletion checks but only log and record the Nodes that would be deleted. Would-be deletions are served as JSON on /dry-run/deletions of the metrics endpoint.")
+	fs.IntVar(&maxDeletionsPerWindow, "max-deletions-per-window", 0, "Maximum Node deletions within --deletion-window before all deletions are halted until the budget is reset. 0 disables the limit.")
+	fs.DurationVar(&deletionWindow, "deletion-window", 10*time.Minute, "Time window for the deletion budget limits.")
+	fs.IntVar(&maxDeletionPercent, "max-deletion-percent", 0, "Maximum percentage of matched Nodes deleted within --deletion-window before all deletions are halted until the budget is reset. 0 disables the limit.")
+	fs.StringVar(&deletionBudgetConfigMap, "deletion-budget-configmap", "", "Namespace/name of the ConfigMap the deletion budget state is persisted in and reset through. Empty keeps the state in memory only.")
+
+	fs.DurationVar(&maxServerSnapshotStaleness, "max-server-snapshot-staleness", 10*time.Minute, "Maximum age of the Kamatera server snapshot for Node remediation and deletions. Older snapshots block them and fail the readyz check. 0 disables the check.")
+	fs.StringVar(&absentServerPolicyValue, "absent-server-policy", "delete", "What to do with NotReady Nodes without a matching Kamatera server: delete, skip or require-consecutive.")
+	fs.IntVar(&absentServerConsecutivePolls, "absent-server-consecutive-polls", 3, "Consecutive Kamatera server list polls a Node's server must be absent from before deletion with --absent-server-policy=require-consecutive.")
+	fs.BoolVar(&allowEmptyServerList, "allow-empty-server-list", false, "Delete Nodes without a matching Kamatera server even when the filtered server list is empty.")
+	fs.IntVar(&maxServerListDropPercent, "max-server-list-drop-percent", 50, "Maximum percentage the filtered Kamatera server list may shrink between polls before it is treated as suspicious and held back. 0 disables the check.")
+	fs.IntVar(&serverListDropConfirmations, "server-list-drop-confirmations", 3, "Number of consecutive polls that must show a drop of more than --max-server-list-drop-percent before the dropped server list replaces the previous one.")
+	fs.Float64Var(&kamateraAPIQPS, "kamatera-api-qps", nodecontroller.DefaultKamateraAPIQPS, "Maximum sustained rate of Kamatera API requests per second, shared by all API calls of the Kamatera account. 0 disables the limit.")
+	fs.IntVar(&kamateraAPIBurst, "kamatera-api-burst", nodecontroller.DefaultKamateraAPIBurst, "Maximum Kamatera API requests sent at once above --kamatera-api-qps.")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&zapOpts), zap.WriteTo(logOutput)))
 	setupLog := ctrl.Log.WithName("setup")
 
 	if notReadyDuration <= 0 {
-		setupLog.Error(nil, "--not-ready-duration must be greater than 0")
-		os.Exit(1)
+		return setupError(setupLog, nil, "--not-ready-duration must be greater than 0")
 	}
 	if kamateraServerListInterval <= 0 {
-		setupLog.Error(nil, "--kamatera-server-list-interval must be greater than 0")
-		os.Exit(1)
+		return setupError(setupLog, nil, "--kamatera-server-list-interval must be greater than 0")
 	}
 	if nodeDeletePollInterval <= 0 {
-		setupLog.Error(nil, "--node-delete-poll-interval must be greater than 0")
-		os.Exit(1)
+		return setupError(setupLog, nil, "--node-delete-poll-interval must be greater than 0")
 	}
 	if snapshotsLogInterval <= 0 {
-		setupLog.Error(nil, "--snapshots-log-interval must be greater than 0")
-		os.Exit(1)
+		return setupError(setupLog, nil, "--snapshots-log-interval must be greater than 0")
+	}
+	remediationMode, err := nodecontroller.ParseRemediationMode(remediationModeValue)
+	if err != nil {
+		return setupError(setupLog, err, "invalid --remediation-mode")
+	}
+	if remediationNotReadyDuration <= 0 {
+		return setupError(setupLog, nil, "--remediation-not-ready-duration must be greater than 0")
+	}
+	if remediationMaxAttempts <= 0 {
+		return setupError(setupLog, nil, "--remediation-max-attempts must be greater than 0")
+	}
+	if remediationCooldown <= 0 {
+		return setupError(setupLog, nil, "--remediation-cooldown must be greater than 0")
+	}
+	if drainTimeout <= 0 {
+		return setupError(setupLog, nil, "--drain-timeout must be greater than 0")
+	}
+	if kamateraAPIQPS < 0 {
+		return setupError(setupLog, nil, "--kamatera-api-qps must not be negative")
+	}
+	if kamateraAPIBurst <= 0 {
+		return setupError(setupLog, nil, "--kamatera-api-burst must be greater than 0")
+	}
+	if maxDeletionsPerWindow < 0 {
+		return setupError(setupLog, nil, "--max-deletions-per-window must not be negative")
+	}
+	if deletionWindow <= 0 {
+		return setupError(setupLog, nil, "--deletion-window must be greater than 0")
+	}
+	if maxDeletionPercent < 0 || maxDeletionPercent > 100 {
+		return setupError(setupLog, nil, "--max-deletion-percent must be between 0 and 100")
+	}
+	var deletionBudgetConfigMapName types.NamespacedName
+	if deletionBudgetConfigMap != "" {
+		var ok bool
+		if deletionBudgetConfigMapName, ok = parseNamespacedName(deletionBudgetConfigMap); !ok {
+			return setupError(setupLog, nil, "--deletion-budget-configmap must be of the form <namespace>/<name>")
+		}
+	}
+	cloudControllerManagerLeaseName, ok := parseNamespacedName(cloudControllerManagerLease)
+	if !ok {
+		return setupError(setupLog, nil, "--cloud-controller-manager-lease must be of the form <namespace>/<name>")
+	}
+	if maxServerSnapshotStaleness < 0 {
+		return setupError(setupLog, nil, "--max-server-snapshot-staleness must not be negative")
+	}
+	if maxServerSnapshotStaleness > 0 && maxServerSnapshotStaleness < kamateraServerListInterval {
+		return setupError(setupLog, nil, "--max-server-snapshot-staleness must not be shorter than --kamatera-server-list-interval")
+	}
+	absentServerPolicy, err := nodecontroller.ParseAbsentServerPolicy(absentServerPolicyValue)
+	if err != nil {
+		return setupError(setupLog, err, "invalid --absent-server-policy")
+	}
+	if absentServerConsecutivePolls <= 0 {
+		return setupError(setupLog, nil, "--absent-server-consecutive-polls must be greater than 0")
+	}
+	if maxServerListDropPercent < 0 || maxServerListDropPercent > 100 {
+		return setupError(setupLog, nil, "--max-server-list-drop-percent must be between 0 and 100")
+	}
+	if serverListDropConfirmations <= 0 {
+		return setupError(setupLog, nil, "--server-list-drop-confirmations must be greater than 0")
 	}
 	serverFilter, err := nodecontroller.NewServerFilter(kamateraServerDatacenters, kamateraServerNameGlob)
 	if err != nil {
-		setupLog.Error(err, "invalid --kamatera-server-name-glob")
-		os.Exit(1)
+		return setupError(setupLog, err, "invalid --kamatera-server-name-glob")
 	}
-	matcher, err := nodecontroller.NewNameMatcher(matchNodeToServerTemplate, matchServerToNodeTemplate)
+	var matcher nodecontroller.NameMatcher
+	if matchRulesFile != "" {
+		if matchNodeToServerTemplate != "" || matchServerToNodeTemplate != "" {
+			return setupError(setupLog, nil, "--match-rules-file is mutually exclusive with --match-node-to-server-template and --match-server-to-node-template")
+		}
+		rules, err := nodecontroller.LoadMatchRules(matchRulesFile)
+		if err != nil {
+			return setupError(setupLog, err, "invalid --match-rules-file")
+		}
+		matcher, err = nodecontroller.NewNameMatcherFromRules(rules)
+		if err != nil {
+			return setupError(setupLog, err, "invalid --match-rules-file")
+		}
+	} else {
+		matcher, err = nodecontroller.NewNameMatcher(matchNodeToServerTemplate, matchServerToNodeTemplate)
+		if err != nil {
+			return setupError(setupLog, err, "invalid node/server matching configuration")
+		}
+	}
+	matchStrategy, err := nodecontroller.ParseMatchStrategy(matchStrategyValue)
 	if err != nil {
-		setupLog.Error(err, "invalid node/server matching configuration")
-		os.Exit(1)
+		return setupError(setupLog, err, "invalid --match-strategy")
+	}
+	matcher = matcher.WithStrategy(matchStrategy).WithPreferProviderID(matchPreferProviderID)
+	addressRules, err := nodecontroller.NewNetworkAddressRules(externalNetworks, internalNetworks)
+	if err != nil {
+		return setupError(setupLog, err, "invalid --external-networks or --internal-networks")
+	}
+
+	deletionCandidates := nodecontroller.NewDeletionCandidateStore()
+	deletionBudget := &nodecontroller.DeletionBudget{
+		MaxDeletions: maxDeletionsPerWindow,
+		Window:       deletionWindow,
+		MaxPercent:   maxDeletionPercent,
+		Log:          ctrl.Log.WithName("controllers").WithName("DeletionBudget"),
+	}
+	metricsExtraHandlers := map[string]http.Handler{
+		"/deletion-budget": deletionBudget,
+	}
+	if dryRun {
+		setupLog.Info("dry-run mode enabled, nodes will not be remediated, drained or deleted")
+		metricsExtraHandlers["/dry-run/deletions"] = deletionCandidates
 	}
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	if restConfig == nil {
+		var err error
+		if restConfig, err = ctrl.GetConfig(); err != nil {
+			return setupError(setupLog, err, "unable to load kubeconfig")
+		}
+	}
+	mgr, err := ctrl.NewManager(restConfig, ctrl.Options{
 		Scheme: scheme,
 		Metrics: metricsserver.Options{
-			BindAddress: metricsAddr,
+			BindAddress:   metricsAddr,
+			ExtraHandlers: metricsExtraHandlers,
 		},
-		HealthProbeBindAddress: healthProbeAddr,
-		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       leaderElectionID,
+		HealthProbeBindAddress:  healthProbeAddr,
+		LeaderElection:          enableLeaderElection,
+		LeaderElectionID:        leaderElectionID,
+		LeaderElectionNamespace: leaderElectionNamespace,
 	})
 	if err != nil {
-		setupLog.Error(err, "unable to start manager")
-		os.Exit(1)
+		return setupError(setupLog, err, "unable to start manager")
+	}
+
+	if deletionBudgetConfigMapName.Name != "" {
+		deletionBudget.Client = mgr.GetClient()
+		deletionBudget.Reader = mgr.GetAPIReader()
+		deletionBudget.ConfigMap = deletionBudgetConfigMapName
 	}
 
+	// The server store stamps refreshes with the clock the deletion controller
+	// checks their freshness against.
+	clock := time.Now
 	serverStore := nodecontroller.NewServerStateStore()
+	serverStore.Now = clock
 	nodeStore := nodecontroller.NewNodeStateStore()
 	kamateraApiUrl := os.Getenv("KAMATERA_API_URL")
 	if kamateraApiUrl == "" {
@@ -115,19 +306,24 @@ func main() {
 		os.Getenv("KAMATERA_API_CLIENT_ID"),
 		os.Getenv("KAMATERA_API_SECRET"),
 		kamateraApiUrl,
+		nodecontroller.KamateraAPIRateLimit{QPS: kamateraAPIQPS, Burst: kamateraAPIBurst},
 	)
 
+	recorder := mgr.GetEventRecorderFor("kamatera-rke2-controller")
 	if err := mgr.Add(&nodecontroller.KamateraServersController{
-		Client:    kamateraClient,
-		Store:     serverStore,
-		NodeStore: nodeStore,
-		Matcher:   matcher,
-		Filter:    serverFilter,
-		Interval:  kamateraServerListInterval,
-		Log:       ctrl.Log.WithName("controllers").WithName("KamateraServers"),
+		Client:            kamateraClient,
+		Store:             serverStore,
+		NodeStore:         nodeStore,
+		Matcher:           matcher,
+		Filter:            serverFilter,
+		Interval:          kamateraServerListInterval,
+		AllowEmptyList:    allowEmptyServerList,
+		MaxDropPercent:    maxServerListDropPercent,
+		DropConfirmations: serverListDropConfirmations,
+		Recorder:          recorder,
+		Log:               ctrl.Log.WithName("controllers").WithName("KamateraServers"),
 	}); err != nil {
-		setupLog.Error(err, "unable to add controller", "controller", "KamateraServers")
-		os.Exit(1)
+		return setupError(setupLog, err, "unable to add controller", "controller", "KamateraServers")
 	}
 
 	if err := (&nodecontroller.NodeListReconciler{
@@ -139,8 +335,17 @@ func main() {
 		TrackedAnnotations: nodecontroller.ParseTrackedKeys(nodeTrackedAnnotations),
 		Log:                ctrl.Log.WithName("controllers").WithName("NodeList"),
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "NodeList")
-		os.Exit(1)
+		return setupError(setupLog, err, "unable to create controller", "controller", "NodeList")
+	}
+
+	var drainer *nodecontroller.NodeDrainer
+	if drainBeforeDelete {
+		drainer = &nodecontroller.NodeDrainer{
+			Client:   mgr.GetClient(),
+			Reader:   mgr.GetAPIReader(),
+			Timeout:  drainTimeout,
+			Recorder: recorder,
+		}
 	}
 
 	if err := mgr.Add(&nodecontroller.NodeDeletePoller{
@@ -150,16 +355,88 @@ func main() {
 			Client:            mgr.GetClient(),
 			NotReadyDuration:  notReadyDuration,
 			AllowControlPlane: allowControlPlane,
+			Now:               clock,
 			Log:               ctrl.Log.WithName("controllers").WithName("NodeDelete"),
 			ServerStore:       serverStore,
 			Matcher:           matcher,
+			Remediator: &nodecontroller.NodeRemediator{
+				Client:           kamateraClient,
+				Mode:             remediationMode,
+				NotReadyDuration: remediationNotReadyDuration,
+				MaxAttempts:      remediationMaxAttempts,
+				Cooldown:         remediationCooldown,
+			},
+			Drainer:                      drainer,
+			Recorder:                     recorder,
+			DryRun:                       dryRun,
+			DeletionCandidates:           deletionCandidates,
+			Budget:                       deletionBudget,
+			MaxServerStaleness:           maxServerSnapshotStaleness,
+			AbsentServerPolicy:           absentServerPolicy,
+			AbsentServerConsecutivePolls: absentServerConsecutivePolls,
 		},
 		Log: ctrl.Log.WithName("controllers").WithName("NodeDeletePoller"),
 	}); err != nil {
-		setupLog.Error(err, "unable to add controller", "controller", "NodeDeletePoller")
-		os.Exit(1)
+		return setupError(setupLog, err, "unable to add controller", "controller", "NodeDeletePoller")
+	}
+
+	if setProviderID {
+		if err := mgr.Add(&nodecontroller.NodeProviderIDSyncer{
+			Client:      mgr.GetClient(),
+			NodeStore:   nodeStore,
+			ServerStore: serverStore,
+			Matcher:     matcher,
+			Interval:    kamateraServerListInterval,
+			Recorder:    recorder,
+			Log:         ctrl.Log.WithName("controllers").WithName("NodeProviderID"),
+		}); err != nil {
+			return setupError(setupLog, err, "unable to add controller", "controller", "NodeProviderID")
+		}
+	}
+
+	if syncNodeLabels {
+		if err := mgr.Add(&nodecontroller.NodeLabeler{
+			Client:      mgr.GetClient(),
+			NodeStore:   nodeStore,
+			ServerStore: serverStore,
+			Matcher:     matcher,
+			Interval:    kamateraServerListInterval,
+			Recorder:    recorder,
+			Log:         ctrl.Log.WithName("controllers").WithName("NodeLabeler"),
+		}); err != nil {
+			return setupError(setupLog, err, "unable to add controller", "controller", "NodeLabeler")
+		}
 	}
 
+	if syncNodeAddresses {
+		if err := mgr.Add(&nodecontroller.NodeAddressSyncer{
+			Client:                      mgr.GetClient(),
+			Reader:                      mgr.GetAPIReader(),
+			CloudControllerManagerLease: cloudControllerManagerLeaseName,
+			NodeStore:                   nodeStore,
+			ServerStore:                 serverStore,
+			Matcher:                     matcher,
+			Rules:                       addressRules,
+			Interval:                    kamateraServerListInterval,
+			Recorder:                    recorder,
+			Now:                         clock,
+			Log:                         ctrl.Log.WithName("controllers").WithName("NodeAddress"),
+		}); err != nil {
+			return setupError(setupLog, err, "unable to add controller", "controller", "NodeAddress")
+		}
+	}
+
+	stateMetrics := &nodecontroller.StateMetricsCollector{
+		ServerStore: serverStore,
+		NodeStore:   nodeStore,
+		Matcher:     matcher,
+		Now:         clock,
+	}
+	if err := ctrlmetrics.Registry.Register(stateMetrics); err != nil {
+		return setupError(setupLog, err, "unable to register metrics")
+	}
+	defer ctrlmetrics.Registry.Unregister(stateMetrics)
+
 	if err := mgr.Add(&nodecontroller.SnapshotLogger{
 		ServerStore: serverStore,
 		NodeStore:   nodeStore,
@@ -167,22 +444,40 @@ func main() {
 		Interval:    snapshotsLogInterval,
 		Log:         ctrl.Log.WithName("controllers").WithName("SnapshotLogger"),
 	}); err != nil {
-		setupLog.Error(err, "unable to add controller", "controller", "SnapshotLogger")
-		os.Exit(1)
+		return setupError(setupLog, err, "unable to add controller", "controller", "SnapshotLogger")
 	}
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
-		setupLog.Error(err, "unable to set up health check")
-		os.Exit(1)
+		return setupError(setupLog, err, "unable to set up health check")
 	}
 	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
-		setupLog.Error(err, "unable to set up ready check")
-		os.Exit(1)
+		return setupError(setupLog, err, "unable to set up ready check")
+	}
+	if err := mgr.AddReadyzCheck("server-snapshot", serverStore.ReadyCheck(maxServerSnapshotStaleness)); err != nil {
+		return setupError(setupLog, err, "unable to set up ready check")
 	}
 
 	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
-		setupLog.Error(err, "problem running manager")
-		os.Exit(1)
+	if err := mgr.Start(ctx); err != nil {
+		return setupError(setupLog, err, "problem running manager")
+	}
+	return nil
+}
+
+// setupError logs a setup failure and returns it as an error.
+func setupError(log logr.Logger, err error, msg string, keysAndValues ...interface{}) error {
+	log.Error(err, msg, keysAndValues...)
+	if err == nil {
+		return errors.New(msg)
+	}
+	return fmt.Errorf("%s: %w", msg, err)
+}
+
+// parseNamespacedName parses a <namespace>/<name> flag value.
+func parseNamespacedName(value string) (types.NamespacedName, bool) {
+	namespace, name, ok := strings.Cut(value, "/")
+	if !ok || namespace == "" || name == "" || strings.Contains(name, "/") {
+		return types.NamespacedName{}, false
 	}
+	return types.NamespacedName{Namespace: namespace, Name: name}, true
 }
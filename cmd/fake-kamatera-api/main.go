@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+
+	"github.com/kamatera/kamatera-rke2-controller/internal/kamaterafake"
+)
+
+func main() {
+	var bindAddress string
+	var serversFile string
+	var latency time.Duration
+	var commandDuration time.Duration
+	klog.InitFlags(nil)
+	flag.StringVar(&bindAddress, "bind-address", ":8080", "The address the fake Kamatera API binds to.")
+	flag.StringVar(&serversFile, "servers-file", "", "YAML or JSON file with the initial list of servers, in the format of the Kamatera servers list.")
+	flag.DurationVar(&latency, "latency", 0, "Delay of every API response.")
+	flag.DurationVar(&commandDuration, "command-duration", 5*time.Second, "How long power and terminate commands run before they complete.")
+	flag.Parse()
+
+	var servers []kamaterafake.Server
+	if serversFile != "" {
+		data, err := os.ReadFile(serversFile)
+		if err != nil {
+			klog.Fatalf("unable to read servers file: %v", err)
+		}
+		if err := yaml.UnmarshalStrict(data, &servers); err != nil {
+			klog.Fatalf("unable to parse servers file %s: %v", serversFile, err)
+		}
+	}
+	api := kamaterafake.New(servers...)
+	api.SetLatency(latency)
+	api.SetCommandDuration(commandDuration)
+	api.SetCredentials(os.Getenv("KAMATERA_API_CLIENT_ID"), os.Getenv("KAMATERA_API_SECRET"))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	server := &http.Server{Addr: bindAddress, Handler: api, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	klog.Infof("serving fake Kamatera API with %d servers on %s", len(servers), bindAddress)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		klog.Fatalf("unable to serve fake Kamatera API: %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const defaultAbsentServerConsecutivePolls = 3
+
+// AbsentServerPolicy decides what happens to a NotReady Node when no matching
+// Kamatera server is found in the server snapshot.
+type AbsentServerPolicy string
+
+const (
+	// AbsentServerPolicyDelete deletes the Node as soon as it is eligible.
+	AbsentServerPolicyDelete AbsentServerPolicy = "delete"
+	// AbsentServerPolicySkip never deletes Nodes without a matching server.
+	AbsentServerPolicySkip AbsentServerPolicy = "skip"
+	// AbsentServerPolicyRequireConsecutive deletes the Node only once its
+	// server was absent from a number of consecutive server list polls.
+	AbsentServerPolicyRequireConsecutive AbsentServerPolicy = "require-consecutive"
+)
+
+func ParseAbsentServerPolicy(value string) (AbsentServerPolicy, error) {
+	switch policy := AbsentServerPolicy(strings.TrimSpace(value)); policy {
+	case "", AbsentServerPolicyDelete:
+		return AbsentServerPolicyDelete, nil
+	case AbsentServerPolicySkip, AbsentServerPolicyRequireConsecutive:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unsupported absent server policy %q, must be one of delete, skip, require-consecutive", value)
+	}
+}
+
+// absentServerTracker remembers the server snapshot generation in which a
+// node was first seen without a matching server.
+type absentServerTracker struct {
+	mu          sync.Mutex
+	firstAbsent map[string]uint64
+}
+
+// observe records that the node has no matching server in the given snapshot
+// generation and returns the number of consecutive polls it has been absent.
+func (t *absentServerTracker) observe(nodeName string, generation uint64) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.firstAbsent == nil {
+		t.firstAbsent = map[string]uint64{}
+	}
+	first, ok := t.firstAbsent[nodeName]
+	if !ok || first > generation {
+		first = generation
+		t.firstAbsent[nodeName] = first
+	}
+	return generation - first + 1
+}
+
+func (t *absentServerTracker) forget(nodeName string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.firstAbsent, nodeName)
+}
+
+func (t *absentServerTracker) retain(nodeNames []string) {
+	keep := make(map[string]struct{}, len(nodeNames))
+	for _, name := range nodeNames {
+		keep[name] = struct{}{}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for name := range t.firstAbsent {
+		if _, ok := keep[name]; !ok {
+			delete(t.firstAbsent, name)
+		}
+	}
+}
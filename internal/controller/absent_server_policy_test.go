@@ -0,0 +1,214 @@
+package controller
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newAbsentServerTestReconciler(t *testing.T, serverStore *ServerStateStore, policy AbsentServerPolicy) (*NodeReconciler, client.Client, *record.FakeRecorder) {
+	t.Helper()
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker1"}}
+	node.Status.Conditions = []corev1.NodeCondition{{
+		Type:               corev1.NodeReady,
+		Status:             corev1.ConditionFalse,
+		LastTransitionTime: metav1.NewTime(now.Add(-20 * time.Minute)),
+	}}
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(node).Build()
+	recorder := record.NewFakeRecorder(10)
+	return &NodeReconciler{
+		Client:                       c,
+		NotReadyDuration:             15 * time.Minute,
+		Now:                          func() time.Time { return now },
+		Log:                          logr.Discard(),
+		ServerStore:                  serverStore,
+		AbsentServerPolicy:           policy,
+		AbsentServerConsecutivePolls: 2,
+		Recorder:                     recorder,
+	}, c, recorder
+}
+
+func reconcileWorker1(t *testing.T, r *NodeReconciler, c client.Client) bool {
+	t.Helper()
+	if err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: "worker1"}}); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	var got corev1.Node
+	err := c.Get(context.Background(), types.NamespacedName{Name: "worker1"}, &got)
+	if err != nil && !apierrors.IsNotFound(err) {
+		t.Fatalf("get node: %v", err)
+	}
+	return apierrors.IsNotFound(err)
+}
+
+func TestParseAbsentServerPolicy(t *testing.T) {
+	for value, expected := range map[string]AbsentServerPolicy{
+		"":                    AbsentServerPolicyDelete,
+		"delete":              AbsentServerPolicyDelete,
+		"skip":                AbsentServerPolicySkip,
+		"require-consecutive": AbsentServerPolicyRequireConsecutive,
+	} {
+		policy, err := ParseAbsentServerPolicy(value)
+		if err != nil || policy != expected {
+			t.Fatalf("parse %q: expected %q, got %q err=%v", value, expected, policy, err)
+		}
+	}
+	if _, err := ParseAbsentServerPolicy("sometimes"); err == nil {
+		t.Fatalf("expected unsupported policy to be rejected")
+	}
+}
+
+func TestNodeReconciler_AbsentServerPolicySkipDoesNotDelete(t *testing.T) {
+	serverStore := NewServerStateStore()
+	serverStore.Replace([]KamateraServer{{Name: "other", Datacenter: "EU", Power: "on"}})
+	r, c, recorder := newAbsentServerTestReconciler(t, serverStore, AbsentServerPolicySkip)
+
+	if reconcileWorker1(t, r, c) {
+		t.Fatalf("expected node without server not to be deleted with skip policy")
+	}
+	expected := []string{"Normal DeletionSkipped Not deleting NotReady node without a matching Kamatera server"}
+	if events := recordedEvents(recorder); !reflect.DeepEqual(events, expected) {
+		t.Fatalf("expected events %v, got %v", expected, events)
+	}
+}
+
+func TestNodeReconciler_AbsentServerPolicyRequiresConsecutivePolls(t *testing.T) {
+	serverStore := NewServerStateStore()
+	serverStore.Replace([]KamateraServer{{Name: "other", Datacenter: "EU", Power: "on"}})
+	r, c, _ := newAbsentServerTestReconciler(t, serverStore, AbsentServerPolicyRequireConsecutive)
+
+	if reconcileWorker1(t, r, c) {
+		t.Fatalf("expected node not to be deleted after the first absent poll")
+	}
+	if reconcileWorker1(t, r, c) {
+		t.Fatalf("expected node not to be deleted again within the same poll")
+	}
+	serverStore.Replace([]KamateraServer{{Name: "other", Datacenter: "EU", Power: "on"}})
+	if !reconcileWorker1(t, r, c) {
+		t.Fatalf("expected node to be deleted after two consecutive absent polls")
+	}
+}
+
+func TestNodeReconciler_AbsentServerCountResetsWhenServerReappears(t *testing.T) {
+	serverStore := NewServerStateStore()
+	serverStore.Replace(nil)
+	r, c, _ := newAbsentServerTestReconciler(t, serverStore, AbsentServerPolicyRequireConsecutive)
+
+	if reconcileWorker1(t, r, c) {
+		t.Fatalf("expected node not to be deleted after the first absent poll")
+	}
+	serverStore.Replace([]KamateraServer{{Name: "worker1", Datacenter: "EU", Power: "on"}})
+	if reconcileWorker1(t, r, c) {
+		t.Fatalf("expected node with a running server not to be deleted")
+	}
+	serverStore.Replace(nil)
+	if reconcileWorker1(t, r, c) {
+		t.Fatalf("expected absent count to restart after the server reappeared")
+	}
+}
+
+func TestNodeReconciler_DoesNotDeleteAbsentServerNodeWhenServerListIsSuspicious(t *testing.T) {
+	serverStore := NewServerStateStore()
+	serverStore.ReplaceSuspicious(nil, "the filtered Kamatera server list is empty")
+	r, c, recorder := newAbsentServerTestReconciler(t, serverStore, AbsentServerPolicyDelete)
+
+	if reconcileWorker1(t, r, c) {
+		t.Fatalf("expected node not to be deleted while the server list is suspicious")
+	}
+	expected := []string{"Warning DeletionSkipped Not deleting NotReady node without a matching Kamatera server because the filtered Kamatera server list is empty"}
+	if events := recordedEvents(recorder); !reflect.DeepEqual(events, expected) {
+		t.Fatalf("expected events %v, got %v", expected, events)
+	}
+}
+
+func TestNodeReconciler_DeletesPoweredOffServerNodeWhenServerListIsSuspicious(t *testing.T) {
+	serverStore := NewServerStateStore()
+	serverStore.ReplaceSuspicious([]KamateraServer{{Name: "worker1", Datacenter: "EU", Power: "off"}}, "the filtered Kamatera server list dropped from 4 to 1 servers")
+	r, c, _ := newAbsentServerTestReconciler(t, serverStore, AbsentServerPolicySkip)
+
+	if !reconcileWorker1(t, r, c) {
+		t.Fatalf("expected node with a powered off server to be deleted")
+	}
+}
+
+func TestNodeDeletePoller_AbsentServerCountRestartsWhenNodeIsRecreated(t *testing.T) {
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	newNode := func(uid types.UID) *corev1.Node {
+		node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker1", UID: uid}}
+		node.Status.Conditions = []corev1.NodeCondition{{
+			Type:               corev1.NodeReady,
+			Status:             corev1.ConditionFalse,
+			LastTransitionTime: metav1.NewTime(now.Add(-20 * time.Minute)),
+		}}
+		return node
+	}
+	node := newNode("uid-1")
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(node).Build()
+	nodeStore := NewNodeStateStore()
+	nodeStore.Replace(NewNodeSnapshot(node, nil, nil))
+	serverStore := NewServerStateStore()
+	poller := &NodeDeletePoller{
+		NodeStore: nodeStore,
+		Reconciler: &NodeReconciler{
+			Client:                       c,
+			NotReadyDuration:             15 * time.Minute,
+			Now:                          func() time.Time { return now },
+			Log:                          logr.Discard(),
+			ServerStore:                  serverStore,
+			AbsentServerPolicy:           AbsentServerPolicyRequireConsecutive,
+			AbsentServerConsecutivePolls: 3,
+		},
+		Log: logr.Discard(),
+	}
+	poll := func() bool {
+		t.Helper()
+		serverStore.Replace([]KamateraServer{{Name: "other", Datacenter: "EU", Power: "on"}})
+		if err := poller.poll(context.Background()); err != nil {
+			t.Fatalf("poll: %v", err)
+		}
+		var got corev1.Node
+		err := c.Get(context.Background(), types.NamespacedName{Name: "worker1"}, &got)
+		if err != nil && !apierrors.IsNotFound(err) {
+			t.Fatalf("get node: %v", err)
+		}
+		return apierrors.IsNotFound(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if poll() {
+			t.Fatalf("expected node not to be deleted after %d absent polls", i+1)
+		}
+	}
+
+	// The node is deleted outside the controller and rejoins under the same
+	// name before the next poll.
+	if err := c.Delete(context.Background(), node); err != nil {
+		t.Fatalf("delete node: %v", err)
+	}
+	recreated := newNode("uid-2")
+	if err := c.Create(context.Background(), recreated); err != nil {
+		t.Fatalf("recreate node: %v", err)
+	}
+	nodeStore.Replace(NewNodeSnapshot(recreated, nil, nil))
+
+	for i := 0; i < 2; i++ {
+		if poll() {
+			t.Fatalf("expected recreated node not to be deleted after %d absent polls", i+1)
+		}
+	}
+	if !poll() {
+		t.Fatalf("expected recreated node to be deleted after 3 consecutive absent polls")
+	}
+}
@@ -0,0 +1,329 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	defaultDeletionWindow = 10 * time.Minute
+
+	// DeletionBudgetResetAnnotation resets the deletion budget when it is set
+	// on the deletion budget ConfigMap to a value that was not handled before,
+	// e.g. the current time.
+	DeletionBudgetResetAnnotation = "kamatera.io/deletion-budget-reset"
+	// deletionBudgetStateKey is the ConfigMap data key of the persisted state.
+	deletionBudgetStateKey = "state"
+)
+
+// DeletionBudget is a safety limit on Node deletions. It allows at most
+// MaxDeletions deletions per Window and at most MaxPercent percent of the
+// matched Nodes per Window; a zero limit disables that check.
+//
+// Exceeding a limit trips a circuit breaker that halts all further deletions
+// until Reset is called, so an outage that powers off many Kamatera servers at
+// once cannot remove a large part of the cluster.
+//
+// When a Client and ConfigMap are configured, Sync persists the recent
+// deletions and the circuit breaker in the ConfigMap, loads them on the first
+// call so they survive restarts, and resets the budget when an operator sets
+// DeletionBudgetResetAnnotation to a new value. Until the state is loaded, all
+// deletions are refused. Reserve persists each deletion before it is made.
+type DeletionBudget struct {
+	MaxDeletions int
+	Window       time.Duration
+	MaxPercent   int
+
+	Client client.Client
+	// Reader is used to get the ConfigMap. It should be an uncached reader so
+	// the manager does not cache all ConfigMaps in the cluster. Defaults to
+	// Client.
+	Reader    client.Reader
+	ConfigMap types.NamespacedName
+
+	Log logr.Logger
+
+	mu           sync.Mutex
+	deletions    []time.Time
+	matchedNodes int
+	tripped      bool
+	trippedAt    time.Time
+	reason       string
+	loaded       bool
+	handledReset string
+}
+
+// deletionBudgetState is the state of a DeletionBudget persisted in its
+// ConfigMap.
+type deletionBudgetState struct {
+	Tripped   bool        `json:"tripped"`
+	TrippedAt time.Time   `json:"trippedAt,omitzero"`
+	Reason    string      `json:"reason,omitempty"`
+	Deletions []time.Time `json:"deletions,omitempty"`
+	// HandledReset is the last value of DeletionBudgetResetAnnotation that
+	// reset the budget.
+	HandledReset string `json:"handledReset,omitempty"`
+}
+
+// DeletionBudgetStatus is the JSON representation of the budget state.
+type DeletionBudgetStatus struct {
+	Tripped         bool      `json:"tripped"`
+	TrippedAt       time.Time `json:"trippedAt,omitempty"`
+	Reason          string    `json:"reason,omitempty"`
+	RecentDeletions int       `json:"recentDeletions"`
+	MatchedNodes    int       `json:"matchedNodes"`
+	MaxDeletions    int       `json:"maxDeletions"`
+	Window          string    `json:"window"`
+	MaxPercent      int       `json:"maxPercent"`
+}
+
+func (b *DeletionBudget) window() time.Duration {
+	if b.Window <= 0 {
+		return defaultDeletionWindow
+	}
+	return b.Window
+}
+
+// SetMatchedNodes updates the number of Nodes matched to a Kamatera server,
+// which MaxPercent is relative to.
+func (b *DeletionBudget) SetMatchedNodes(count int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.matchedNodes = count
+}
+
+// Allow returns whether one more deletion fits in the budget. When it does
+// not, the circuit breaker is tripped and the reason is returned.
+func (b *DeletionBudget) Allow(now time.Time) (bool, string) {
+	if b == nil {
+		return true, ""
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.allowLocked(now)
+}
+
+func (b *DeletionBudget) allowLocked(now time.Time) (bool, string) {
+	if b.persisted() && !b.loaded {
+		return false, "the deletion budget state was not loaded yet"
+	}
+	if b.tripped {
+		return false, b.reason
+	}
+	recent := b.pruneLocked(now)
+	if b.MaxDeletions > 0 && recent+1 > b.MaxDeletions {
+		b.tripLocked(now, fmt.Sprintf("more than %d node deletions within %s", b.MaxDeletions, b.window()))
+		return false, b.reason
+	}
+	if b.MaxPercent > 0 && b.matchedNodes > 0 && (recent+1)*100 > b.MaxPercent*b.matchedNodes {
+		b.tripLocked(now, fmt.Sprintf("more than %d%% of %d matched nodes deleted within %s", b.MaxPercent, b.matchedNodes, b.window()))
+		return false, b.reason
+	}
+	return true, ""
+}
+
+// Reserve counts a deletion against the budget before it happens, like Allow
+// when it does not fit. A persisted budget writes the reservation to its
+// ConfigMap before returning, so a deletion is never made without being
+// recorded; when that fails, the reservation is dropped and the error is
+// returned, and the deletion must not be made. Release returns the
+// reservation of a deletion that did not happen.
+func (b *DeletionBudget) Reserve(ctx context.Context, now time.Time) (bool, string, error) {
+	if b == nil {
+		return true, "", nil
+	}
+	b.mu.Lock()
+	if allowed, reason := b.allowLocked(now); !allowed {
+		b.mu.Unlock()
+		return false, reason, nil
+	}
+	b.deletions = append(b.deletions, now)
+	b.mu.Unlock()
+
+	if err := b.Sync(ctx, now); err != nil {
+		b.Release(now)
+		return false, "", err
+	}
+	return true, "", nil
+}
+
+// Release returns a reservation made by Reserve at now. It is persisted by
+// the next Sync; until then, the persisted budget errs on the side of the
+// reservation.
+func (b *DeletionBudget) Release(now time.Time) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := len(b.deletions) - 1; i >= 0; i-- {
+		if b.deletions[i].Equal(now) {
+			b.deletions = append(b.deletions[:i], b.deletions[i+1:]...)
+			return
+		}
+	}
+}
+
+// Reset closes the circuit breaker and clears the deletion history.
+func (b *DeletionBudget) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.resetLocked()
+}
+
+func (b *DeletionBudget) resetLocked() {
+	if b.tripped {
+		b.Log.Info("deletion budget reset, node deletions resumed", "trippedAt", b.trippedAt, "reason", b.reason)
+	}
+	b.tripped = false
+	deletionBudgetTripped.Set(0)
+	b.trippedAt = time.Time{}
+	b.reason = ""
+	b.deletions = nil
+}
+
+func (b *DeletionBudget) Status(now time.Time) DeletionBudgetStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return DeletionBudgetStatus{
+		Tripped:         b.tripped,
+		TrippedAt:       b.trippedAt,
+		Reason:          b.reason,
+		RecentDeletions: b.pruneLocked(now),
+		MatchedNodes:    b.matchedNodes,
+		MaxDeletions:    b.MaxDeletions,
+		Window:          b.window().String(),
+		MaxPercent:      b.MaxPercent,
+	}
+}
+
+// ServeHTTP returns the budget status on GET. The budget is reset through its
+// ConfigMap, which is guarded by RBAC, not through this endpoint.
+func (b *DeletionBudget) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(b.Status(time.Now()))
+}
+
+func (b *DeletionBudget) persisted() bool {
+	return b.Client != nil && b.ConfigMap.Name != ""
+}
+
+// Sync loads the persisted state on the first call, resets the budget when
+// the reset annotation of the ConfigMap has a new value, and writes the
+// current state to the ConfigMap, creating it if needed.
+func (b *DeletionBudget) Sync(ctx context.Context, now time.Time) error {
+	if b == nil || !b.persisted() {
+		return nil
+	}
+	reader := b.Reader
+	if reader == nil {
+		reader = b.Client
+	}
+	var configMap corev1.ConfigMap
+	found := true
+	if err := reader.Get(ctx, b.ConfigMap, &configMap); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return fmt.Errorf("get deletion budget ConfigMap %s: %w", b.ConfigMap, err)
+		}
+		found = false
+	}
+
+	b.mu.Lock()
+	if !b.loaded {
+		if data := configMap.Data[deletionBudgetStateKey]; found && data != "" {
+			var state deletionBudgetState
+			if err := json.Unmarshal([]byte(data), &state); err != nil {
+				b.mu.Unlock()
+				return fmt.Errorf("invalid deletion budget state in ConfigMap %s: %w", b.ConfigMap, err)
+			}
+			b.loadLocked(state)
+		}
+		b.loaded = true
+	}
+	if reset := configMap.Annotations[DeletionBudgetResetAnnotation]; reset != "" && reset != b.handledReset {
+		b.resetLocked()
+		b.handledReset = reset
+	}
+	b.pruneLocked(now)
+	state := deletionBudgetState{
+		Tripped:      b.tripped,
+		TrippedAt:    b.trippedAt,
+		Reason:       b.reason,
+		Deletions:    append([]time.Time(nil), b.deletions...),
+		HandledReset: b.handledReset,
+	}
+	b.mu.Unlock()
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if found && configMap.Data[deletionBudgetStateKey] == string(data) {
+		return nil
+	}
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+	configMap.Data[deletionBudgetStateKey] = string(data)
+	if !found {
+		configMap.Namespace = b.ConfigMap.Namespace
+		configMap.Name = b.ConfigMap.Name
+		if err := b.Client.Create(ctx, &configMap); err != nil {
+			return fmt.Errorf("create deletion budget ConfigMap %s: %w", b.ConfigMap, err)
+		}
+		return nil
+	}
+	if err := b.Client.Update(ctx, &configMap); err != nil {
+		return fmt.Errorf("update deletion budget ConfigMap %s: %w", b.ConfigMap, err)
+	}
+	return nil
+}
+
+func (b *DeletionBudget) loadLocked(state deletionBudgetState) {
+	b.tripped = state.Tripped
+	b.trippedAt = state.TrippedAt
+	b.reason = state.Reason
+	b.deletions = state.Deletions
+	b.handledReset = state.HandledReset
+	if b.tripped {
+		deletionBudgetTripped.Set(1)
+		b.Log.Error(nil, "deletion budget is tripped, node deletions stay halted until the budget is reset", "trippedAt", b.trippedAt, "reason", b.reason)
+	}
+}
+
+func (b *DeletionBudget) pruneLocked(now time.Time) int {
+	cutoff := now.Add(-b.window())
+	kept := b.deletions[:0]
+	for _, deletedAt := range b.deletions {
+		if deletedAt.After(cutoff) {
+			kept = append(kept, deletedAt)
+		}
+	}
+	b.deletions = kept
+	return len(kept)
+}
+
+func (b *DeletionBudget) tripLocked(now time.Time, reason string) {
+	b.tripped = true
+	deletionBudgetTripped.Set(1)
+	b.trippedAt = now
+	b.reason = reason
+	b.Log.Error(nil, "deletion budget exceeded, halting all node deletions until the budget is reset", "reason", reason, "recentDeletions", len(b.deletions), "matchedNodes", b.matchedNodes)
+}
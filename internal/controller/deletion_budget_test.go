@@ -0,0 +1,223 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func TestDeletionBudgetTripsOnMaxDeletionsPerWindow(t *testing.T) {
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	budget := &DeletionBudget{MaxDeletions: 2, Window: 10 * time.Minute}
+
+	for i := 0; i < 2; i++ {
+		if allowed, reason, err := budget.Reserve(context.Background(), now); !allowed || err != nil {
+			t.Fatalf("expected deletion %d to be allowed, got %q, %v", i+1, reason, err)
+		}
+	}
+	if allowed, _ := budget.Allow(now.Add(time.Minute)); allowed {
+		t.Fatalf("expected third deletion within window to be refused")
+	}
+	if allowed, _ := budget.Allow(now.Add(time.Hour)); allowed {
+		t.Fatalf("expected tripped budget to refuse deletions after the window until reset")
+	}
+
+	budget.Reset()
+	if allowed, reason := budget.Allow(now.Add(time.Hour)); !allowed {
+		t.Fatalf("expected reset budget to allow deletions, got %q", reason)
+	}
+}
+
+func TestDeletionBudgetAllowsDeletionsOutsideWindow(t *testing.T) {
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	budget := &DeletionBudget{MaxDeletions: 1, Window: 10 * time.Minute}
+	budget.Reserve(context.Background(), now)
+
+	if allowed, reason := budget.Allow(now.Add(11 * time.Minute)); !allowed {
+		t.Fatalf("expected deletion after the window to be allowed, got %q", reason)
+	}
+}
+
+func TestDeletionBudgetTripsOnMaxPercentOfMatchedNodes(t *testing.T) {
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	budget := &DeletionBudget{MaxPercent: 25, Window: 10 * time.Minute}
+	budget.SetMatchedNodes(8)
+
+	for i := 0; i < 2; i++ {
+		if allowed, reason, err := budget.Reserve(context.Background(), now); !allowed || err != nil {
+			t.Fatalf("expected deletion %d of 8 nodes to be allowed, got %q, %v", i+1, reason, err)
+		}
+	}
+	if allowed, _ := budget.Allow(now); allowed {
+		t.Fatalf("expected third deletion of 8 nodes to exceed 25%%")
+	}
+	if status := budget.Status(now); !status.Tripped || status.RecentDeletions != 2 || status.MatchedNodes != 8 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
+
+func TestDeletionBudgetNilAllowsEverything(t *testing.T) {
+	var budget *DeletionBudget
+	if allowed, _ := budget.Allow(time.Now()); !allowed {
+		t.Fatalf("expected nil budget to allow deletions")
+	}
+	if allowed, _, err := budget.Reserve(context.Background(), time.Now()); !allowed || err != nil {
+		t.Fatalf("expected nil budget to allow reservations, got %v", err)
+	}
+	budget.Release(time.Now())
+	budget.SetMatchedNodes(1)
+}
+
+func TestDeletionBudgetServeHTTPServesStatusOnly(t *testing.T) {
+	budget := &DeletionBudget{MaxDeletions: 1}
+	budget.Reserve(context.Background(), time.Now())
+	budget.Allow(time.Now())
+
+	recorder := httptest.NewRecorder()
+	budget.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/deletion-budget", nil))
+	var status DeletionBudgetStatus
+	if err := json.Unmarshal(recorder.Body.Bytes(), &status); err != nil {
+		t.Fatalf("decode status: %v", err)
+	}
+	if !status.Tripped {
+		t.Fatalf("expected tripped status, got %+v", status)
+	}
+
+	recorder = httptest.NewRecorder()
+	budget.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/deletion-budget", nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected POST to be rejected, got %d", recorder.Code)
+	}
+	if status := budget.Status(time.Now()); !status.Tripped {
+		t.Fatalf("expected POST not to reset the budget, got %+v", status)
+	}
+}
+
+func TestDeletionBudgetSyncPersistsAndLoadsState(t *testing.T) {
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).Build()
+	name := types.NamespacedName{Namespace: "kube-system", Name: "deletion-budget"}
+	budget := &DeletionBudget{MaxDeletions: 1, Client: c, ConfigMap: name}
+
+	if allowed, _ := budget.Allow(now); allowed {
+		t.Fatalf("expected deletions to be refused until the state is loaded")
+	}
+	if err := budget.Sync(context.Background(), now); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if allowed, reason, err := budget.Reserve(context.Background(), now); !allowed || err != nil {
+		t.Fatalf("expected loaded budget to allow a deletion, got %q, %v", reason, err)
+	}
+
+	reserved := &DeletionBudget{MaxDeletions: 1, Client: c, ConfigMap: name}
+	if err := reserved.Sync(context.Background(), now); err != nil {
+		t.Fatalf("sync after restart: %v", err)
+	}
+	if status := reserved.Status(now); status.RecentDeletions != 1 {
+		t.Fatalf("expected the reservation to be persisted before the deletion, got %+v", status)
+	}
+
+	if allowed, _ := budget.Allow(now); allowed {
+		t.Fatalf("expected second deletion to trip the budget")
+	}
+	if err := budget.Sync(context.Background(), now); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+
+	restarted := &DeletionBudget{MaxDeletions: 1, Client: c, ConfigMap: name}
+	if err := restarted.Sync(context.Background(), now.Add(time.Hour)); err != nil {
+		t.Fatalf("sync after restart: %v", err)
+	}
+	if status := restarted.Status(now.Add(time.Hour)); !status.Tripped || status.Reason == "" {
+		t.Fatalf("expected tripped state to survive a restart, got %+v", status)
+	}
+	if allowed, _ := restarted.Allow(now.Add(time.Hour)); allowed {
+		t.Fatalf("expected restarted budget to keep refusing deletions")
+	}
+}
+
+func TestDeletionBudgetSyncResetsOnNewAnnotationValue(t *testing.T) {
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	name := types.NamespacedName{Namespace: "kube-system", Name: "deletion-budget"}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: name.Namespace, Name: name.Name},
+		Data:       map[string]string{"state": `{"tripped":true,"reason":"more than 1 node deletions within 10m0s","handledReset":"1"}`},
+	}
+	configMap.Annotations = map[string]string{DeletionBudgetResetAnnotation: "1"}
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(configMap).Build()
+	budget := &DeletionBudget{MaxDeletions: 1, Client: c, ConfigMap: name}
+
+	if err := budget.Sync(context.Background(), now); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if !budget.Status(now).Tripped {
+		t.Fatalf("expected an already handled reset value not to reset the budget")
+	}
+
+	var current corev1.ConfigMap
+	if err := c.Get(context.Background(), name, &current); err != nil {
+		t.Fatalf("get ConfigMap: %v", err)
+	}
+	current.Annotations[DeletionBudgetResetAnnotation] = "2"
+	if err := c.Update(context.Background(), &current); err != nil {
+		t.Fatalf("update ConfigMap: %v", err)
+	}
+	if err := budget.Sync(context.Background(), now); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if budget.Status(now).Tripped {
+		t.Fatalf("expected a new reset value to reset the budget")
+	}
+	if err := c.Get(context.Background(), name, &current); err != nil {
+		t.Fatalf("get ConfigMap: %v", err)
+	}
+	if state := current.Data["state"]; state != `{"tripped":false,"handledReset":"2"}` {
+		t.Fatalf("expected reset state to be persisted, got %s", state)
+	}
+}
+
+func TestDeletionBudgetReserveFailsClosedWhenStateCannotBePersisted(t *testing.T) {
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	failUpdates := false
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithInterceptorFuncs(interceptor.Funcs{
+		Update: func(ctx context.Context, client client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+			if failUpdates {
+				return errors.New("etcd unavailable")
+			}
+			return client.Update(ctx, obj, opts...)
+		},
+	}).Build()
+	name := types.NamespacedName{Namespace: "kube-system", Name: "deletion-budget"}
+	budget := &DeletionBudget{MaxDeletions: 5, Client: c, ConfigMap: name}
+	if err := budget.Sync(context.Background(), now); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+
+	failUpdates = true
+	if allowed, _, err := budget.Reserve(context.Background(), now); allowed || err == nil {
+		t.Fatalf("expected reservation to fail when the state cannot be persisted, got %v, %v", allowed, err)
+	}
+	if status := budget.Status(now); status.RecentDeletions != 0 {
+		t.Fatalf("expected failed reservation to be dropped, got %+v", status)
+	}
+
+	failUpdates = false
+	if allowed, _, err := budget.Reserve(context.Background(), now); !allowed || err != nil {
+		t.Fatalf("expected reservation to succeed, got %v, %v", allowed, err)
+	}
+	budget.Release(now)
+	if status := budget.Status(now); status.RecentDeletions != 0 {
+		t.Fatalf("expected released reservation not to count, got %+v", status)
+	}
+}
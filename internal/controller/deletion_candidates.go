@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DeletionCandidate is a Node that passed all deletion eligibility checks but
+// was not deleted because the reconciler runs in dry-run mode.
+type DeletionCandidate struct {
+	NodeName         string    `json:"nodeName"`
+	ServerState      string    `json:"serverState"`
+	NotReadyFor      string    `json:"notReadyFor"`
+	ServerName       string    `json:"serverName,omitempty"`
+	ServerID         string    `json:"serverID,omitempty"`
+	ServerDatacenter string    `json:"serverDatacenter,omitempty"`
+	ServerPower      string    `json:"serverPower,omitempty"`
+	FirstSeen        time.Time `json:"firstSeen"`
+	LastSeen         time.Time `json:"lastSeen"`
+}
+
+// DeletionCandidateStore holds the Nodes that would currently be deleted in
+// dry-run mode. Nodes are removed as soon as they are no longer eligible.
+type DeletionCandidateStore struct {
+	mu         sync.RWMutex
+	candidates map[string]DeletionCandidate
+}
+
+func NewDeletionCandidateStore() *DeletionCandidateStore {
+	return &DeletionCandidateStore{candidates: map[string]DeletionCandidate{}}
+}
+
+// Record adds or refreshes a candidate, keeping the time it was first seen.
+func (s *DeletionCandidateStore) Record(candidate DeletionCandidate, now time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	candidate.FirstSeen = now
+	if previous, ok := s.candidates[candidate.NodeName]; ok {
+		candidate.FirstSeen = previous.FirstSeen
+	}
+	candidate.LastSeen = now
+	s.candidates[candidate.NodeName] = candidate
+}
+
+func (s *DeletionCandidateStore) Forget(nodeName string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.candidates, nodeName)
+}
+
+// Retain removes the candidates of nodes that are not in nodeNames, e.g.
+// nodes deleted outside the controller, which are never reconciled again.
+func (s *DeletionCandidateStore) Retain(nodeNames []string) {
+	if s == nil {
+		return
+	}
+	keep := make(map[string]struct{}, len(nodeNames))
+	for _, name := range nodeNames {
+		keep[name] = struct{}{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name := range s.candidates {
+		if _, ok := keep[name]; !ok {
+			delete(s.candidates, name)
+		}
+	}
+}
+
+func (s *DeletionCandidateStore) List() []DeletionCandidate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	candidates := make([]DeletionCandidate, 0, len(s.candidates))
+	for _, candidate := range s.candidates {
+		candidates = append(candidates, candidate)
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].NodeName < candidates[j].NodeName })
+	return candidates
+}
+
+// ServeHTTP returns the current candidates as a JSON array.
+func (s *DeletionCandidateStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s.List())
+}
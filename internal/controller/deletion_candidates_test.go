@@ -0,0 +1,61 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDeletionCandidateStoreKeepsFirstSeen(t *testing.T) {
+	store := NewDeletionCandidateStore()
+	first := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	store.Record(DeletionCandidate{NodeName: "worker2", ServerState: "unknown"}, first)
+	store.Record(DeletionCandidate{NodeName: "worker1", ServerState: "powered off"}, first)
+	store.Record(DeletionCandidate{NodeName: "worker2", ServerState: "unknown"}, first.Add(time.Minute))
+
+	candidates := store.List()
+	if len(candidates) != 2 || candidates[0].NodeName != "worker1" || candidates[1].NodeName != "worker2" {
+		t.Fatalf("expected sorted candidates, got %+v", candidates)
+	}
+	if !candidates[1].FirstSeen.Equal(first) || !candidates[1].LastSeen.Equal(first.Add(time.Minute)) {
+		t.Fatalf("expected first seen to be kept and last seen to be updated, got %+v", candidates[1])
+	}
+
+	store.Forget("worker1")
+	if candidates := store.List(); len(candidates) != 1 || candidates[0].NodeName != "worker2" {
+		t.Fatalf("expected forgotten candidate to be removed, got %+v", candidates)
+	}
+
+	store.Record(DeletionCandidate{NodeName: "worker3", ServerState: "unknown"}, first)
+	store.Retain([]string{"worker3", "worker4"})
+	if candidates := store.List(); len(candidates) != 1 || candidates[0].NodeName != "worker3" {
+		t.Fatalf("expected candidates of other nodes to be pruned, got %+v", candidates)
+	}
+}
+
+func TestDeletionCandidateStoreServesJSON(t *testing.T) {
+	store := NewDeletionCandidateStore()
+	store.Record(DeletionCandidate{NodeName: "worker1", ServerState: "powered off", ServerName: "kamatera-worker1"}, time.Now())
+
+	recorder := httptest.NewRecorder()
+	store.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/dry-run/deletions", nil))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", recorder.Code)
+	}
+	var got []DeletionCandidate
+	if err := json.Unmarshal(recorder.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(got) != 1 || got[0].NodeName != "worker1" || got[0].ServerName != "kamatera-worker1" {
+		t.Fatalf("unexpected response: %+v", got)
+	}
+
+	recorder = httptest.NewRecorder()
+	store.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/dry-run/deletions", nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected POST to be rejected, got %d", recorder.Code)
+	}
+}
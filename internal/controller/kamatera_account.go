@@ -0,0 +1,63 @@
+package controller
+
+import (
+	"sync"
+
+	"golang.org/x/sync/singleflight"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// DefaultKamateraAPIQPS is the default sustained rate of Kamatera API
+	// requests per account, in requests per second.
+	DefaultKamateraAPIQPS = 5
+	// DefaultKamateraAPIBurst is the default number of Kamatera API requests
+	// per account that may be sent at once above DefaultKamateraAPIQPS.
+	DefaultKamateraAPIBurst = 10
+)
+
+// KamateraAPIRateLimit is the client-side token bucket limit of Kamatera API
+// request attempts. A QPS of 0 or less disables the limit.
+type KamateraAPIRateLimit struct {
+	QPS   float64
+	Burst int
+}
+
+// DefaultKamateraAPIRateLimit returns the default Kamatera API rate limit.
+func DefaultKamateraAPIRateLimit() KamateraAPIRateLimit {
+	return KamateraAPIRateLimit{QPS: DefaultKamateraAPIQPS, Burst: DefaultKamateraAPIBurst}
+}
+
+// kamateraAccount is the state shared by all clients of a Kamatera account in
+// the process: the rate limiter of its requests and the in-flight requests
+// that identical requests are coalesced into.
+type kamateraAccount struct {
+	limiter  *rate.Limiter
+	requests singleflight.Group
+}
+
+var (
+	kamateraAccountsMu sync.Mutex
+	kamateraAccounts   = map[string]*kamateraAccount{}
+)
+
+// sharedKamateraAccount returns the state of the Kamatera account with the
+// given API URL and client ID, creating it on first use. The latest rate limit
+// applies when the account is used with different limits.
+func sharedKamateraAccount(url string, clientID string, limit KamateraAPIRateLimit) *kamateraAccount {
+	kamateraAccountsMu.Lock()
+	defer kamateraAccountsMu.Unlock()
+	key := url + "\x00" + clientID
+	account, ok := kamateraAccounts[key]
+	if !ok {
+		account = &kamateraAccount{limiter: rate.NewLimiter(rate.Inf, 0)}
+		kamateraAccounts[key] = account
+	}
+	if limit.QPS > 0 {
+		account.limiter.SetLimit(rate.Limit(limit.QPS))
+		account.limiter.SetBurst(max(limit.Burst, 1))
+	} else {
+		account.limiter.SetLimit(rate.Inf)
+	}
+	return account
+}
@@ -4,18 +4,29 @@ import (
 	"context"
 )
 
-// kamateraAPIClient is the interface used to call kamatera API
+// kamateraAPIClient is the interface used to call kamatera API. Commands take
+// the server they act on as listed, identified by name, datacenter and ID: the
+// Kamatera API addresses servers by name only, so implementations must refuse
+// to send a command when the name does not resolve to exactly that server.
 type kamateraAPIClient interface {
 	IsServerRunning(ctx context.Context, name string) (bool, error)
 	ListServers(ctx context.Context) ([]KamateraServer, error)
+	PowerOnServer(ctx context.Context, server KamateraServer) error
+	PowerOffServer(ctx context.Context, server KamateraServer, force bool) error
+	RebootServer(ctx context.Context, server KamateraServer) error
+	StartServerPower(ctx context.Context, server KamateraServer, power string, force bool) ([]string, error)
+	CommandStatus(ctx context.Context, commandID string) (KamateraCommand, error)
+	TerminateServer(ctx context.Context, server KamateraServer, force bool) error
 }
 
 // buildKamateraAPIClient returns the struct ready to perform calls to kamatera API
-func buildKamateraAPIClient(clientId string, secret string, url string) kamateraAPIClient {
-	client := NewKamateraApiClientRest(clientId, secret, url)
+func buildKamateraAPIClient(clientId string, secret string, url string, rateLimit KamateraAPIRateLimit) kamateraAPIClient {
+	client := NewKamateraApiClientRest(clientId, secret, url, rateLimit)
 	return &client
 }
 
-func BuildKamateraAPIClient(clientId string, secret string, url string) kamateraAPIClient {
-	return buildKamateraAPIClient(clientId, secret, url)
+// BuildKamateraAPIClient returns a Kamatera API client. All clients of the same
+// account share rateLimit and coalesce identical concurrent read requests.
+func BuildKamateraAPIClient(clientId string, secret string, url string, rateLimit KamateraAPIRateLimit) kamateraAPIClient {
+	return buildKamateraAPIClient(clientId, secret, url, rateLimit)
 }
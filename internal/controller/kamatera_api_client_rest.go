@@ -3,113 +3,397 @@ package controller
 import (
 	"context"
 	"fmt"
+	"time"
+
+	"k8s.io/klog/v2"
 )
 
 const (
 	userAgent = "kamatera/kamatera-rke2-controller"
+
+	defaultCommandPollInterval = 2 * time.Second
+	defaultCommandTimeout      = 20 * time.Minute
 )
 
 // NewKamateraApiClientRest factory to create new Rest API Client struct
-func NewKamateraApiClientRest(clientId string, secret string, url string) (client KamateraApiClientRest) {
+func NewKamateraApiClientRest(clientId string, secret string, url string, rateLimit KamateraAPIRateLimit) (client KamateraApiClientRest) {
 	return KamateraApiClientRest{
-		userAgent:                userAgent,
-		clientId:                 clientId,
-		secret:                   secret,
-		url:                      url,
-		maxRetries:               5,
-		expSecondsBetweenRetries: 1,
+		http:                newKamateraHTTPClient(url, clientId, secret, rateLimit),
+		details:             newServerDetailsCache(),
+		commandPollInterval: defaultCommandPollInterval,
+		commandTimeout:      defaultCommandTimeout,
 	}
 }
 
 // KamateraApiClientRest is the struct to perform API calls
 type KamateraApiClientRest struct {
-	userAgent                string
-	clientId                 string
-	secret                   string
-	url                      string
-	maxRetries               int
-	expSecondsBetweenRetries int
+	http                *kamateraHTTPClient
+	details             *serverDetailsCache
+	commandPollInterval time.Duration
+	commandTimeout      time.Duration
 }
 
 type KamateraServerPostRequest struct {
 	ServerName string `json:"name"`
 }
 
+type KamateraServerPowerPostRequest struct {
+	ServerName string `json:"name"`
+	Power      string `json:"power"`
+	Force      bool   `json:"force,omitempty"`
+}
+
+type KamateraServerTerminatePostRequest struct {
+	ServerName string `json:"name"`
+	Force      bool   `json:"force"`
+}
+
+type KamateraQueuePostRequest struct {
+	ID string `json:"id"`
+}
+
+// kamateraServerInfo is the wire format of a single server returned by the
+// servers list and server info endpoints. Name, datacenter and power are
+// required; all other fields are optional. The servers list only returns the
+// ID, name, datacenter and power, the other fields are returned by server
+// info.
+type kamateraServerInfo struct {
+	ID         flexString                  `json:"id"`
+	Name       *string                     `json:"name"`
+	Datacenter *string                     `json:"datacenter"`
+	Power      *string                     `json:"power"`
+	CPU        flexString                  `json:"cpu"`
+	RAM        flexInt                     `json:"ram"`
+	DiskSizes  []flexInt                   `json:"diskSizes"`
+	Networks   []kamateraServerNetworkInfo `json:"networks"`
+	Billing    flexString                  `json:"billing"`
+	Tags       flexStringList              `json:"tags"`
+	Created    flexTime                    `json:"created"`
+}
+
+type kamateraServerNetworkInfo struct {
+	Network flexString     `json:"network"`
+	IPs     flexStringList `json:"ips"`
+}
+
+func (i kamateraServerInfo) toKamateraServer() (KamateraServer, error) {
+	if i.Name == nil {
+		return KamateraServer{}, fmt.Errorf("invalid server name format")
+	}
+	if i.Datacenter == nil {
+		return KamateraServer{}, fmt.Errorf("invalid server datacenter format")
+	}
+	if i.Power == nil {
+		return KamateraServer{}, fmt.Errorf("invalid server power format")
+	}
+	server := KamateraServer{
+		ID:         string(i.ID),
+		Name:       *i.Name,
+		Datacenter: *i.Datacenter,
+		Power:      *i.Power,
+		CPU:        string(i.CPU),
+		RAMMB:      int(i.RAM),
+		Billing:    string(i.Billing),
+		Tags:       []string(i.Tags),
+		CreatedAt:  time.Time(i.Created),
+	}
+	for _, size := range i.DiskSizes {
+		server.DiskSizesGB = append(server.DiskSizesGB, int(size))
+	}
+	for _, network := range i.Networks {
+		server.Networks = append(server.Networks, KamateraServerNetwork{Name: string(network.Network), IPs: []string(network.IPs)})
+	}
+	return server, nil
+}
+
+func decodeKamateraServers(result interface{}) ([]KamateraServer, error) {
+	var infos []kamateraServerInfo
+	if err := decodeKamateraResult(result, &infos); err != nil {
+		return nil, fmt.Errorf("invalid servers list format: %w", err)
+	}
+	servers := make([]KamateraServer, 0, len(infos))
+	for _, info := range infos {
+		server, err := info.toKamateraServer()
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, server)
+	}
+	return servers, nil
+}
+
 func (c *KamateraApiClientRest) IsServerRunning(ctx context.Context, name string) (bool, error) {
-	gotErrorMessage, res, err := request(
-		ctx,
-		ProviderConfig{ApiUrl: c.url, ApiClientID: c.clientId, ApiSecret: c.secret},
-		"POST",
-		"/service/server/info",
-		KamateraServerPostRequest{ServerName: name},
-		c.maxRetries,
-		c.expSecondsBetweenRetries,
-		"No servers found",
-	)
+	res, err := c.http.doShared(ctx, "POST", "/service/server/info", KamateraServerPostRequest{ServerName: serverInfoNamePattern([]string{name})})
+	if isNoServersFound(err) {
+		return false, nil
+	}
 	if err != nil {
 		return false, err
 	}
-	if gotErrorMessage {
+	if res == nil {
 		return false, nil
 	}
-	serverInfoList, ok := res.([]interface{})
-	if !ok || len(serverInfoList) == 0 {
+	servers, err := decodeKamateraServers(res)
+	if err != nil {
+		return false, err
+	}
+	if len(servers) == 0 {
 		return false, nil
 	}
-	if len(serverInfoList) != 1 {
-		return false, fmt.Errorf("expected one server info, got %d", len(serverInfoList))
+	if len(servers) != 1 {
+		return false, fmt.Errorf("expected one server info, got %d", len(servers))
 	}
-	serverInfo, ok := serverInfoList[0].(map[string]interface{})
-	if !ok {
-		return false, fmt.Errorf("invalid server info format")
+	return servers[0].Power == "on", nil
+}
+
+// ListServers lists all servers with their details, see withServerDetails.
+func (c *KamateraApiClientRest) ListServers(ctx context.Context) ([]KamateraServer, error) {
+	res, err := c.http.doShared(ctx, "GET", "/service/servers", nil)
+	if isNoServersFound(err) {
+		return nil, nil
 	}
-	powerState, ok := serverInfo["power"].(string)
-	if !ok {
-		return false, fmt.Errorf("invalid power state format")
+	if err != nil {
+		return nil, err
 	}
-	return powerState == "on", nil
+	servers, err := decodeKamateraServers(res)
+	if err != nil {
+		return nil, err
+	}
+	return c.withServerDetails(ctx, servers), nil
 }
 
-func (c *KamateraApiClientRest) ListServers(ctx context.Context) ([]KamateraServer, error) {
-	gotErrorMessage, res, err := request(
-		ctx,
-		ProviderConfig{ApiUrl: c.url, ApiClientID: c.clientId, ApiSecret: c.secret},
-		"GET",
-		"/service/servers",
-		nil,
-		c.maxRetries,
-		c.expSecondsBetweenRetries,
-		"No servers found",
-	)
+func (c *KamateraApiClientRest) PowerOnServer(ctx context.Context, server KamateraServer) error {
+	return c.setServerPower(ctx, server, "on", false)
+}
+
+func (c *KamateraApiClientRest) PowerOffServer(ctx context.Context, server KamateraServer, force bool) error {
+	return c.setServerPower(ctx, server, "off", force)
+}
+
+func (c *KamateraApiClientRest) RebootServer(ctx context.Context, server KamateraServer) error {
+	return c.setServerPower(ctx, server, "restart", false)
+}
+
+// resolveServer checks that the name of server resolves to that server alone,
+// since commands address servers by name: the name must match exactly one
+// server of the account, with the datacenter and ID of server when they are
+// set. NoServersFound errors are returned as they are.
+func (c *KamateraApiClientRest) resolveServer(ctx context.Context, server KamateraServer) error {
+	res, err := c.http.doShared(ctx, "POST", "/service/server/info", KamateraServerPostRequest{ServerName: serverInfoNamePattern([]string{server.Name})})
+	if err != nil {
+		return fmt.Errorf("looking up Kamatera server %s: %w", server.Name, err)
+	}
+	servers, err := decodeKamateraServers(res)
 	if err != nil {
+		return fmt.Errorf("invalid server info response for server %s: %w", server.Name, err)
+	}
+	if len(servers) != 1 {
+		return fmt.Errorf("%d Kamatera servers are named %s, not sending a command addressed by name", len(servers), server.Name)
+	}
+	found := servers[0]
+	if (server.ID != "" && found.ID != server.ID) || (server.Datacenter != "" && found.Datacenter != server.Datacenter) {
+		return fmt.Errorf("kamatera server named %s is %s in datacenter %s, not %s in datacenter %s", server.Name, found.ID, found.Datacenter, server.ID, server.Datacenter)
+	}
+	return nil
+}
+
+// TerminateServer powers off the server (best effort) and then terminates it,
+// waiting for all resulting Kamatera commands to complete. A server that no
+// longer exists, e.g. terminated by an earlier attempt whose response was
+// lost, counts as terminated. When the terminate response has no command IDs,
+// the server is looked up until it is gone.
+func (c *KamateraApiClientRest) TerminateServer(ctx context.Context, server KamateraServer, force bool) error {
+	if err := c.resolveServer(ctx, server); err != nil {
+		if isNoServersFound(err) {
+			klog.V(1).Infof("Kamatera server %s to terminate was not found, it is already terminated", server.Name)
+			return nil
+		}
+		return err
+	}
+	if err := c.startAndWait(ctx, server.Name, "off", force); err != nil {
+		klog.V(1).Infof("failed to power off Kamatera server %s before terminate, will attempt to terminate anyway: %v", server.Name, err)
+	}
+	res, err := c.http.do(ctx, "DELETE", "/service/server/terminate", KamateraServerTerminatePostRequest{ServerName: server.Name, Force: force})
+	if isNoServersFound(err) {
+		klog.V(1).Infof("Kamatera server %s to terminate was not found, it is already terminated", server.Name)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	commandIDs, err := decodeKamateraCommandIDs(res)
+	if err != nil {
+		// terminate responses do not always include command IDs
+		return c.waitForTerminated(ctx, server.Name)
+	}
+	return c.waitForCommands(ctx, commandIDs)
+}
+
+// waitForTerminated polls server/info until the server is no longer found or
+// commandTimeout elapses.
+func (c *KamateraApiClientRest) waitForTerminated(ctx context.Context, name string) error {
+	pollInterval := c.commandPollInterval
+	if pollInterval <= 0 {
+		pollInterval = defaultCommandPollInterval
+	}
+	timeout := c.commandTimeout
+	if timeout <= 0 {
+		timeout = defaultCommandTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for Kamatera server %s to be terminated: %w", name, ctx.Err())
+		case <-ticker.C:
+		}
+		_, err := c.http.doShared(ctx, "POST", "/service/server/info", KamateraServerPostRequest{ServerName: serverInfoNamePattern([]string{name})})
+		if isNoServersFound(err) {
+			return nil
+		}
+		if err != nil {
+			klog.V(4).Infof("failed to look up terminated Kamatera server %s, will retry: %v", name, err)
+		}
+	}
+}
+
+// StartServerPower starts a power operation on a server, one of on, off or
+// restart, and returns the IDs of the resulting Kamatera commands without
+// waiting for them to complete.
+func (c *KamateraApiClientRest) StartServerPower(ctx context.Context, server KamateraServer, power string, force bool) ([]string, error) {
+	if err := c.resolveServer(ctx, server); err != nil {
 		return nil, err
 	}
-	if gotErrorMessage {
-		return nil, nil
+	return c.startServerPower(ctx, server.Name, power, force)
+}
+
+func (c *KamateraApiClientRest) startServerPower(ctx context.Context, name string, power string, force bool) ([]string, error) {
+	res, err := c.http.do(ctx, "POST", "/service/server/power", KamateraServerPowerPostRequest{ServerName: name, Power: power, Force: force})
+	if err != nil {
+		return nil, err
+	}
+	commandIDs, err := decodeKamateraCommandIDs(res)
+	if err != nil {
+		return nil, fmt.Errorf("invalid power %s response for server %s: %w", power, name, err)
+	}
+	return commandIDs, nil
+}
+
+func (c *KamateraApiClientRest) setServerPower(ctx context.Context, server KamateraServer, power string, force bool) error {
+	if err := c.resolveServer(ctx, server); err != nil {
+		return err
 	}
-	serverInfoList, ok := res.([]interface{})
-	if !ok {
-		return nil, fmt.Errorf("invalid servers list format")
+	return c.startAndWait(ctx, server.Name, power, force)
+}
+
+// startAndWait starts a power operation on a resolved server and waits for its
+// commands to complete.
+func (c *KamateraApiClientRest) startAndWait(ctx context.Context, name string, power string, force bool) error {
+	commandIDs, err := c.startServerPower(ctx, name, power, force)
+	if err != nil {
+		return err
 	}
-	servers := make([]KamateraServer, 0, len(serverInfoList))
-	for _, item := range serverInfoList {
-		serverInfo, ok := item.(map[string]interface{})
-		if !ok {
-			return nil, fmt.Errorf("invalid server info format")
+	return c.waitForCommands(ctx, commandIDs)
+}
+
+// CommandStatus returns the current state of a Kamatera command.
+func (c *KamateraApiClientRest) CommandStatus(ctx context.Context, commandID string) (KamateraCommand, error) {
+	res, err := c.http.doShared(ctx, "POST", "/service/queue", KamateraQueuePostRequest{ID: commandID})
+	if err != nil {
+		return KamateraCommand{}, err
+	}
+	var commands []kamateraCommandInfo
+	if err := decodeKamateraResult(res, &commands); err != nil || len(commands) == 0 {
+		return KamateraCommand{}, fmt.Errorf("invalid Kamatera command %s status response: %+v", commandID, res)
+	}
+	return KamateraCommand{ID: commandID, Status: string(commands[0].Status), Log: string(commands[0].Log)}, nil
+}
+
+func (c *KamateraApiClientRest) waitForCommands(ctx context.Context, commandIDs []string) error {
+	for _, commandID := range commandIDs {
+		if err := c.waitForCommand(ctx, commandID); err != nil {
+			return err
 		}
-		name, ok := serverInfo["name"].(string)
-		if !ok {
-			return nil, fmt.Errorf("invalid server name format")
+	}
+	return nil
+}
+
+// waitForCommand polls service/queue until the command completes, fails, is
+// cancelled or commandTimeout elapses.
+func (c *KamateraApiClientRest) waitForCommand(ctx context.Context, commandID string) error {
+	pollInterval := c.commandPollInterval
+	if pollInterval <= 0 {
+		pollInterval = defaultCommandPollInterval
+	}
+	timeout := c.commandTimeout
+	if timeout <= 0 {
+		timeout = defaultCommandTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for Kamatera command %s: %w", commandID, ctx.Err())
+		case <-ticker.C:
 		}
-		datacenter, ok := serverInfo["datacenter"].(string)
-		if !ok {
-			return nil, fmt.Errorf("invalid server datacenter format")
+		command, err := c.CommandStatus(ctx, commandID)
+		if err != nil {
+			klog.V(4).Infof("failed to get Kamatera command %s status, will retry: %v", commandID, err)
+			continue
 		}
-		power, ok := serverInfo["power"].(string)
-		if !ok {
-			return nil, fmt.Errorf("invalid server power format")
+		if command.Done() {
+			return command.Err()
 		}
-		servers = append(servers, KamateraServer{Name: name, Datacenter: datacenter, Power: power})
 	}
-	return servers, nil
+}
+
+// KamateraCommand is the state of an asynchronous Kamatera command.
+type KamateraCommand struct {
+	ID     string
+	Status string
+	Log    string
+}
+
+// Done returns whether the command completed, failed or was cancelled.
+func (c KamateraCommand) Done() bool {
+	switch c.Status {
+	case "complete", "error", "cancelled":
+		return true
+	}
+	return false
+}
+
+// Err returns the error of a failed or cancelled command.
+func (c KamateraCommand) Err() error {
+	switch c.Status {
+	case "error":
+		return fmt.Errorf("kamatera command %s failed: %s", c.ID, c.Log)
+	case "cancelled":
+		return fmt.Errorf("kamatera command %s was cancelled: %s", c.ID, c.Log)
+	}
+	return nil
+}
+
+type kamateraCommandInfo struct {
+	Status flexString `json:"status"`
+	Log    flexString `json:"log"`
+}
+
+// decodeKamateraCommandIDs parses the command IDs returned by operations that
+// are executed asynchronously through the Kamatera queue.
+func decodeKamateraCommandIDs(result interface{}) ([]string, error) {
+	var ids flexStringList
+	if err := decodeKamateraResult(result, &ids); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no command IDs in response")
+	}
+	return []string(ids), nil
 }
@@ -2,12 +2,21 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/mock"
+
+	"github.com/kamatera/kamatera-rke2-controller/internal/kamaterafake"
 )
 
 type kamateraClientMock struct {
@@ -25,8 +34,35 @@ func (c *kamateraClientMock) ListServers(ctx context.Context) ([]KamateraServer,
 	return servers, args.Error(1)
 }
 
+func (c *kamateraClientMock) PowerOnServer(ctx context.Context, server KamateraServer) error {
+	return c.Called(ctx, server.Name).Error(0)
+}
+
+func (c *kamateraClientMock) PowerOffServer(ctx context.Context, server KamateraServer, force bool) error {
+	return c.Called(ctx, server.Name, force).Error(0)
+}
+
+func (c *kamateraClientMock) RebootServer(ctx context.Context, server KamateraServer) error {
+	return c.Called(ctx, server.Name).Error(0)
+}
+
+func (c *kamateraClientMock) StartServerPower(ctx context.Context, server KamateraServer, power string, force bool) ([]string, error) {
+	args := c.Called(ctx, server.Name, power, force)
+	commandIDs, _ := args.Get(0).([]string)
+	return commandIDs, args.Error(1)
+}
+
+func (c *kamateraClientMock) CommandStatus(ctx context.Context, commandID string) (KamateraCommand, error) {
+	args := c.Called(ctx, commandID)
+	return args.Get(0).(KamateraCommand), args.Error(1)
+}
+
+func (c *kamateraClientMock) TerminateServer(ctx context.Context, server KamateraServer, force bool) error {
+	return c.Called(ctx, server.Name, force).Error(0)
+}
+
 func TestBuildKamateraAPIClientReturnsClient(t *testing.T) {
-	client := BuildKamateraAPIClient("client-id", "secret", "https://example.invalid")
+	client := BuildKamateraAPIClient("client-id", "secret", "https://example.invalid", DefaultKamateraAPIRateLimit())
 	if client == nil {
 		t.Fatalf("expected Kamatera API client")
 	}
@@ -34,18 +70,23 @@ func TestBuildKamateraAPIClientReturnsClient(t *testing.T) {
 
 func TestKamateraApiClientRestListServersParsesValidResponse(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet || r.URL.Path != "/service/servers" {
-			t.Fatalf("unexpected request: %s %s", r.Method, r.URL.Path)
-		}
 		if r.Header.Get("AuthClientId") != "client-id" || r.Header.Get("AuthSecret") != "secret" {
-			t.Fatalf("unexpected auth headers")
+			t.Errorf("unexpected auth headers")
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/service/servers":
+			fmt.Fprint(w, `[{"name":"node-1","datacenter":"EU","power":"on"},{"name":"node-2","datacenter":"US","power":"off"}]`)
+		case r.Method == http.MethodPost && r.URL.Path == "/service/server/info":
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"message":"No servers found"}`)
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
 		}
-		fmt.Fprint(w, `[{"name":"node-1","datacenter":"EU","power":"on"},{"name":"node-2","datacenter":"US","power":"off"}]`)
 	}))
 	defer server.Close()
 
-	client := NewKamateraApiClientRest("client-id", "secret", server.URL)
-	client.maxRetries = 1
+	client := NewKamateraApiClientRest("client-id", "secret", server.URL, DefaultKamateraAPIRateLimit())
+	client.http.retry.maxAttempts = 1
 	servers, err := client.ListServers(context.Background())
 	if err != nil {
 		t.Fatalf("ListServers: %v", err)
@@ -55,10 +96,190 @@ func TestKamateraApiClientRestListServersParsesValidResponse(t *testing.T) {
 	if len(servers) != len(want) {
 		t.Fatalf("expected %d servers, got %+v", len(want), servers)
 	}
-	for i := range want {
-		if servers[i] != want[i] {
-			t.Fatalf("expected servers %+v, got %+v", want, servers)
+	if !reflect.DeepEqual(servers, want) {
+		t.Fatalf("expected servers %+v, got %+v", want, servers)
+	}
+}
+
+func TestKamateraApiClientRestListServersParsesFullServerInfo(t *testing.T) {
+	var infoRequests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/service/servers" {
+			fmt.Fprint(w, `[{"id":"12345","name":"worker1","datacenter":"EU","power":"off"}]`)
+			return
 		}
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		infoRequests = append(infoRequests, fmt.Sprint(body["name"]))
+		fmt.Fprint(w, `[{
+			"id": "12345",
+			"name": "worker1",
+			"datacenter": "EU",
+			"power": "on",
+			"cpu": "2B",
+			"ram": "4096",
+			"diskSizes": [20, "40"],
+			"networks": [{"network": "wan-eu", "ips": ["1.2.3.4"]}, {"network": "lan-eu-private", "ips": "10.0.0.5"}],
+			"billing": "hourly",
+			"tags": "rke2,worker",
+			"created": "2026-01-15 12:00:00",
+			"unknownField": {"ignored": true}
+		}]`)
+	}))
+	defer server.Close()
+
+	client := NewKamateraApiClientRest("client-id", "secret", server.URL, DefaultKamateraAPIRateLimit())
+	client.http.retry.maxAttempts = 1
+	servers, err := client.ListServers(context.Background())
+	if err != nil {
+		t.Fatalf("ListServers: %v", err)
+	}
+
+	want := []KamateraServer{{
+		ID:          "12345",
+		Name:        "worker1",
+		Datacenter:  "EU",
+		Power:       "off",
+		CPU:         "2B",
+		RAMMB:       4096,
+		DiskSizesGB: []int{20, 40},
+		Networks: []KamateraServerNetwork{
+			{Name: "wan-eu", IPs: []string{"1.2.3.4"}},
+			{Name: "lan-eu-private", IPs: []string{"10.0.0.5"}},
+		},
+		Billing:   "hourly",
+		Tags:      []string{"rke2", "worker"},
+		CreatedAt: time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC),
+	}}
+	if !reflect.DeepEqual(servers, want) {
+		t.Fatalf("expected servers %+v, got %+v", want, servers)
+	}
+	if want := []string{"^(?:worker1)$"}; !reflect.DeepEqual(infoRequests, want) {
+		t.Fatalf("expected server info requests %v, got %v", want, infoRequests)
+	}
+}
+
+func TestKamateraApiClientRestListServersCachesServerDetailsPerGeneration(t *testing.T) {
+	var servers []kamaterafake.Server
+	for i := 0; i < serverInfoBatchSize+5; i++ {
+		servers = append(servers, kamaterafake.Server{ID: strconv.Itoa(i), Name: fmt.Sprintf("worker%d", i), Datacenter: "EU", Power: "on", CPU: "2B", RAMMB: 4096})
+	}
+	api := kamaterafake.New(servers...)
+	server := httptest.NewServer(api)
+	defer server.Close()
+	client := newQueueTestClient(server.URL)
+	infoRequests := func() int {
+		count := 0
+		for _, req := range api.Requests() {
+			if req.Path == "/service/server/info" {
+				count++
+			}
+		}
+		return count
+	}
+
+	listed, err := client.ListServers(context.Background())
+	if err != nil || len(listed) != len(servers) || listed[len(servers)-1].RAMMB != 4096 {
+		t.Fatalf("unexpected servers %+v err=%v", listed, err)
+	}
+	if count := infoRequests(); count != 2 {
+		t.Fatalf("expected the details of %d servers to be fetched in 2 batches, got %d requests", len(servers), count)
+	}
+
+	api.SetPower("worker0", "off")
+	api.AddServer(kamaterafake.Server{ID: "new", Name: "worker-new", Datacenter: "EU", Power: "on", CPU: "4A", RAMMB: 8192})
+	listed, err = client.ListServers(context.Background())
+	if err != nil || len(listed) != len(servers)+1 || listed[0].Power != "off" || listed[0].RAMMB != 4096 || listed[len(servers)].CPU != "4A" {
+		t.Fatalf("unexpected servers %+v err=%v", listed, err)
+	}
+	if count := infoRequests(); count != 3 {
+		t.Fatalf("expected only the details of the new server to be fetched, got %d requests", count)
+	}
+
+	for i := 2; i < serverDetailsMaxAge; i++ {
+		if _, err := client.ListServers(context.Background()); err != nil {
+			t.Fatalf("ListServers: %v", err)
+		}
+	}
+	if count := infoRequests(); count != 3 {
+		t.Fatalf("expected cached details to be reused, got %d requests", count)
+	}
+	if _, err := client.ListServers(context.Background()); err != nil {
+		t.Fatalf("ListServers: %v", err)
+	}
+	if count := infoRequests(); count != 5 {
+		t.Fatalf("expected details to be fetched again after %d generations, got %d requests", serverDetailsMaxAge, count)
+	}
+}
+
+func TestKamateraApiClientRestListServersFallsBackWhenServerDetailsFail(t *testing.T) {
+	api := kamaterafake.New(kamaterafake.Server{ID: "1", Name: "worker1", Datacenter: "EU", Power: "on", RAMMB: 4096})
+	api.AddFault(kamaterafake.Fault{Path: "/service/server/info", StatusCode: http.StatusBadGateway})
+	server := httptest.NewServer(api)
+	defer server.Close()
+	client := newQueueTestClient(server.URL)
+	failures := testutil.ToFloat64(kamateraServerDetailsFailures)
+
+	listed, err := client.ListServers(context.Background())
+	if err != nil || len(listed) != 1 || listed[0].Name != "worker1" || listed[0].RAMMB != 0 {
+		t.Fatalf("expected the listed server without details, got %+v err=%v", listed, err)
+	}
+	if got := testutil.ToFloat64(kamateraServerDetailsFailures) - failures; got != 1 {
+		t.Fatalf("expected 1 counted details failure, got %v", got)
+	}
+
+	api.ClearFaults()
+	if listed, err = client.ListServers(context.Background()); err != nil || listed[0].RAMMB != 4096 {
+		t.Fatalf("expected details once the lookup succeeds, got %+v err=%v", listed, err)
+	}
+
+	api.AddFault(kamaterafake.Fault{Path: "/service/server/info", StatusCode: http.StatusBadGateway})
+	api.SetPower("worker1", "off")
+	for i := 0; i <= serverDetailsMaxAge; i++ {
+		if listed, err = client.ListServers(context.Background()); err != nil {
+			t.Fatalf("ListServers: %v", err)
+		}
+	}
+	if len(listed) != 1 || listed[0].RAMMB != 4096 || listed[0].Power != "off" {
+		t.Fatalf("expected cached details with the listed power, got %+v", listed)
+	}
+
+	api.AddFault(kamaterafake.Fault{Path: "/service/servers", StatusCode: http.StatusBadGateway})
+	if _, err := client.ListServers(context.Background()); err == nil {
+		t.Fatalf("expected the servers list error")
+	}
+}
+
+func TestKamateraApiClientRestListServersRejectsMissingRequiredField(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"name":"node-1","datacenter":"EU"}]`)
+	}))
+	defer server.Close()
+
+	client := NewKamateraApiClientRest("client-id", "secret", server.URL, DefaultKamateraAPIRateLimit())
+	client.http.retry.maxAttempts = 1
+	if _, err := client.ListServers(context.Background()); err == nil {
+		t.Fatalf("expected missing power field error")
+	}
+}
+
+func TestKamateraApiClientRestIsServerRunningParsesServerInfo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/service/server/info" {
+			t.Fatalf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		fmt.Fprint(w, `[{"id":1,"name":"node-1","datacenter":"EU","power":"on","ram":2048}]`)
+	}))
+	defer server.Close()
+
+	client := NewKamateraApiClientRest("client-id", "secret", server.URL, DefaultKamateraAPIRateLimit())
+	client.http.retry.maxAttempts = 1
+	running, err := client.IsServerRunning(context.Background(), "node-1")
+	if err != nil {
+		t.Fatalf("IsServerRunning: %v", err)
+	}
+	if !running {
+		t.Fatalf("expected server to be running")
 	}
 }
 
@@ -68,10 +289,298 @@ func TestKamateraApiClientRestListServersRejectsInvalidResponseShape(t *testing.
 	}))
 	defer server.Close()
 
-	client := NewKamateraApiClientRest("client-id", "secret", server.URL)
-	client.maxRetries = 1
+	client := NewKamateraApiClientRest("client-id", "secret", server.URL, DefaultKamateraAPIRateLimit())
+	client.http.retry.maxAttempts = 1
 	_, err := client.ListServers(context.Background())
 	if err == nil {
 		t.Fatalf("expected invalid response shape error")
 	}
 }
+
+func newQueueTestClient(url string) KamateraApiClientRest {
+	client := NewKamateraApiClientRest("client-id", "secret", url, KamateraAPIRateLimit{})
+	client.http.retry.maxAttempts = 1
+	client.commandPollInterval = time.Millisecond
+	client.commandTimeout = 5 * time.Second
+	return client
+}
+
+// testWorker1 is the server described by testWorker1Info.
+var testWorker1 = KamateraServer{ID: "1", Name: "worker1", Datacenter: "EU"}
+
+const testWorker1Info = `[{"id":"1","name":"worker1","datacenter":"EU","power":"on"}]`
+
+func TestKamateraApiClientRestPowerOffServerWaitsForQueueCompletion(t *testing.T) {
+	var mu sync.Mutex
+	queuePolls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode request body: %v", err)
+		}
+		switch r.URL.Path {
+		case "/service/server/info":
+			fmt.Fprint(w, testWorker1Info)
+		case "/service/server/power":
+			if body["name"] != "worker1" || body["power"] != "off" || body["force"] != true {
+				t.Errorf("unexpected power request body: %v", body)
+			}
+			fmt.Fprint(w, `[4242]`)
+		case "/service/queue":
+			if body["id"] != "4242" {
+				t.Errorf("unexpected queue request body: %v", body)
+			}
+			mu.Lock()
+			queuePolls++
+			polls := queuePolls
+			mu.Unlock()
+			if polls < 3 {
+				fmt.Fprint(w, `[{"status":"running"}]`)
+				return
+			}
+			fmt.Fprint(w, `[{"status":"complete"}]`)
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	client := newQueueTestClient(server.URL)
+	if err := client.PowerOffServer(context.Background(), testWorker1, true); err != nil {
+		t.Fatalf("PowerOffServer: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if queuePolls != 3 {
+		t.Fatalf("expected 3 queue polls, got %d", queuePolls)
+	}
+}
+
+func TestKamateraApiClientRestRebootServerReturnsCommandError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/service/server/info":
+			fmt.Fprint(w, testWorker1Info)
+		case "/service/server/power":
+			var body map[string]interface{}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			if body["power"] != "restart" {
+				t.Errorf("expected restart power request, got %v", body)
+			}
+			fmt.Fprint(w, `["17"]`)
+		case "/service/queue":
+			fmt.Fprint(w, `[{"status":"error","log":"server is locked"}]`)
+		}
+	}))
+	defer server.Close()
+
+	client := newQueueTestClient(server.URL)
+	err := client.RebootServer(context.Background(), testWorker1)
+	if err == nil || !strings.Contains(err.Error(), "server is locked") {
+		t.Fatalf("expected command error with queue log, got %v", err)
+	}
+}
+
+func TestKamateraApiClientRestPowerOnServerHonoursContextCancellation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/service/server/info":
+			fmt.Fprint(w, testWorker1Info)
+		case "/service/server/power":
+			fmt.Fprint(w, `[1]`)
+		case "/service/queue":
+			fmt.Fprint(w, `[{"status":"running"}]`)
+		}
+	}))
+	defer server.Close()
+
+	client := newQueueTestClient(server.URL)
+	client.commandTimeout = 50 * time.Millisecond
+	if err := client.PowerOnServer(context.Background(), testWorker1); err == nil {
+		t.Fatalf("expected timeout waiting for command")
+	}
+}
+
+func TestKamateraApiClientRestTerminateServerPowersOffThenTerminates(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/service/server/info":
+			fmt.Fprint(w, testWorker1Info)
+		case "/service/server/power":
+			fmt.Fprint(w, `[1]`)
+		case "/service/server/terminate":
+			var body map[string]interface{}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			if body["name"] != "worker1" || body["force"] != true {
+				t.Errorf("unexpected terminate request body: %v", body)
+			}
+			fmt.Fprint(w, `[2]`)
+		case "/service/queue":
+			fmt.Fprint(w, `[{"status":"complete"}]`)
+		}
+	}))
+	defer server.Close()
+
+	client := newQueueTestClient(server.URL)
+	if err := client.TerminateServer(context.Background(), testWorker1, true); err != nil {
+		t.Fatalf("TerminateServer: %v", err)
+	}
+	want := []string{"POST /service/server/info", "POST /service/server/power", "POST /service/queue", "DELETE /service/server/terminate", "POST /service/queue"}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(calls, want) {
+		t.Fatalf("expected calls %v, got %v", want, calls)
+	}
+}
+
+func TestKamateraApiClientRestRefusesCommandsForAmbiguousServerNames(t *testing.T) {
+	for name, tc := range map[string]struct {
+		info    string
+		server  KamateraServer
+		wantErr string
+	}{
+		"name in two datacenters": {
+			info:    `[{"id":"1","name":"worker1","datacenter":"EU","power":"on"},{"id":"2","name":"worker1","datacenter":"US","power":"on"}]`,
+			server:  testWorker1,
+			wantErr: "2 Kamatera servers are named worker1",
+		},
+		"other server with the name": {
+			info:    `[{"id":"2","name":"worker1","datacenter":"US","power":"on"}]`,
+			server:  testWorker1,
+			wantErr: "is 2 in datacenter US",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var mu sync.Mutex
+			var calls []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				calls = append(calls, r.URL.Path)
+				mu.Unlock()
+				switch r.URL.Path {
+				case "/service/server/info":
+					fmt.Fprint(w, tc.info)
+				default:
+					fmt.Fprint(w, `[1]`)
+				}
+			}))
+			defer server.Close()
+
+			client := newQueueTestClient(server.URL)
+			if _, err := client.StartServerPower(context.Background(), tc.server, "restart", false); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tc.wantErr, err)
+			}
+			if err := client.TerminateServer(context.Background(), tc.server, true); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tc.wantErr, err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for _, call := range calls {
+				if call != "/service/server/info" {
+					t.Fatalf("expected no command to be sent, got %v", calls)
+				}
+			}
+		})
+	}
+}
+
+func TestKamateraApiClientRestTerminateServerWithoutCommandIDsWaitsUntilGone(t *testing.T) {
+	for name, tc := range map[string]struct {
+		// stillListed is the number of lookups after the terminate request
+		// that still list the server, -1 for all of them.
+		stillListed int
+		wantErr     bool
+	}{
+		"gone after a while": {stillListed: 2},
+		"still listed":       {stillListed: -1, wantErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var mu sync.Mutex
+			terminated := false
+			lookups := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				switch r.URL.Path {
+				case "/service/server/info":
+					if terminated {
+						lookups++
+						if tc.stillListed >= 0 && lookups > tc.stillListed {
+							w.WriteHeader(http.StatusInternalServerError)
+							fmt.Fprint(w, `{"message":"No servers found"}`)
+							return
+						}
+					}
+					fmt.Fprint(w, testWorker1Info)
+				case "/service/server/power":
+					fmt.Fprint(w, `[1]`)
+				case "/service/queue":
+					fmt.Fprint(w, `[{"status":"complete"}]`)
+				case "/service/server/terminate":
+					terminated = true
+					fmt.Fprint(w, `{"result":"ok"}`)
+				}
+			}))
+			defer server.Close()
+
+			client := newQueueTestClient(server.URL)
+			client.commandTimeout = 100 * time.Millisecond
+			err := client.TerminateServer(context.Background(), testWorker1, true)
+			if tc.wantErr != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if !tc.wantErr && lookups != tc.stillListed+1 {
+				t.Fatalf("expected the server to be looked up until it is gone, got %d lookups", lookups)
+			}
+		})
+	}
+}
+
+func TestKamateraApiClientRestAgainstFakeAPI(t *testing.T) {
+	api := kamaterafake.New(
+		kamaterafake.Server{ID: "1", Name: "worker1", Datacenter: "EU", Power: "on", Networks: []kamaterafake.Network{{Network: "wan-eu", IPs: []string{"203.0.113.1"}}}},
+		kamaterafake.Server{ID: "2", Name: "worker2", Datacenter: "EU", Power: "on"},
+	)
+	api.SetCredentials("client-id", "secret")
+	api.SetCommandDuration(20 * time.Millisecond)
+	server := httptest.NewServer(api)
+	defer server.Close()
+	client := newQueueTestClient(server.URL)
+
+	servers, err := client.ListServers(context.Background())
+	if err != nil || len(servers) != 2 || servers[0].IPs()[0] != "203.0.113.1" {
+		t.Fatalf("unexpected servers %+v err=%v", servers, err)
+	}
+	worker1, worker2 := servers[0], servers[1]
+	if err := client.PowerOffServer(context.Background(), worker1, false); err != nil {
+		t.Fatalf("PowerOffServer: %v", err)
+	}
+	if running, err := client.IsServerRunning(context.Background(), "worker1"); err != nil || running {
+		t.Fatalf("expected worker1 to be powered off, got %v err=%v", running, err)
+	}
+	if err := client.TerminateServer(context.Background(), worker2, true); err != nil {
+		t.Fatalf("TerminateServer: %v", err)
+	}
+	if running, err := client.IsServerRunning(context.Background(), "worker2"); err != nil || running {
+		t.Fatalf("expected terminated worker2 not to be found, got %v err=%v", running, err)
+	}
+	if err := client.TerminateServer(context.Background(), worker2, true); err != nil {
+		t.Fatalf("expected terminating an already terminated server to succeed, got %v", err)
+	}
+
+	api.AddFault(kamaterafake.Fault{Path: "/service/servers", Times: 1, Message: kamaterafake.NoServersFoundMessage})
+	if servers, err := client.ListServers(context.Background()); err != nil || servers != nil {
+		t.Fatalf("expected No servers found to return no servers, got %+v err=%v", servers, err)
+	}
+	api.AddFault(kamaterafake.Fault{Method: http.MethodPost, Path: "/service/server/power", CommandError: "server is locked"})
+	if err := client.RebootServer(context.Background(), worker1); err == nil || !strings.Contains(err.Error(), "server is locked") {
+		t.Fatalf("expected the command error, got %v", err)
+	}
+}
@@ -0,0 +1,370 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	defaultRequestMaxAttempts    = 5
+	defaultRequestInitialBackoff = time.Second
+	defaultRequestMaxBackoff     = 30 * time.Second
+	defaultRequestAttemptTimeout = 30 * time.Second
+	defaultRequestMaxRetryAfter  = time.Minute
+
+	// maxResponseBytes bounds how much of a response body is read.
+	maxResponseBytes = 32 << 20
+	// maxErrorMessageBytes bounds the message of an APIError built from a
+	// response body that is not JSON.
+	maxErrorMessageBytes = 512
+
+	// noServersFoundMessage is the message of the 500 response returned by
+	// the Kamatera API when no server matches.
+	noServersFoundMessage = "No servers found"
+)
+
+// APIError is an error response returned by the Kamatera API.
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	// Message is the message field of a JSON error response, or the response
+	// body when it is not JSON.
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("error response from Kamatera API %s %s (%d): %s", e.Method, e.Path, e.StatusCode, e.Message)
+}
+
+// NoServersFound returns whether the API reported that no server matches,
+// which it does with a 500 response.
+func (e *APIError) NoServersFound() bool {
+	return e.StatusCode == http.StatusInternalServerError && strings.Contains(e.Message, noServersFoundMessage)
+}
+
+// Retryable returns whether the request may succeed when it is repeated.
+func (e *APIError) Retryable() bool {
+	return isRetryableStatus(e.StatusCode) && !e.NoServersFound()
+}
+
+// isNoServersFound returns whether err is an APIError reporting that no
+// server matches.
+func isNoServersFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.NoServersFound()
+}
+
+// isRetryableError returns whether a failed attempt should be retried. Errors
+// that are not APIErrors are transport errors, timeouts or invalid responses.
+func isRetryableError(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Retryable()
+	}
+	return true
+}
+
+// isRetryableCommandError returns whether a failed attempt of a command, such
+// as a power operation or a termination, should be retried. Only attempts that
+// provably never reached the API are: the connection could not be established,
+// or the API rejected the request with 429 and a Retry-After header. Any other
+// failure, including a timeout or a 5xx response, may have started the
+// command, and repeating it could e.g. power a server off after it was powered
+// back on.
+func isRetryableCommandError(err error, retryAfter time.Duration) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests && retryAfter > 0
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// isRetryableStatus returns whether a response with the given status code may
+// succeed when the request is repeated.
+func isRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusRequestTimeout || statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// retryPolicy controls how kamateraHTTPClient retries failed requests. Reads
+// are retried on transport errors, timeouts, invalid responses and retryable
+// APIErrors; other 4xx responses such as authentication errors fail
+// immediately. Commands are only retried when they never reached the API, see
+// isRetryableCommandError.
+type retryPolicy struct {
+	// maxAttempts is the total number of attempts, including the first one.
+	maxAttempts int
+	// initialBackoff is the wait before the first retry. It doubles on every
+	// following retry up to maxBackoff, and every wait is jittered.
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	// attemptTimeout bounds a single attempt, including reading the response.
+	attemptTimeout time.Duration
+	// maxRetryAfter caps the wait requested by a Retry-After response header.
+	maxRetryAfter time.Duration
+}
+
+func defaultRetryPolicy() retryPolicy {
+	return retryPolicy{
+		maxAttempts:    defaultRequestMaxAttempts,
+		initialBackoff: defaultRequestInitialBackoff,
+		maxBackoff:     defaultRequestMaxBackoff,
+		attemptTimeout: defaultRequestAttemptTimeout,
+		maxRetryAfter:  defaultRequestMaxRetryAfter,
+	}
+}
+
+// backoff returns the jittered wait before the given retry, starting at 1 for
+// the first retry. Half of the capped exponential backoff is kept so retries
+// never happen immediately, the other half is random.
+func (p retryPolicy) backoff(retry int) time.Duration {
+	backoff := p.initialBackoff
+	for i := 1; i < retry && backoff < p.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if p.maxBackoff > 0 && backoff > p.maxBackoff {
+		backoff = p.maxBackoff
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return backoff/2 + rand.N(backoff/2+1)
+}
+
+// retryWait returns how long to wait before the given retry, honouring the
+// Retry-After duration of the previous response up to maxRetryAfter.
+func (p retryPolicy) retryWait(retry int, retryAfter time.Duration) time.Duration {
+	wait := p.backoff(retry)
+	if p.maxRetryAfter > 0 && retryAfter > p.maxRetryAfter {
+		retryAfter = p.maxRetryAfter
+	}
+	return max(wait, retryAfter)
+}
+
+func (p retryPolicy) attemptContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := p.attemptTimeout
+	if timeout <= 0 {
+		timeout = defaultRequestAttemptTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// parseRetryAfter parses a Retry-After header given either in seconds or as an
+// HTTP date. Missing, invalid and past values return 0.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds <= 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	at, err := http.ParseTime(value)
+	if err != nil || !at.After(now) {
+		return 0
+	}
+	return at.Sub(now)
+}
+
+// sleepContext waits for d, returning early with the context error when ctx
+// is done first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// kamateraHTTPClient sends authenticated JSON requests to the Kamatera API and
+// retries failed attempts according to its retry policy. Attempts of all
+// clients of the same Kamatera account share one rate limiter. It is safe for
+// concurrent use.
+type kamateraHTTPClient struct {
+	url       string
+	clientID  string
+	secret    string
+	userAgent string
+	retry     retryPolicy
+	account   *kamateraAccount
+	// httpClient has no client-wide timeout: every attempt is bounded by its
+	// own context, see retryPolicy.attemptTimeout.
+	httpClient *http.Client
+}
+
+func newKamateraHTTPClient(url string, clientID string, secret string, rateLimit KamateraAPIRateLimit) *kamateraHTTPClient {
+	url = strings.TrimSuffix(url, "/")
+	return &kamateraHTTPClient{
+		url:        url,
+		clientID:   clientID,
+		secret:     secret,
+		userAgent:  userAgent,
+		retry:      defaultRetryPolicy(),
+		account:    sharedKamateraAccount(url, clientID, rateLimit),
+		httpClient: &http.Client{},
+	}
+}
+
+// do sends a command, a request that changes state, with body as JSON and
+// returns the decoded JSON response. Error responses are returned as
+// *APIError. Failed attempts are only retried when the request never reached
+// the API.
+func (c *kamateraHTTPClient) do(ctx context.Context, method string, path string, body interface{}) (interface{}, error) {
+	payload, err := marshalPayload(body)
+	if err != nil {
+		return nil, err
+	}
+	return c.send(ctx, method, strings.TrimPrefix(path, "/"), payload, isRetryableCommandError)
+}
+
+// doShared is do for read-only requests, which are retried on any retryable
+// error. Identical requests of the same Kamatera account that are in flight at
+// the same time are sent once and share the response. The shared request is
+// not cancelled when one of its callers gives up, but every caller returns as
+// soon as its own context is done.
+func (c *kamateraHTTPClient) doShared(ctx context.Context, method string, path string, body interface{}) (interface{}, error) {
+	payload, err := marshalPayload(body)
+	if err != nil {
+		return nil, err
+	}
+	path = strings.TrimPrefix(path, "/")
+	key := fmt.Sprintf("%s %s %s", method, path, payload)
+	results := c.account.requests.DoChan(key, func() (interface{}, error) {
+		return c.send(context.WithoutCancel(ctx), method, path, payload, func(err error, _ time.Duration) bool { return isRetryableError(err) })
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-results:
+		if res.Shared {
+			klog.V(4).Infof("kamatera request %s %s shared with concurrent identical requests", method, path)
+		}
+		return res.Val, res.Err
+	}
+}
+
+func marshalPayload(body interface{}) ([]byte, error) {
+	if body == nil {
+		return nil, nil
+	}
+	return json.Marshal(body)
+}
+
+// send sends payload, retrying failed attempts for which retryable returns
+// true, given the error and the Retry-After duration of the attempt.
+func (c *kamateraHTTPClient) send(ctx context.Context, method string, path string, payload []byte, retryable func(error, time.Duration) bool) (interface{}, error) {
+	isQueueRequest := strings.HasPrefix(path, "service/queue")
+	logLevel := klog.Level(2)
+	if isQueueRequest {
+		logLevel = klog.Level(4)
+	}
+	maxAttempts := max(c.retry.maxAttempts, 1)
+	var err error
+	var retryAfter time.Duration
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			wait := c.retry.retryWait(attempt, retryAfter)
+			if !isQueueRequest {
+				klog.V(logLevel).Infof("kamatera request retry %d in %s after: %v", attempt, wait, err)
+			}
+			if e := sleepContext(ctx, wait); e != nil {
+				return nil, fmt.Errorf("gave up retrying kamatera request %s %s: %w (last error: %w)", method, path, e, err)
+			}
+		}
+		if e := c.account.limiter.Wait(ctx); e != nil {
+			if err == nil {
+				return nil, fmt.Errorf("rate limited kamatera request %s %s: %w", method, path, e)
+			}
+			return nil, fmt.Errorf("rate limited kamatera request %s %s: %w (last error: %w)", method, path, e, err)
+		}
+		if !isQueueRequest {
+			klog.V(logLevel).Infof("kamatera request: %s %s/%s %s", method, c.url, path, payload)
+		}
+		var result interface{}
+		result, retryAfter, err = c.attempt(ctx, method, path, payload)
+		if err == nil {
+			return result, nil
+		}
+		if attempt+1 >= maxAttempts || !retryable(err, retryAfter) || ctx.Err() != nil {
+			return nil, err
+		}
+	}
+}
+
+// attempt sends a single request and returns the decoded response and the
+// Retry-After duration of a failed response.
+func (c *kamateraHTTPClient) attempt(ctx context.Context, method string, path string, payload []byte) (interface{}, time.Duration, error) {
+	ctx, cancel := c.retry.attemptContext(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("%s/%s", c.url, path), bytes.NewReader(payload))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("AuthClientId", c.clientID)
+	req.Header.Set("AuthSecret", c.secret)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", c.userAgent)
+	startedAt := time.Now()
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		observeKamateraAPIRequest(method, path, 0, true, time.Since(startedAt))
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(res.Body, maxResponseBytes))
+	var result interface{}
+	if err == nil {
+		err = json.Unmarshal(data, &result)
+	}
+	failed := err != nil || res.StatusCode != http.StatusOK
+	observeKamateraAPIRequest(method, path, res.StatusCode, failed, time.Since(startedAt))
+	if res.StatusCode != http.StatusOK {
+		retryAfter := parseRetryAfter(res.Header.Get("Retry-After"), time.Now())
+		return nil, retryAfter, &APIError{Method: method, Path: path, StatusCode: res.StatusCode, Message: errorMessage(res.StatusCode, data, result)}
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid response from Kamatera API %s %s: %w", method, path, err)
+	}
+	return result, 0, nil
+}
+
+// errorMessage returns the message of an error response: the message field of
+// a JSON object, the JSON value itself, or the beginning of a non-JSON body.
+func errorMessage(statusCode int, data []byte, result interface{}) string {
+	if resultMap, ok := result.(map[string]interface{}); ok {
+		if message, ok := resultMap["message"].(string); ok {
+			return message
+		}
+	}
+	if result != nil {
+		return fmt.Sprintf("%+v", result)
+	}
+	message := strings.TrimSpace(string(data))
+	if len(message) > maxErrorMessageBytes {
+		message = message[:maxErrorMessageBytes] + "..."
+	}
+	if message == "" {
+		message = http.StatusText(statusCode)
+	}
+	return message
+}
@@ -0,0 +1,465 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKamateraHTTPClientHasNoSharedTimeout(t *testing.T) {
+	client := newKamateraHTTPClient("https://example.invalid", "client-id", "secret", DefaultKamateraAPIRateLimit())
+	assert.Zero(t, client.httpClient.Timeout, "attempts should be bounded by their own context instead of a shared client timeout")
+	assert.Equal(t, defaultRequestAttemptTimeout, client.retry.attemptTimeout)
+}
+
+// newTestHTTPClient returns a client for url that retries quickly so tests do
+// not wait for real backoffs.
+func newTestHTTPClient(url string, maxAttempts int) *kamateraHTTPClient {
+	client := newKamateraHTTPClient(url, "client-id", "secret", KamateraAPIRateLimit{})
+	client.retry = retryPolicy{
+		maxAttempts:    maxAttempts,
+		initialBackoff: time.Millisecond,
+		maxBackoff:     5 * time.Millisecond,
+		attemptTimeout: 5 * time.Second,
+		maxRetryAfter:  time.Second,
+	}
+	return client
+}
+
+// recordingHandler answers each request with the next response in responses,
+// repeating the last one, and records the received requests.
+type recordingHandler struct {
+	mu        sync.Mutex
+	requests  []*http.Request
+	bodies    []string
+	responses []func(w http.ResponseWriter)
+}
+
+func (h *recordingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	h.mu.Lock()
+	h.requests = append(h.requests, r)
+	h.bodies = append(h.bodies, string(body))
+	respond := h.responses[min(len(h.bodies), len(h.responses))-1]
+	h.mu.Unlock()
+	respond(w)
+}
+
+func (h *recordingHandler) received() ([]*http.Request, []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]*http.Request(nil), h.requests...), append([]string(nil), h.bodies...)
+}
+
+func respond(statusCode int, body string, headers ...string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		for i := 0; i+1 < len(headers); i += 2 {
+			w.Header().Set(headers[i], headers[i+1])
+		}
+		w.WriteHeader(statusCode)
+		_, _ = io.WriteString(w, body)
+	}
+}
+
+func TestKamateraHTTPClientSendsAuthenticatedJSONRequests(t *testing.T) {
+	handler := &recordingHandler{responses: []func(w http.ResponseWriter){
+		respond(http.StatusOK, `[{"name":"worker1"}]`),
+	}}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	result, err := newTestHTTPClient(server.URL+"/", 1).do(context.Background(), "POST", "/service/server/info", KamateraServerPostRequest{ServerName: "worker1"})
+	if err != nil {
+		t.Fatalf("do: %v", err)
+	}
+	assert.Equal(t, []interface{}{map[string]interface{}{"name": "worker1"}}, result)
+	requests, bodies := handler.received()
+	assert.Len(t, requests, 1)
+	assert.Equal(t, "/service/server/info", requests[0].URL.Path)
+	assert.Equal(t, "client-id", requests[0].Header.Get("AuthClientId"))
+	assert.Equal(t, "secret", requests[0].Header.Get("AuthSecret"))
+	assert.Equal(t, "application/json", requests[0].Header.Get("Content-Type"))
+	assert.Equal(t, userAgent, requests[0].Header.Get("User-Agent"))
+	assert.Equal(t, `{"name":"worker1"}`, bodies[0])
+}
+
+func TestKamateraHTTPClientRetriesServerErrorsAndResendsBody(t *testing.T) {
+	handler := &recordingHandler{responses: []func(w http.ResponseWriter){
+		respond(http.StatusServiceUnavailable, `{"message":"try again"}`),
+		respond(http.StatusOK, `not json`),
+		respond(http.StatusOK, `["1001"]`),
+	}}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	result, err := newTestHTTPClient(server.URL, 3).doShared(context.Background(), "POST", "/service/queue", KamateraQueuePostRequest{ID: "1001"})
+	if err != nil {
+		t.Fatalf("doShared: %v", err)
+	}
+	assert.Equal(t, []interface{}{"1001"}, result)
+	_, bodies := handler.received()
+	want := `{"id":"1001"}`
+	assert.Equal(t, []string{want, want, want}, bodies, "every attempt should send the request body")
+}
+
+func TestKamateraHTTPClientReturnsAPIErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		response       func(w http.ResponseWriter)
+		statusCode     int
+		message        string
+		attempts       int
+		noServersFound bool
+		wantRetryable  bool
+	}{
+		"json message": {
+			response:   respond(http.StatusUnauthorized, `{"message":"Invalid credentials"}`),
+			statusCode: http.StatusUnauthorized, message: "Invalid credentials", attempts: 1,
+		},
+		"plain text": {
+			response:   respond(http.StatusBadGateway, "<html>bad gateway</html>\n"),
+			statusCode: http.StatusBadGateway, message: "<html>bad gateway</html>", attempts: 3, wantRetryable: true,
+		},
+		"empty body": {
+			response:   respond(http.StatusForbidden, ""),
+			statusCode: http.StatusForbidden, message: "Forbidden", attempts: 1,
+		},
+		"no servers found": {
+			response:   respond(http.StatusInternalServerError, `{"message":"No servers found"}`),
+			statusCode: http.StatusInternalServerError, message: noServersFoundMessage, attempts: 1, noServersFound: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			handler := &recordingHandler{responses: []func(w http.ResponseWriter){tc.response}}
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			_, err := newTestHTTPClient(server.URL, 3).doShared(context.Background(), "GET", "/service/servers", nil)
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("expected an APIError, got %v", err)
+			}
+			assert.Equal(t, APIError{Method: "GET", Path: "service/servers", StatusCode: tc.statusCode, Message: tc.message}, *apiErr)
+			assert.Equal(t, tc.noServersFound, isNoServersFound(err))
+			assert.Equal(t, tc.wantRetryable, apiErr.Retryable())
+			requests, _ := handler.received()
+			assert.Len(t, requests, tc.attempts)
+		})
+	}
+}
+
+func TestKamateraHTTPClientTruncatesLongErrorMessages(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = io.WriteString(w, strings.Repeat("x", 10*maxErrorMessageBytes))
+	}))
+	defer server.Close()
+
+	_, err := newTestHTTPClient(server.URL, 1).do(context.Background(), "GET", "/service/servers", nil)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected an APIError, got %v", err)
+	}
+	assert.Equal(t, strings.Repeat("x", maxErrorMessageBytes)+"...", apiErr.Message)
+}
+
+func TestKamateraHTTPClientDoesNotRetryPermanentErrors(t *testing.T) {
+	for _, statusCode := range []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound} {
+		handler := &recordingHandler{responses: []func(w http.ResponseWriter){
+			respond(statusCode, `{"message":"denied"}`),
+		}}
+		server := httptest.NewServer(handler)
+
+		_, err := newTestHTTPClient(server.URL, 5).doShared(context.Background(), "GET", "/service/servers", nil)
+		server.Close()
+		if err == nil {
+			t.Fatalf("expected an error for status %d", statusCode)
+		}
+		requests, _ := handler.received()
+		assert.Len(t, requests, 1, "status %d should not be retried", statusCode)
+	}
+}
+
+func TestKamateraHTTPClientHonoursRetryAfter(t *testing.T) {
+	handler := &recordingHandler{responses: []func(w http.ResponseWriter){
+		respond(http.StatusTooManyRequests, `{"message":"slow down"}`, "Retry-After", "1"),
+		respond(http.StatusOK, `[]`),
+	}}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client := newTestHTTPClient(server.URL, 2)
+	client.retry.maxRetryAfter = 100 * time.Millisecond
+	startedAt := time.Now()
+	if _, err := client.doShared(context.Background(), "GET", "/service/servers", nil); err != nil {
+		t.Fatalf("doShared: %v", err)
+	}
+	elapsed := time.Since(startedAt)
+	requests, _ := handler.received()
+	assert.Len(t, requests, 2)
+	assert.GreaterOrEqual(t, elapsed, 100*time.Millisecond, "the retry should wait for Retry-After")
+	assert.Less(t, elapsed, time.Second, "Retry-After should be capped by maxRetryAfter")
+}
+
+func TestKamateraHTTPClientStopsWaitingWhenContextIsCancelled(t *testing.T) {
+	handler := &recordingHandler{responses: []func(w http.ResponseWriter){
+		respond(http.StatusTooManyRequests, `{"message":"slow down"}`, "Retry-After", "1"),
+	}}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client := newTestHTTPClient(server.URL, 5)
+	client.retry.initialBackoff = time.Hour
+	client.retry.maxBackoff = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	startedAt := time.Now()
+	_, err := client.do(ctx, "POST", "/service/server/power", KamateraServerPowerPostRequest{ServerName: "worker1", Power: "off"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the context error, got %v", err)
+	}
+	var apiErr *APIError
+	assert.True(t, errors.As(err, &apiErr), "the last API error should be kept")
+	assert.Less(t, time.Since(startedAt), 5*time.Second)
+	requests, _ := handler.received()
+	assert.Len(t, requests, 1)
+}
+
+func TestKamateraHTTPClientTimesOutStuckAttempts(t *testing.T) {
+	attempts := 0
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts++
+		mu.Unlock()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	client := newTestHTTPClient(server.URL, 2)
+	client.retry.attemptTimeout = 50 * time.Millisecond
+	startedAt := time.Now()
+	_, err := client.doShared(context.Background(), "GET", "/service/servers", nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected an attempt timeout, got %v", err)
+	}
+	assert.Less(t, time.Since(startedAt), 5*time.Second)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 2, attempts, "timed out attempts should be retried")
+}
+
+func TestKamateraHTTPClientRetriesCommandsOnlyWhenNotReceived(t *testing.T) {
+	for name, tc := range map[string]struct {
+		responses []func(w http.ResponseWriter)
+		attempts  int
+		wantErr   bool
+	}{
+		"server error": {
+			responses: []func(w http.ResponseWriter){respond(http.StatusServiceUnavailable, `{"message":"try again"}`), respond(http.StatusOK, `["1001"]`)},
+			attempts:  1, wantErr: true,
+		},
+		"too many requests without retry-after": {
+			responses: []func(w http.ResponseWriter){respond(http.StatusTooManyRequests, `{"message":"slow down"}`), respond(http.StatusOK, `["1001"]`)},
+			attempts:  1, wantErr: true,
+		},
+		"too many requests with retry-after": {
+			responses: []func(w http.ResponseWriter){respond(http.StatusTooManyRequests, `{"message":"slow down"}`, "Retry-After", "1"), respond(http.StatusOK, `["1001"]`)},
+			attempts:  2,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			handler := &recordingHandler{responses: tc.responses}
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			client := newTestHTTPClient(server.URL, 3)
+			client.retry.maxRetryAfter = time.Millisecond
+			_, err := client.do(context.Background(), "POST", "/service/server/power", KamateraServerPowerPostRequest{ServerName: "worker1", Power: "off"})
+			assert.Equal(t, tc.wantErr, err != nil, "error: %v", err)
+			requests, _ := handler.received()
+			assert.Len(t, requests, tc.attempts)
+		})
+	}
+}
+
+func TestKamateraHTTPClientDoesNotRetryTimedOutCommands(t *testing.T) {
+	attempts := 0
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The body must be read for the server to notice the client giving up.
+		_, _ = io.ReadAll(r.Body)
+		mu.Lock()
+		attempts++
+		mu.Unlock()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	client := newTestHTTPClient(server.URL, 3)
+	client.retry.attemptTimeout = 50 * time.Millisecond
+	if _, err := client.do(context.Background(), "DELETE", "/service/server/terminate", KamateraServerTerminatePostRequest{ServerName: "worker1"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected an attempt timeout, got %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 1, attempts, "a timed out command may have been received and must not be repeated")
+}
+
+func TestIsRetryableCommandErrorRetriesDialErrors(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	_, err = newTestHTTPClient("http://"+addr, 1).do(context.Background(), "POST", "/service/server/power", KamateraServerPowerPostRequest{ServerName: "worker1", Power: "off"})
+	if err == nil {
+		t.Fatalf("expected a connection error")
+	}
+	assert.True(t, isRetryableCommandError(err, 0), "a refused connection never reached the API: %v", err)
+	assert.False(t, isRetryableCommandError(context.DeadlineExceeded, 0))
+	assert.False(t, isRetryableCommandError(&APIError{StatusCode: http.StatusBadGateway}, time.Second))
+}
+
+func TestRetryPolicyBackoffIsJitteredAndCapped(t *testing.T) {
+	policy := retryPolicy{initialBackoff: time.Second, maxBackoff: 10 * time.Second}
+	for retry, base := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 8 * time.Second, 5: 10 * time.Second, 30: 10 * time.Second} {
+		for range 20 {
+			backoff := policy.backoff(retry)
+			assert.GreaterOrEqual(t, backoff, base/2, "retry %d", retry)
+			assert.LessOrEqual(t, backoff, base, "retry %d", retry)
+		}
+	}
+
+	policy.maxRetryAfter = 30 * time.Second
+	assert.Equal(t, 20*time.Second, policy.retryWait(1, 20*time.Second))
+	assert.Equal(t, 30*time.Second, policy.retryWait(1, time.Hour))
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, 5*time.Second, parseRetryAfter("5", now))
+	assert.Equal(t, 90*time.Second, parseRetryAfter(now.Add(90*time.Second).Format(http.TimeFormat), now))
+	assert.Zero(t, parseRetryAfter("", now))
+	assert.Zero(t, parseRetryAfter("-1", now))
+	assert.Zero(t, parseRetryAfter("soon", now))
+	assert.Zero(t, parseRetryAfter(now.Add(-time.Minute).Format(http.TimeFormat), now))
+}
+
+func TestKamateraHTTPClientSharesRateLimitPerAccount(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `[]`)
+	}))
+	defer server.Close()
+
+	limit := KamateraAPIRateLimit{QPS: 5, Burst: 1}
+	first := newKamateraHTTPClient(server.URL, "client-id", "secret", limit)
+	second := newKamateraHTTPClient(server.URL, "client-id", "secret", limit)
+	other := newKamateraHTTPClient(server.URL, "other-client-id", "secret", limit)
+	assert.Same(t, first.account, second.account)
+	assert.NotSame(t, first.account, other.account)
+
+	startedAt := time.Now()
+	for _, client := range []*kamateraHTTPClient{first, second, first} {
+		if _, err := client.do(context.Background(), "GET", "/service/servers", nil); err != nil {
+			t.Fatalf("do: %v", err)
+		}
+	}
+	assert.GreaterOrEqual(t, time.Since(startedAt), 350*time.Millisecond, "clients of one account should share a token bucket")
+
+	startedAt = time.Now()
+	if _, err := other.do(context.Background(), "GET", "/service/servers", nil); err != nil {
+		t.Fatalf("do: %v", err)
+	}
+	assert.Less(t, time.Since(startedAt), 150*time.Millisecond, "other accounts should not be limited")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := first.do(ctx, "GET", "/service/servers", nil); err == nil {
+		t.Fatalf("expected an error when the rate limit wait exceeds the context deadline")
+	}
+}
+
+func TestKamateraHTTPClientCoalescesIdenticalReads(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		received = append(received, r.URL.Path+" "+string(body))
+		mu.Unlock()
+		<-release
+		_, _ = io.WriteString(w, `[{"name":"worker1"}]`)
+	}))
+	defer server.Close()
+	client := newTestHTTPClient(server.URL, 1)
+
+	type call struct {
+		result interface{}
+		err    error
+	}
+	results := make(chan call, 8)
+	read := func(ctx context.Context, path string, body interface{}) {
+		result, err := client.doShared(ctx, "POST", path, body)
+		results <- call{result, err}
+	}
+	for range 4 {
+		go read(context.Background(), "/service/server/info", KamateraServerPostRequest{ServerName: "worker1"})
+	}
+	go read(context.Background(), "/service/server/info", KamateraServerPostRequest{ServerName: "worker2"})
+	for range 2 {
+		go func() {
+			_, err := client.do(context.Background(), "POST", "/service/server/power", KamateraServerPowerPostRequest{ServerName: "worker1", Power: "off"})
+			results <- call{nil, err}
+		}()
+	}
+	cancelled, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	go read(cancelled, "/service/server/info", KamateraServerPostRequest{ServerName: "worker1"})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		count := len(received)
+		mu.Unlock()
+		if count >= 4 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 4 requests, got %d", count)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancelledCall := <-results
+	assert.ErrorIs(t, cancelledCall.err, context.DeadlineExceeded, "a caller should stop waiting for a shared request when its context is done")
+	close(release)
+
+	for range 7 {
+		call := <-results
+		if call.err != nil {
+			t.Fatalf("unexpected error: %v", call.err)
+		}
+		if call.result != nil {
+			assert.Equal(t, []interface{}{map[string]interface{}{"name": "worker1"}}, call.result)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	assert.ElementsMatch(t, []string{
+		`/service/server/info {"name":"worker1"}`,
+		`/service/server/info {"name":"worker2"}`,
+		`/service/server/power {"name":"worker1","power":"off"}`,
+		`/service/server/power {"name":"worker1","power":"off"}`,
+	}, received, "identical reads should be coalesced, other reads and writes should not")
+}
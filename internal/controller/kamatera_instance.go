@@ -0,0 +1,150 @@
+package controller
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// KamateraProviderIDPrefix is the scheme of Kamatera Node provider IDs,
+// which have the form kamatera://<datacenter>/<server-id>.
+const KamateraProviderIDPrefix = "kamatera://"
+
+var invalidLabelValueChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// KamateraProviderID returns the provider ID of a server, or an empty string
+// when the server ID is unknown.
+func KamateraProviderID(server KamateraServer) string {
+	if server.ID == "" || server.Datacenter == "" {
+		return ""
+	}
+	return KamateraProviderIDPrefix + server.Datacenter + "/" + server.ID
+}
+
+// ParseKamateraProviderID returns the datacenter and server ID of a Kamatera
+// provider ID.
+func ParseKamateraProviderID(providerID string) (string, string, error) {
+	if !strings.HasPrefix(providerID, KamateraProviderIDPrefix) {
+		return "", "", fmt.Errorf("provider ID %q does not start with %s", providerID, KamateraProviderIDPrefix)
+	}
+	datacenter, id, ok := strings.Cut(strings.TrimPrefix(providerID, KamateraProviderIDPrefix), "/")
+	if !ok || datacenter == "" || id == "" || strings.Contains(id, "/") {
+		return "", "", fmt.Errorf("provider ID %q is not of the form %s<datacenter>/<server-id>", providerID, KamateraProviderIDPrefix)
+	}
+	return datacenter, id, nil
+}
+
+// KamateraInstanceType returns the instance type of a server from its CPU and
+// RAM, e.g. 2B-4096MB, formatted as a valid label value.
+func KamateraInstanceType(server KamateraServer) string {
+	cpu := invalidLabelValueChars.ReplaceAllString(server.CPU, "")
+	switch {
+	case cpu == "" && server.RAMMB <= 0:
+		return ""
+	case server.RAMMB <= 0:
+		return cpu
+	case cpu == "":
+		return fmt.Sprintf("%dMB", server.RAMMB)
+	default:
+		return fmt.Sprintf("%s-%dMB", cpu, server.RAMMB)
+	}
+}
+
+// NetworkAddressRules decide the Node address type of a server's network
+// interfaces by glob patterns on the Kamatera network name, e.g. wan* or
+// lan-*-private. Networks matching an external pattern are external, networks
+// matching an internal pattern are internal and all others are ignored.
+// Patterns are case-insensitive.
+type NetworkAddressRules struct {
+	ExternalNetworks []string
+	InternalNetworks []string
+}
+
+// DefaultNetworkAddressRules treats wan networks as external and all other
+// networks as internal.
+func DefaultNetworkAddressRules() NetworkAddressRules {
+	return NetworkAddressRules{ExternalNetworks: []string{"wan*"}, InternalNetworks: []string{"*"}}
+}
+
+// NewNetworkAddressRules parses comma-separated network name globs.
+func NewNetworkAddressRules(externalCSV string, internalCSV string) (NetworkAddressRules, error) {
+	var rules NetworkAddressRules
+	var err error
+	if rules.ExternalNetworks, err = parseNetworkGlobs(externalCSV); err != nil {
+		return NetworkAddressRules{}, err
+	}
+	if rules.InternalNetworks, err = parseNetworkGlobs(internalCSV); err != nil {
+		return NetworkAddressRules{}, err
+	}
+	return rules, nil
+}
+
+func parseNetworkGlobs(csv string) ([]string, error) {
+	var globs []string
+	for _, glob := range strings.Split(csv, ",") {
+		glob = strings.ToLower(strings.TrimSpace(glob))
+		if glob == "" {
+			continue
+		}
+		if _, err := filepath.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid network glob %q: %w", glob, err)
+		}
+		globs = append(globs, glob)
+	}
+	return globs, nil
+}
+
+func (r NetworkAddressRules) addressType(networkName string) (corev1.NodeAddressType, bool) {
+	networkName = strings.ToLower(networkName)
+	if matchesAnyGlob(r.ExternalNetworks, networkName) {
+		return corev1.NodeExternalIP, true
+	}
+	if matchesAnyGlob(r.InternalNetworks, networkName) {
+		return corev1.NodeInternalIP, true
+	}
+	return "", false
+}
+
+func matchesAnyGlob(globs []string, name string) bool {
+	for _, glob := range globs {
+		if matched, err := filepath.Match(glob, name); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// NodeAddresses returns the addresses of a server's network interfaces.
+// Internal addresses are returned first.
+func (r NetworkAddressRules) NodeAddresses(server KamateraServer) []corev1.NodeAddress {
+	var internal, external []corev1.NodeAddress
+	for _, network := range server.Networks {
+		addressType, ok := r.addressType(network.Name)
+		if !ok {
+			continue
+		}
+		for _, ip := range network.IPs {
+			if net.ParseIP(ip) == nil {
+				continue
+			}
+			address := corev1.NodeAddress{Type: addressType, Address: ip}
+			if addressType == corev1.NodeInternalIP {
+				internal = append(internal, address)
+			} else {
+				external = append(external, address)
+			}
+		}
+	}
+	return append(internal, external...)
+}
+
+// KamateraNodeAddresses returns the addresses of a server's network
+// interfaces with the default rules: addresses on wan networks are external,
+// all others internal. Internal addresses are returned first.
+func KamateraNodeAddresses(server KamateraServer) []corev1.NodeAddress {
+	return DefaultNetworkAddressRules().NodeAddresses(server)
+}
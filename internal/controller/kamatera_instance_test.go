@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestKamateraProviderIDRoundTrip(t *testing.T) {
+	providerID := KamateraProviderID(KamateraServer{ID: "abc-123", Datacenter: "EU-FR"})
+	if providerID != "kamatera://EU-FR/abc-123" {
+		t.Fatalf("unexpected provider ID %q", providerID)
+	}
+	datacenter, id, err := ParseKamateraProviderID(providerID)
+	if err != nil || datacenter != "EU-FR" || id != "abc-123" {
+		t.Fatalf("unexpected parse result %q %q err=%v", datacenter, id, err)
+	}
+	if KamateraProviderID(KamateraServer{Datacenter: "EU"}) != "" {
+		t.Fatalf("expected no provider ID without server ID")
+	}
+	for _, invalid := range []string{"", "aws:///i-123", "kamatera://EU", "kamatera:///abc", "kamatera://EU/a/b"} {
+		if _, _, err := ParseKamateraProviderID(invalid); err == nil {
+			t.Fatalf("expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestKamateraInstanceType(t *testing.T) {
+	for expected, server := range map[string]KamateraServer{
+		"2B-4096MB": {CPU: "2B", RAMMB: 4096},
+		"4T":        {CPU: "4 T"},
+		"1024MB":    {RAMMB: 1024},
+		"":          {},
+	} {
+		if got := KamateraInstanceType(server); got != expected {
+			t.Fatalf("expected instance type %q, got %q", expected, got)
+		}
+	}
+}
+
+func TestKamateraNodeAddresses(t *testing.T) {
+	server := KamateraServer{Networks: []KamateraServerNetwork{
+		{Name: "wan-eu", IPs: []string{"203.0.113.10", "not-an-ip"}},
+		{Name: "lan-1-private", IPs: []string{"172.16.0.10"}},
+	}}
+	expected := []corev1.NodeAddress{
+		{Type: corev1.NodeInternalIP, Address: "172.16.0.10"},
+		{Type: corev1.NodeExternalIP, Address: "203.0.113.10"},
+	}
+	if got := KamateraNodeAddresses(server); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected addresses %v, got %v", expected, got)
+	}
+}
+
+func TestNetworkAddressRules(t *testing.T) {
+	rules, err := NewNetworkAddressRules("WAN*", "lan-*-private")
+	if err != nil {
+		t.Fatalf("new rules: %v", err)
+	}
+	server := KamateraServer{Networks: []KamateraServerNetwork{
+		{Name: "wan-eu", IPs: []string{"203.0.113.10"}},
+		{Name: "lan-1-private", IPs: []string{"172.16.0.10"}},
+		{Name: "lan-2-storage", IPs: []string{"172.17.0.10"}},
+	}}
+	expected := []corev1.NodeAddress{
+		{Type: corev1.NodeInternalIP, Address: "172.16.0.10"},
+		{Type: corev1.NodeExternalIP, Address: "203.0.113.10"},
+	}
+	if got := rules.NodeAddresses(server); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected addresses %v, got %v", expected, got)
+	}
+	if _, err := NewNetworkAddressRules("[", ""); err == nil {
+		t.Fatalf("expected invalid glob to be rejected")
+	}
+}
@@ -0,0 +1,156 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The Kamatera API is not consistent about value types: IDs and sizes are
+// returned as numbers by some endpoints and as strings by others, and list
+// values are sometimes comma-separated strings. The types below accept all of
+// these representations while still rejecting values of an unrelated shape.
+
+// flexString decodes a JSON string, number or boolean into a string.
+type flexString string
+
+func (s *flexString) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if isJSONNull(data) {
+		*s = ""
+		return nil
+	}
+	switch data[0] {
+	case '"':
+		var value string
+		if err := json.Unmarshal(data, &value); err != nil {
+			return err
+		}
+		*s = flexString(value)
+		return nil
+	case '{', '[':
+		return fmt.Errorf("expected string or number, got %s", data)
+	default:
+		*s = flexString(data)
+		return nil
+	}
+}
+
+// flexInt decodes a JSON number or numeric string into an int. Fractional
+// values are truncated.
+type flexInt int
+
+func (i *flexInt) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if isJSONNull(data) {
+		*i = 0
+		return nil
+	}
+	raw := string(data)
+	if data[0] == '"' {
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return err
+		}
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			*i = 0
+			return nil
+		}
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return fmt.Errorf("expected integer, got %s", data)
+	}
+	*i = flexInt(value)
+	return nil
+}
+
+// flexStringList decodes a JSON array of strings/numbers, or a single
+// comma-separated string, into a list of non-empty strings.
+type flexStringList []string
+
+func (l *flexStringList) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if isJSONNull(data) {
+		*l = nil
+		return nil
+	}
+	if data[0] == '[' {
+		var items []flexString
+		if err := json.Unmarshal(data, &items); err != nil {
+			return err
+		}
+		values := make([]string, 0, len(items))
+		for _, item := range items {
+			if value := strings.TrimSpace(string(item)); value != "" {
+				values = append(values, value)
+			}
+		}
+		*l = values
+		return nil
+	}
+	var value flexString
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	var values []string
+	for _, item := range strings.Split(string(value), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+	*l = values
+	return nil
+}
+
+// flexTime decodes a timestamp in one of the formats used by the Kamatera API,
+// or a unix timestamp in seconds.
+type flexTime time.Time
+
+var flexTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"02/01/2006 15:04:05",
+	"2006-01-02",
+}
+
+func (t *flexTime) UnmarshalJSON(data []byte) error {
+	var raw flexString
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	value := strings.TrimSpace(string(raw))
+	if value == "" {
+		*t = flexTime{}
+		return nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		*t = flexTime(time.Unix(seconds, 0).UTC())
+		return nil
+	}
+	for _, layout := range flexTimeLayouts {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			*t = flexTime(parsed.UTC())
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported time format %q", value)
+}
+
+func isJSONNull(data []byte) bool {
+	return len(data) == 0 || string(data) == "null"
+}
+
+// decodeKamateraResult converts a generic decoded API response into a typed
+// value.
+func decodeKamateraResult(result interface{}, out interface{}) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
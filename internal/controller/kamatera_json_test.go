@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFlexStringAcceptsStringsAndNumbers(t *testing.T) {
+	var values []flexString
+	if err := json.Unmarshal([]byte(`["abc", 123, null, true]`), &values); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	want := []flexString{"abc", "123", "", "true"}
+	if !reflect.DeepEqual(values, want) {
+		t.Fatalf("expected %v, got %v", want, values)
+	}
+	var value flexString
+	if err := json.Unmarshal([]byte(`{"a":1}`), &value); err == nil {
+		t.Fatalf("expected object to be rejected")
+	}
+}
+
+func TestFlexIntAcceptsNumbersAndNumericStrings(t *testing.T) {
+	var values []flexInt
+	if err := json.Unmarshal([]byte(`[4096, "2048", 1.5, "", null]`), &values); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	want := []flexInt{4096, 2048, 1, 0, 0}
+	if !reflect.DeepEqual(values, want) {
+		t.Fatalf("expected %v, got %v", want, values)
+	}
+	var value flexInt
+	if err := json.Unmarshal([]byte(`"lots"`), &value); err == nil {
+		t.Fatalf("expected non-numeric string to be rejected")
+	}
+}
+
+func TestFlexStringListAcceptsArraysAndCSV(t *testing.T) {
+	var fromArray flexStringList
+	if err := json.Unmarshal([]byte(`["a", " b ", ""]`), &fromArray); err != nil {
+		t.Fatalf("unmarshal array: %v", err)
+	}
+	if !reflect.DeepEqual([]string(fromArray), []string{"a", "b"}) {
+		t.Fatalf("unexpected array values: %v", fromArray)
+	}
+	var fromCSV flexStringList
+	if err := json.Unmarshal([]byte(`"a, b,,c"`), &fromCSV); err != nil {
+		t.Fatalf("unmarshal csv: %v", err)
+	}
+	if !reflect.DeepEqual([]string(fromCSV), []string{"a", "b", "c"}) {
+		t.Fatalf("unexpected csv values: %v", fromCSV)
+	}
+}
+
+func TestFlexTimeAcceptsKnownFormats(t *testing.T) {
+	want := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	for _, input := range []string{`"2026-01-15T12:00:00Z"`, `"2026-01-15 12:00:00"`, `1768478400`} {
+		var value flexTime
+		if err := json.Unmarshal([]byte(input), &value); err != nil {
+			t.Fatalf("unmarshal %s: %v", input, err)
+		}
+		if !time.Time(value).Equal(want) {
+			t.Fatalf("expected %v for %s, got %v", want, input, time.Time(value))
+		}
+	}
+	var value flexTime
+	if err := json.Unmarshal([]byte(`"yesterday"`), &value); err == nil {
+		t.Fatalf("expected unsupported format to be rejected")
+	}
+}
@@ -0,0 +1,153 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// serverInfoBatchSize bounds the number of servers looked up by a single
+	// server info request.
+	serverInfoBatchSize = 20
+	// serverDetailsMaxAge is the number of server list generations the details
+	// of a server are reused for before they are fetched again, so changed
+	// networks and tags are picked up without fetching every server on every
+	// poll.
+	serverDetailsMaxAge = 10
+)
+
+// serverInfoNamePattern returns the server info name regular expression that
+// matches exactly the given server names.
+func serverInfoNamePattern(names []string) string {
+	quoted := make([]string, 0, len(names))
+	for _, name := range names {
+		quoted = append(quoted, regexp.QuoteMeta(name))
+	}
+	return "^(?:" + strings.Join(quoted, "|") + ")$"
+}
+
+// serverDetailsKey identifies a server across server list generations. The ID
+// is part of the key so a server recreated with the same name is fetched again.
+func serverDetailsKey(server KamateraServer) string {
+	return server.Datacenter + "\x00" + server.Name + "\x00" + server.ID
+}
+
+type serverDetailsEntry struct {
+	server     KamateraServer
+	generation uint64
+}
+
+// serverDetailsCache holds the details of listed servers returned by the server
+// info endpoint: the servers list only returns their ID, name, datacenter and
+// power. Every server list is a new generation; details are kept for the
+// servers of the current generation and fetched again once they are
+// serverDetailsMaxAge generations old. It is safe for concurrent use.
+type serverDetailsCache struct {
+	mu         sync.Mutex
+	generation uint64
+	entries    map[string]serverDetailsEntry
+}
+
+func newServerDetailsCache() *serverDetailsCache {
+	return &serverDetailsCache{entries: map[string]serverDetailsEntry{}}
+}
+
+// next starts a new generation with the given listed servers, dropping the
+// details of servers that are no longer listed, and returns the generation
+// and the names of the servers whose details need to be fetched.
+func (c *serverDetailsCache) next(servers []KamateraServer) (uint64, []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	listed := make(map[string]struct{}, len(servers))
+	seen := map[string]struct{}{}
+	var names []string
+	for _, server := range servers {
+		key := serverDetailsKey(server)
+		listed[key] = struct{}{}
+		if entry, ok := c.entries[key]; ok && c.generation-entry.generation < serverDetailsMaxAge {
+			continue
+		}
+		if _, ok := seen[server.Name]; !ok {
+			seen[server.Name] = struct{}{}
+			names = append(names, server.Name)
+		}
+	}
+	for key := range c.entries {
+		if _, ok := listed[key]; !ok {
+			delete(c.entries, key)
+		}
+	}
+	return c.generation, names
+}
+
+// store records server details fetched in the given generation.
+func (c *serverDetailsCache) store(generation uint64, servers []KamateraServer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, server := range servers {
+		c.entries[serverDetailsKey(server)] = serverDetailsEntry{server: server, generation: generation}
+	}
+}
+
+// apply returns the listed servers with their cached details. The power state
+// of the list is kept, since it is at least as recent as the details. Servers
+// without details, e.g. terminated between the list and the info request, are
+// returned as listed.
+func (c *serverDetailsCache) apply(servers []KamateraServer) []KamateraServer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	detailed := make([]KamateraServer, 0, len(servers))
+	for _, server := range servers {
+		entry, ok := c.entries[serverDetailsKey(server)]
+		if !ok {
+			detailed = append(detailed, server)
+			continue
+		}
+		details := entry.server
+		details.Power = server.Power
+		detailed = append(detailed, details)
+	}
+	return detailed
+}
+
+// withServerDetails fills in the details of listed servers from the server
+// info endpoint, looking up servers whose cached details are missing or too
+// old in batches of serverInfoBatchSize. The lookups go through the account
+// rate limiter like all requests. A failed batch does not fail the list: its
+// servers keep their cached details, if any, and are otherwise returned as
+// listed until a later list fetches them.
+func (c *KamateraApiClientRest) withServerDetails(ctx context.Context, servers []KamateraServer) []KamateraServer {
+	generation, names := c.details.next(servers)
+	for start := 0; start < len(names); start += serverInfoBatchSize {
+		batch := names[start:min(start+serverInfoBatchSize, len(names))]
+		infos, err := c.serverInfoBatch(ctx, batch)
+		if err != nil {
+			kamateraServerDetailsFailures.Inc()
+			klog.Errorf("failed to get details of %d Kamatera servers, using the listed servers with their cached details: %v", len(batch), err)
+			continue
+		}
+		c.details.store(generation, infos)
+	}
+	return c.details.apply(servers)
+}
+
+func (c *KamateraApiClientRest) serverInfoBatch(ctx context.Context, names []string) ([]KamateraServer, error) {
+	res, err := c.http.doShared(ctx, "POST", "/service/server/info", KamateraServerPostRequest{ServerName: serverInfoNamePattern(names)})
+	if isNoServersFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	infos, err := decodeKamateraServers(res)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server info response: %w", err)
+	}
+	return infos, nil
+}
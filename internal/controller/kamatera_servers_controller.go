@@ -2,14 +2,30 @@ package controller
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 )
 
-const defaultKamateraServerListInterval = time.Minute
+const (
+	defaultKamateraServerListInterval  = time.Minute
+	defaultServerListDropConfirmations = 3
+)
 
+// KamateraServersController periodically lists the Kamatera servers into the
+// server store. A list that dropped too much since the stored one is held
+// back, and the stored list kept and marked suspicious, until
+// DropConfirmations consecutive polls confirm the drop. An empty list is
+// stored marked suspicious. Both keep Nodes from being deleted for their
+// server being absent.
+// When a Recorder is configured, it records Events on the matched Nodes for
+// server power changes and removals, and for Nodes that become matched to,
+// unmatched from or ambiguously matched to Kamatera servers.
 type KamateraServersController struct {
 	Client    kamateraAPIClient
 	Store     *ServerStateStore
@@ -18,7 +34,31 @@ type KamateraServersController struct {
 	Filter    ServerFilter
 	Interval  time.Duration
 
+	// AllowEmptyList accepts an empty filtered server list as valid. Otherwise
+	// an empty list is marked suspicious.
+	AllowEmptyList bool
+	// MaxDropPercent marks the server list suspicious when it has more than
+	// this percentage fewer servers than the stored list. 0 disables the
+	// check.
+	MaxDropPercent int
+	// DropConfirmations is the number of consecutive polls that must show a
+	// drop of more than MaxDropPercent before the dropped list is stored.
+	// Defaults to 3; 1 stores it on the first poll.
+	DropConfirmations int
+
+	Recorder record.EventRecorder
+
 	Log logr.Logger
+
+	// matchedServers maps node names to the name of their matched server as
+	// of the previous poll.
+	matchedServers map[string]string
+	// ambiguousNodes holds the nodes that matched several servers as of the
+	// previous poll.
+	ambiguousNodes map[string]struct{}
+	// pendingDrops counts the consecutive polls whose list was held back for
+	// dropping too much.
+	pendingDrops int
 }
 
 func (c *KamateraServersController) Start(ctx context.Context) error {
@@ -30,7 +70,7 @@ func (c *KamateraServersController) Start(ctx context.Context) error {
 		interval = defaultKamateraServerListInterval
 	}
 	if err := c.poll(ctx); err != nil {
-		c.Log.Error(err, "failed to list Kamatera servers")
+		c.Log.Error(err, "failed to list Kamatera servers", "consecutiveFailures", c.Store.ConsecutiveFailures())
 	}
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
@@ -40,7 +80,7 @@ func (c *KamateraServersController) Start(ctx context.Context) error {
 			return nil
 		case <-ticker.C:
 			if err := c.poll(ctx); err != nil {
-				c.Log.Error(err, "failed to list Kamatera servers")
+				c.Log.Error(err, "failed to list Kamatera servers", "consecutiveFailures", c.Store.ConsecutiveFailures())
 			}
 		}
 	}
@@ -53,6 +93,7 @@ func (c *KamateraServersController) NeedLeaderElection() bool {
 func (c *KamateraServersController) poll(ctx context.Context) error {
 	servers, err := c.Client.ListServers(ctx)
 	if err != nil {
+		c.Store.RecordRefreshFailure()
 		return err
 	}
 	filtered := make([]KamateraServer, 0, len(servers))
@@ -61,11 +102,49 @@ func (c *KamateraServersController) poll(ctx context.Context) error {
 			filtered = append(filtered, server)
 		}
 	}
-	diff := c.Store.Replace(filtered)
+	initial := !c.Store.Initialized()
+	previousCount := c.Store.Len()
+	if !initial && c.MaxDropPercent > 0 && (previousCount-len(filtered))*100 > c.MaxDropPercent*previousCount {
+		reason := fmt.Sprintf("the filtered Kamatera server list dropped from %d to %d servers", previousCount, len(filtered))
+		c.pendingDrops++
+		if c.pendingDrops < c.dropConfirmations() {
+			c.Log.Info("holding back Kamatera server list until the drop is confirmed", "reason", reason, "confirmations", c.pendingDrops, "required", c.dropConfirmations())
+			previous := c.Store.Suspicious()
+			c.Store.SetSuspicious(reason)
+			c.logSuspicious(previous, reason)
+			return nil
+		}
+		c.Log.Info("Kamatera server list drop confirmed", "reason", reason, "confirmations", c.pendingDrops)
+	}
+	c.pendingDrops = 0
+
+	reason := ""
+	if len(filtered) == 0 && !c.AllowEmptyList {
+		reason = "the filtered Kamatera server list is empty"
+	}
+	previous := c.Store.Suspicious()
+	diff := c.Store.replace(filtered, servers, reason)
+	c.logSuspicious(previous, reason)
 	c.logDiff(diff)
+	c.recordMatchChanges(diff.Initial)
 	return nil
 }
 
+func (c *KamateraServersController) dropConfirmations() int {
+	if c.DropConfirmations <= 0 {
+		return defaultServerListDropConfirmations
+	}
+	return c.DropConfirmations
+}
+
+func (c *KamateraServersController) logSuspicious(previous string, reason string) {
+	if reason != "" && reason != previous {
+		c.Log.Error(nil, "suspicious Kamatera server list, not deleting nodes whose server is absent", "reason", reason)
+	} else if reason == "" && previous != "" {
+		c.Log.Info("Kamatera server list is no longer suspicious", "previousReason", previous)
+	}
+}
+
 func (c *KamateraServersController) logDiff(diff ServerStateDiff) {
 	if diff.Initial {
 		for _, server := range diff.Current {
@@ -78,19 +157,84 @@ func (c *KamateraServersController) logDiff(diff ServerStateDiff) {
 	}
 	for _, server := range diff.Removed {
 		c.Log.Info("server removed", c.serverLogValues(server)...)
+		if node, ok := c.Matcher.FindNodeForServer(server, c.NodeStore); ok {
+			c.event(node, corev1.EventTypeWarning, "KamateraServerRemoved", fmt.Sprintf("Kamatera server %s in datacenter %s is no longer listed", server.Name, server.Datacenter))
+		}
 	}
 	for _, change := range diff.PowerChanged {
-		server := KamateraServer{Name: change.Name, Datacenter: change.Datacenter, Power: change.NewPower}
-		c.Log.Info("server power changed", append(c.serverLogValues(server), "oldPower", change.OldPower, "newPower", change.NewPower)...)
+		c.Log.Info("server power changed", append(c.serverLogValues(change.Server), "oldPower", change.OldPower, "newPower", change.NewPower)...)
+		if node, ok := c.Matcher.FindNodeForServer(change.Server, c.NodeStore); ok {
+			eventType := corev1.EventTypeNormal
+			if change.NewPower == "off" {
+				eventType = corev1.EventTypeWarning
+			}
+			c.event(node, eventType, "KamateraServerPowerChanged", fmt.Sprintf("Kamatera server %s power changed from %s to %s", change.Server.Name, change.OldPower, change.NewPower))
+		}
+	}
+}
+
+// recordMatchChanges records Events for Nodes whose matched Kamatera server
+// changed since the previous poll. The first poll only establishes the
+// baseline, so restarting the controller does not emit an Event per Node.
+// Nodes that become ambiguously matched are reported from the first poll on,
+// since they need an operator.
+func (c *KamateraServersController) recordMatchChanges(initial bool) {
+	if c.NodeStore == nil {
+		return
+	}
+	previous := c.matchedServers
+	current := map[string]string{}
+	ambiguous := map[string]struct{}{}
+	for _, node := range c.NodeStore.List() {
+		server, outcome := c.Matcher.MatchServerForNode(node, c.Store)
+		matched := outcome == MatchUnique
+		if matched {
+			current[node.Name] = server.Name
+		}
+		if outcome == MatchAmbiguous {
+			ambiguous[node.Name] = struct{}{}
+			if _, was := c.ambiguousNodes[node.Name]; !was {
+				c.Log.Info("node matches several Kamatera servers, it is not remediated or deleted until the match is unique", "nodeName", node.Name)
+				c.event(node, corev1.EventTypeWarning, "KamateraServerAmbiguous", "Node matches several Kamatera servers and is not remediated or deleted until the match is unique")
+			}
+		}
+		if initial || previous == nil {
+			continue
+		}
+		previousServer, wasMatched := previous[node.Name]
+		switch {
+		case matched && (!wasMatched || previousServer != server.Name):
+			c.event(node, corev1.EventTypeNormal, "KamateraServerMatched", fmt.Sprintf("Node matched to Kamatera server %s in datacenter %s", server.Name, server.Datacenter))
+		case !matched && wasMatched:
+			c.event(node, corev1.EventTypeWarning, "KamateraServerUnmatched", fmt.Sprintf("Node is no longer matched to Kamatera server %s", previousServer))
+		}
+	}
+	c.matchedServers = current
+	c.ambiguousNodes = ambiguous
+}
+
+func (c *KamateraServersController) event(node NodeSnapshot, eventType string, reason string, message string) {
+	if c.Recorder != nil {
+		c.Recorder.Event(nodeEventObject(node), eventType, reason, message)
 	}
 }
 
+// nodeEventObject returns a Node object that identifies a node snapshot as
+// the involved object of an Event.
+func nodeEventObject(node NodeSnapshot) *corev1.Node {
+	return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: node.Name, UID: node.UID}}
+}
+
 func (c *KamateraServersController) serverLogValues(server KamateraServer) []interface{} {
-	node, matched := c.Matcher.FindNodeForServer(server.Name, c.NodeStore)
+	node, matched := c.Matcher.FindNodeForServer(server, c.NodeStore)
 	return []interface{}{
 		"name", server.Name,
 		"datacenter", server.Datacenter,
 		"power", server.Power,
+		"id", server.ID,
+		"cpu", server.CPU,
+		"ramMB", server.RAMMB,
+		"ips", server.IPs(),
 		"matchedNode", matched,
 		"nodeName", node.Name,
 		"nodeReady", node.Ready,
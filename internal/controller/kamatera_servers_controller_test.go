@@ -2,6 +2,8 @@ package controller
 
 import (
 	"context"
+	"errors"
+	"reflect"
 	"testing"
 	"time"
 
@@ -9,6 +11,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
@@ -144,3 +147,164 @@ func TestKamateraServersControllerPollDoesNotDeleteUnmatchedNode(t *testing.T) {
 		t.Fatalf("expected unmatched node to remain: %v", err)
 	}
 }
+
+func TestKamateraServersControllerRecordsNodeEvents(t *testing.T) {
+	nodeStore := NewNodeStateStore()
+	nodeStore.Replace(NodeSnapshot{Name: "worker1", UID: "worker1-uid"})
+	kclient := kamateraClientMock{}
+	kclient.On("ListServers", context.Background()).Return([]KamateraServer{{Name: "worker1", Datacenter: "EU", Power: "on"}}, nil).Once()
+	kclient.On("ListServers", context.Background()).Return([]KamateraServer{
+		{Name: "worker1", Datacenter: "EU", Power: "off"},
+		{Name: "worker2", Datacenter: "EU", Power: "on"},
+	}, nil).Once()
+	kclient.On("ListServers", context.Background()).Return([]KamateraServer{{Name: "worker2", Datacenter: "EU", Power: "on"}}, nil).Once()
+	recorder := record.NewFakeRecorder(10)
+	controller := KamateraServersController{Client: &kclient, Store: NewServerStateStore(), NodeStore: nodeStore, Recorder: recorder, Log: logr.Discard()}
+
+	if err := controller.poll(context.Background()); err != nil {
+		t.Fatalf("initial poll: %v", err)
+	}
+	if events := recordedEvents(recorder); len(events) != 0 {
+		t.Fatalf("expected no events for the initial state, got %v", events)
+	}
+
+	nodeStore.Replace(NodeSnapshot{Name: "worker2", UID: "worker2-uid"})
+	if err := controller.poll(context.Background()); err != nil {
+		t.Fatalf("second poll: %v", err)
+	}
+	expected := []string{
+		"Warning KamateraServerPowerChanged Kamatera server worker1 power changed from on to off",
+		"Normal KamateraServerMatched Node matched to Kamatera server worker2 in datacenter EU",
+	}
+	if events := recordedEvents(recorder); !reflect.DeepEqual(events, expected) {
+		t.Fatalf("expected events %v, got %v", expected, events)
+	}
+
+	if err := controller.poll(context.Background()); err != nil {
+		t.Fatalf("third poll: %v", err)
+	}
+	expected = []string{
+		"Warning KamateraServerRemoved Kamatera server worker1 in datacenter EU is no longer listed",
+		"Warning KamateraServerUnmatched Node is no longer matched to Kamatera server worker1",
+	}
+	if events := recordedEvents(recorder); !reflect.DeepEqual(events, expected) {
+		t.Fatalf("expected events %v, got %v", expected, events)
+	}
+}
+
+func TestKamateraServersControllerRecordsAmbiguousMatchEvents(t *testing.T) {
+	nodeStore := NewNodeStateStore()
+	nodeStore.Replace(NodeSnapshot{Name: "worker1", UID: "worker1-uid"})
+	ambiguous := []KamateraServer{
+		{Name: "worker1", Datacenter: "EU", Power: "on"},
+		{Name: "worker1", Datacenter: "US", Power: "on"},
+	}
+	kclient := kamateraClientMock{}
+	kclient.On("ListServers", context.Background()).Return(ambiguous, nil).Twice()
+	kclient.On("ListServers", context.Background()).Return(ambiguous[:1], nil).Once()
+	recorder := record.NewFakeRecorder(10)
+	controller := KamateraServersController{Client: &kclient, Store: NewServerStateStore(), NodeStore: nodeStore, Recorder: recorder, Log: logr.Discard()}
+
+	for i := 0; i < 2; i++ {
+		if err := controller.poll(context.Background()); err != nil {
+			t.Fatalf("poll %d: %v", i, err)
+		}
+	}
+	expected := []string{"Warning KamateraServerAmbiguous Node matches several Kamatera servers and is not remediated or deleted until the match is unique"}
+	if events := recordedEvents(recorder); !reflect.DeepEqual(events, expected) {
+		t.Fatalf("expected a single ambiguous event from the first poll on, got %v", events)
+	}
+
+	if err := controller.poll(context.Background()); err != nil {
+		t.Fatalf("third poll: %v", err)
+	}
+	expected = []string{
+		"Warning KamateraServerRemoved Kamatera server worker1 in datacenter US is no longer listed",
+		"Normal KamateraServerMatched Node matched to Kamatera server worker1 in datacenter EU",
+	}
+	if events := recordedEvents(recorder); !reflect.DeepEqual(events, expected) {
+		t.Fatalf("expected events %v, got %v", expected, events)
+	}
+}
+
+func TestKamateraServersControllerPollCountsListFailures(t *testing.T) {
+	store := NewServerStateStore()
+	kclient := kamateraClientMock{}
+	kclient.On("ListServers", context.Background()).Return([]KamateraServer{{Name: "worker1", Datacenter: "EU", Power: "on"}}, nil).Once()
+	kclient.On("ListServers", context.Background()).Return([]KamateraServer(nil), errors.New("unavailable")).Once()
+	controller := KamateraServersController{Client: &kclient, Store: store, Log: logr.Discard()}
+
+	if err := controller.poll(context.Background()); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if err := controller.poll(context.Background()); err == nil {
+		t.Fatalf("expected poll to fail")
+	}
+
+	if got := store.ConsecutiveFailures(); got != 1 {
+		t.Fatalf("expected one consecutive failure, got %d", got)
+	}
+	if _, ok := store.Get("worker1"); !ok {
+		t.Fatalf("expected previous snapshot to be kept")
+	}
+}
+
+func TestKamateraServersControllerMarksEmptyOrDroppedListSuspicious(t *testing.T) {
+	store := NewServerStateStore()
+	kclient := kamateraClientMock{}
+	workers := []KamateraServer{
+		{Name: "worker1", Datacenter: "EU", Power: "on"},
+		{Name: "worker2", Datacenter: "EU", Power: "on"},
+		{Name: "worker3", Datacenter: "EU", Power: "on"},
+		{Name: "worker4", Datacenter: "EU", Power: "on"},
+	}
+	kclient.On("ListServers", context.Background()).Return([]KamateraServer(nil), nil).Once()
+	kclient.On("ListServers", context.Background()).Return(workers, nil).Once()
+	kclient.On("ListServers", context.Background()).Return(workers[:1], nil).Times(3)
+	controller := KamateraServersController{Client: &kclient, Store: store, MaxDropPercent: 50, DropConfirmations: 3, Log: logr.Discard()}
+
+	expected := []struct {
+		reason  string
+		servers int
+	}{
+		{"the filtered Kamatera server list is empty", 0},
+		{"", 4},
+		{"the filtered Kamatera server list dropped from 4 to 1 servers", 4},
+		{"the filtered Kamatera server list dropped from 4 to 1 servers", 4},
+		{"", 1},
+	}
+	for i, poll := range expected {
+		if err := controller.poll(context.Background()); err != nil {
+			t.Fatalf("poll %d: %v", i+1, err)
+		}
+		if got := store.Suspicious(); got != poll.reason {
+			t.Fatalf("poll %d: expected suspicious reason %q, got %q", i+1, poll.reason, got)
+		}
+		if got := store.Len(); got != poll.servers {
+			t.Fatalf("poll %d: expected %d stored servers, got %d", i+1, poll.servers, got)
+		}
+	}
+}
+
+func TestKamateraServersControllerResetsDropConfirmationsWhenListRecovers(t *testing.T) {
+	store := NewServerStateStore()
+	kclient := kamateraClientMock{}
+	workers := []KamateraServer{
+		{Name: "worker1", Datacenter: "EU", Power: "on"},
+		{Name: "worker2", Datacenter: "EU", Power: "on"},
+	}
+	kclient.On("ListServers", context.Background()).Return(workers, nil).Once()
+	kclient.On("ListServers", context.Background()).Return(workers[:0], nil).Once()
+	kclient.On("ListServers", context.Background()).Return(workers, nil).Once()
+	kclient.On("ListServers", context.Background()).Return(workers[:0], nil).Once()
+	controller := KamateraServersController{Client: &kclient, Store: store, MaxDropPercent: 50, DropConfirmations: 2, Log: logr.Discard()}
+
+	for i := 1; i <= 4; i++ {
+		if err := controller.poll(context.Background()); err != nil {
+			t.Fatalf("poll %d: %v", i, err)
+		}
+	}
+	if store.Len() != 2 || store.Suspicious() == "" {
+		t.Fatalf("expected a drop after a recovery to be held back again, got %d servers, suspicious %q", store.Len(), store.Suspicious())
+	}
+}
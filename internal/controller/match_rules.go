@@ -0,0 +1,155 @@
+package controller
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+// MatchRulesFile is the file of ordered node/server name matching rules, e.g.
+//
+//	rules:
+//	  - name: control-plane
+//	    nodeSelector: node-role.kubernetes.io/control-plane=true
+//	    nodeToServerTemplate: cp-%s
+//	  - name: workers
+//	    serverDatacenters: [EU, EU-FR]
+//	    nodeToServerTemplate: '{{ . | trimPrefix "rke2-" }}'
+type MatchRulesFile struct {
+	Rules []MatchRule `json:"rules"`
+}
+
+// MatchRule matches Nodes to Kamatera servers by name. A rule only applies to
+// Nodes selected by NodeSelector and servers in ServerDatacenters; empty
+// scopes select everything. At most one of the templates may be set, none
+// matches exact names.
+type MatchRule struct {
+	Name                 string   `json:"name,omitempty"`
+	NodeSelector         string   `json:"nodeSelector,omitempty"`
+	ServerDatacenters    []string `json:"serverDatacenters,omitempty"`
+	NodeToServerTemplate string   `json:"nodeToServerTemplate,omitempty"`
+	ServerToNodeTemplate string   `json:"serverToNodeTemplate,omitempty"`
+}
+
+// LoadMatchRules reads a YAML or JSON matching rules file.
+func LoadMatchRules(path string) ([]MatchRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read matching rules: %w", err)
+	}
+	var file MatchRulesFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("parse matching rules %s: %w", path, err)
+	}
+	if len(file.Rules) == 0 {
+		return nil, fmt.Errorf("matching rules %s contain no rules", path)
+	}
+	return file.Rules, nil
+}
+
+type matchRule struct {
+	nodeSelector labels.Selector
+	datacenters  map[string]struct{}
+	nodeToServer *nameTransform
+	serverToNode *nameTransform
+}
+
+func newMatchRule(rule MatchRule) (matchRule, error) {
+	if strings.TrimSpace(rule.NodeToServerTemplate) != "" && strings.TrimSpace(rule.ServerToNodeTemplate) != "" {
+		return matchRule{}, fmt.Errorf("only one matching template can be configured")
+	}
+	var parsed matchRule
+	var err error
+	if parsed.nodeToServer, err = parseNameTransform(rule.NodeToServerTemplate); err != nil {
+		return matchRule{}, fmt.Errorf("invalid node-to-server template: %w", err)
+	}
+	if parsed.serverToNode, err = parseNameTransform(rule.ServerToNodeTemplate); err != nil {
+		return matchRule{}, fmt.Errorf("invalid server-to-node template: %w", err)
+	}
+	if selector := strings.TrimSpace(rule.NodeSelector); selector != "" {
+		if parsed.nodeSelector, err = labels.Parse(selector); err != nil {
+			return matchRule{}, fmt.Errorf("invalid node selector: %w", err)
+		}
+	}
+	for _, datacenter := range rule.ServerDatacenters {
+		if datacenter = strings.TrimSpace(datacenter); datacenter == "" {
+			continue
+		}
+		if parsed.datacenters == nil {
+			parsed.datacenters = map[string]struct{}{}
+		}
+		parsed.datacenters[datacenter] = struct{}{}
+	}
+	return parsed, nil
+}
+
+func matchRuleName(rule MatchRule, index int) string {
+	if rule.Name != "" {
+		return fmt.Sprintf("%q", rule.Name)
+	}
+	return fmt.Sprintf("#%d", index+1)
+}
+
+func (r matchRule) matchesNode(node NodeSnapshot) bool {
+	return r.nodeSelector == nil || r.nodeSelector.Matches(labels.Set(node.Labels))
+}
+
+func (r matchRule) matchesServer(server KamateraServer) bool {
+	if r.datacenters == nil {
+		return true
+	}
+	_, ok := r.datacenters[server.Datacenter]
+	return ok
+}
+
+func (r matchRule) matchNames(nodeName string, serverName string) bool {
+	if r.nodeToServer != nil {
+		transformed, ok := r.nodeToServer.apply(nodeName)
+		return ok && transformed == serverName
+	}
+	if r.serverToNode != nil {
+		transformed, ok := r.serverToNode.apply(serverName)
+		return ok && transformed == nodeName
+	}
+	return nodeName == serverName
+}
+
+// serverCandidates looks up the servers whose names match nodeName by the
+// rule's templates, regardless of the rule scopes.
+func (r matchRule) serverCandidates(nodeName string, store *ServerStateStore) []KamateraServer {
+	switch {
+	case r.nodeToServer != nil:
+		serverName, ok := r.nodeToServer.apply(nodeName)
+		if !ok {
+			return nil
+		}
+		return store.serversNamed(serverName)
+	case r.serverToNode != nil:
+		return store.serversWithTransformedName(r.serverToNode, nodeName)
+	default:
+		return store.serversNamed(nodeName)
+	}
+}
+
+// nodeCandidates looks up the nodes whose names match serverName by the
+// rule's templates, regardless of the rule scopes.
+func (r matchRule) nodeCandidates(serverName string, store *NodeStateStore) []NodeSnapshot {
+	nodeName := serverName
+	switch {
+	case r.nodeToServer != nil:
+		return store.nodesWithTransformedName(r.nodeToServer, serverName)
+	case r.serverToNode != nil:
+		var ok bool
+		if nodeName, ok = r.serverToNode.apply(serverName); !ok {
+			return nil
+		}
+	}
+	node, ok := store.Get(nodeName)
+	if !ok {
+		return nil
+	}
+	return []NodeSnapshot{node}
+}
@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNameMatcherTriesRulesInOrderWithScopes(t *testing.T) {
+	matcher, err := NewNameMatcherFromRules([]MatchRule{
+		{Name: "control-plane", NodeSelector: "node-role.kubernetes.io/control-plane=true", NodeToServerTemplate: "cp-%s"},
+		{Name: "eu-workers", ServerDatacenters: []string{"EU"}, NodeToServerTemplate: `{{ . | trimPrefix "pool-" }}`},
+		{Name: "exact"},
+	})
+	if err != nil {
+		t.Fatalf("new matcher: %v", err)
+	}
+	store := NewServerStateStore()
+	store.Replace([]KamateraServer{
+		{ID: "id-1", Name: "cp-master1", Datacenter: "EU"},
+		{ID: "id-2", Name: "worker1", Datacenter: "EU"},
+		{ID: "id-3", Name: "worker1", Datacenter: "US"},
+		{ID: "id-4", Name: "pool-worker2", Datacenter: "US"},
+		{ID: "id-5", Name: "master1", Datacenter: "EU"},
+	})
+	controlPlane := NodeSnapshot{Name: "master1", Labels: map[string]string{"node-role.kubernetes.io/control-plane": "true"}}
+
+	for _, tt := range []struct {
+		node NodeSnapshot
+		id   string
+	}{
+		{node: controlPlane, id: "id-1"},
+		{node: NodeSnapshot{Name: "master1"}, id: "id-5"},
+		{node: NodeSnapshot{Name: "pool-worker1"}, id: "id-2"},
+		{node: NodeSnapshot{Name: "pool-worker2"}, id: "id-4"},
+	} {
+		server, ok := matcher.FindServerForNode(tt.node, store)
+		if !ok || server.ID != tt.id {
+			t.Fatalf("node %s: expected server %s, got %+v ok=%v", tt.node.Name, tt.id, server, ok)
+		}
+	}
+
+	nodes := NewNodeStateStore()
+	nodes.Replace(controlPlane)
+	nodes.Replace(NodeSnapshot{Name: "pool-worker1"})
+	for id, expected := range map[string]string{"id-1": "master1", "id-2": "pool-worker1"} {
+		server, _ := store.GetByID("EU", id)
+		node, ok := matcher.FindNodeForServer(server, nodes)
+		if !ok || node.Name != expected {
+			t.Fatalf("server %s: expected node %s, got %+v ok=%v", id, expected, node, ok)
+		}
+	}
+	if node, ok := matcher.FindNodeForServer(KamateraServer{ID: "id-3", Name: "worker1", Datacenter: "US"}, nodes); ok {
+		t.Fatalf("did not expect US server outside the worker rule scope to match, got %+v", node)
+	}
+}
+
+func TestNameMatcherFallsThroughAmbiguousRules(t *testing.T) {
+	matcher, err := NewNameMatcherFromRules([]MatchRule{
+		{NodeToServerTemplate: "{{ . | lower }}"},
+		{ServerDatacenters: []string{"US"}, NodeToServerTemplate: "{{ . | lower }}"},
+	})
+	if err != nil {
+		t.Fatalf("new matcher: %v", err)
+	}
+	store := NewServerStateStore()
+	store.Replace([]KamateraServer{
+		{ID: "id-1", Name: "worker1", Datacenter: "EU"},
+		{ID: "id-2", Name: "worker1", Datacenter: "US"},
+	})
+	server, ok := matcher.FindServerForNode(NodeSnapshot{Name: "Worker1"}, store)
+	if !ok || server.ID != "id-2" {
+		t.Fatalf("expected second rule to resolve the ambiguous first rule, got %+v ok=%v", server, ok)
+	}
+}
+
+func TestNewNameMatcherFromRulesRejectsInvalidRules(t *testing.T) {
+	for _, rules := range [][]MatchRule{
+		nil,
+		{{NodeToServerTemplate: "a-%s", ServerToNodeTemplate: "b-%s"}},
+		{{NodeSelector: "role in (a"}},
+		{{Name: "bad", ServerToNodeTemplate: "{{ . | nope }}"}},
+	} {
+		if _, err := NewNameMatcherFromRules(rules); err == nil {
+			t.Fatalf("expected rules %+v to be rejected", rules)
+		}
+	}
+}
+
+func TestLoadMatchRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	data := `rules:
+  - name: control-plane
+    nodeSelector: node-role.kubernetes.io/control-plane=true
+    nodeToServerTemplate: cp-%s
+  - name: workers
+    serverDatacenters: [EU]
+`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("write rules: %v", err)
+	}
+	rules, err := LoadMatchRules(path)
+	if err != nil {
+		t.Fatalf("load rules: %v", err)
+	}
+	if len(rules) != 2 || rules[0].NodeToServerTemplate != "cp-%s" || rules[1].ServerDatacenters[0] != "EU" {
+		t.Fatalf("unexpected rules %+v", rules)
+	}
+
+	if err := os.WriteFile(path, []byte("rules:\n  - nodeTemplate: x\n"), 0o600); err != nil {
+		t.Fatalf("write rules: %v", err)
+	}
+	if _, err := LoadMatchRules(path); err == nil {
+		t.Fatalf("expected unknown field to be rejected")
+	}
+}
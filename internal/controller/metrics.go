@@ -0,0 +1,217 @@
+package controller
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const metricsNamespace = "kamatera_rke2_controller"
+
+var (
+	kamateraAPIRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "kamatera_api_requests_total",
+		Help:      "Kamatera API request attempts by method, path and HTTP status code. The status is \"error\" when no response was received.",
+	}, []string{"method", "path", "status"})
+	kamateraAPIRequestErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "kamatera_api_request_errors_total",
+		Help:      "Failed Kamatera API request attempts by method, path and HTTP status code. The status is \"error\" when no response was received.",
+	}, []string{"method", "path", "status"})
+	kamateraAPIRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "kamatera_api_request_duration_seconds",
+		Help:      "Latency of Kamatera API request attempts by method and path.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"method", "path"})
+	kamateraServerDetailsFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "kamatera_server_details_failures_total",
+		Help:      "Failed Kamatera server info batches, whose servers were listed with their cached details instead.",
+	})
+	nodeDeletions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "node_deletions_total",
+		Help:      "Nodes deleted by the controller by reason.",
+	}, []string{"reason"})
+	deletionBudgetTripped = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "deletion_budget_tripped",
+		Help:      "1 while the deletion budget circuit breaker halts node deletions, 0 otherwise.",
+	})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(
+		kamateraAPIRequests,
+		kamateraAPIRequestErrors,
+		kamateraAPIRequestDuration,
+		kamateraServerDetailsFailures,
+		nodeDeletions,
+		deletionBudgetTripped,
+	)
+}
+
+func observeKamateraAPIRequest(method string, path string, statusCode int, failed bool, duration time.Duration) {
+	status := "error"
+	if statusCode > 0 {
+		status = strconv.Itoa(statusCode)
+	}
+	kamateraAPIRequests.WithLabelValues(method, path, status).Inc()
+	if failed {
+		kamateraAPIRequestErrors.WithLabelValues(method, path, status).Inc()
+	}
+	kamateraAPIRequestDuration.WithLabelValues(method, path).Observe(duration.Seconds())
+}
+
+// nodeDeletionReason maps the server state of a deleted node to the reason
+// label of the node deletions metric.
+func nodeDeletionReason(serverState string) string {
+	switch serverState {
+	case "powered off":
+		return "server_powered_off"
+	case "not recovered by remediation":
+		return "remediation_exhausted"
+	default:
+		return "server_absent"
+	}
+}
+
+var (
+	serversDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "kamatera_servers"),
+		"Kamatera servers in the server snapshot by datacenter and power state.",
+		[]string{"datacenter", "power"}, nil,
+	)
+	nodesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "nodes"),
+		"Nodes in the node snapshot by Ready condition status.",
+		[]string{"ready"}, nil,
+	)
+	nodesMatchedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "nodes_matched"),
+		"Nodes by whether they are matched to a Kamatera server in the server snapshot: true, false, or ambiguous when several servers match.",
+		[]string{"matched"}, nil,
+	)
+	nodeNotReadyAgeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "node_not_ready_age_seconds"),
+		"How long the Nodes that are currently not Ready have been not Ready.",
+		nil, nil,
+	)
+	listServersFailuresDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "kamatera_list_servers_consecutive_failures"),
+		"Failed Kamatera server list refreshes since the last successful one.",
+		nil, nil,
+	)
+	serverListSuspiciousDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "kamatera_server_list_suspicious"),
+		"1 while the filtered Kamatera server list is empty or dropped too much, which blocks deletions of Nodes without a matching server.",
+		nil, nil,
+	)
+	listServersAgeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "kamatera_list_servers_last_success_age_seconds"),
+		"Seconds since the Kamatera server list was last refreshed successfully. Absent until the first successful refresh.",
+		nil, nil,
+	)
+)
+
+var nodeNotReadyAgeBuckets = []float64{60, 300, 600, 900, 1800, 3600, 7200, 21600, 86400}
+
+// StateMetricsCollector exposes gauges computed from the server and node
+// snapshot stores at scrape time.
+type StateMetricsCollector struct {
+	ServerStore *ServerStateStore
+	NodeStore   *NodeStateStore
+	Matcher     NameMatcher
+
+	Now func() time.Time
+}
+
+func (c *StateMetricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- serversDesc
+	ch <- nodesDesc
+	ch <- nodesMatchedDesc
+	ch <- nodeNotReadyAgeDesc
+	ch <- listServersAgeDesc
+	ch <- listServersFailuresDesc
+	ch <- serverListSuspiciousDesc
+}
+
+func (c *StateMetricsCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	if c.Now != nil {
+		now = c.Now()
+	}
+
+	if c.ServerStore != nil {
+		type serverKey struct{ datacenter, power string }
+		servers := map[serverKey]int{}
+		for _, server := range c.ServerStore.List() {
+			servers[serverKey{server.Datacenter, server.Power}]++
+		}
+		for key, count := range servers {
+			ch <- prometheus.MustNewConstMetric(serversDesc, prometheus.GaugeValue, float64(count), key.datacenter, key.power)
+		}
+		if lastRefresh := c.ServerStore.LastRefresh(); !lastRefresh.IsZero() {
+			ch <- prometheus.MustNewConstMetric(listServersAgeDesc, prometheus.GaugeValue, now.Sub(lastRefresh).Seconds())
+		}
+		ch <- prometheus.MustNewConstMetric(listServersFailuresDesc, prometheus.GaugeValue, float64(c.ServerStore.ConsecutiveFailures()))
+		suspicious := 0.0
+		if c.ServerStore.Suspicious() != "" {
+			suspicious = 1
+		}
+		ch <- prometheus.MustNewConstMetric(serverListSuspiciousDesc, prometheus.GaugeValue, suspicious)
+	}
+
+	if c.NodeStore == nil {
+		return
+	}
+	ready := map[corev1.ConditionStatus]int{corev1.ConditionTrue: 0, corev1.ConditionFalse: 0, corev1.ConditionUnknown: 0}
+	matched := map[string]int{"true": 0, "false": 0, string(MatchAmbiguous): 0}
+	buckets := make(map[float64]uint64, len(nodeNotReadyAgeBuckets))
+	for _, bound := range nodeNotReadyAgeBuckets {
+		buckets[bound] = 0
+	}
+	var notReadyCount uint64
+	var notReadySum float64
+	for _, node := range c.NodeStore.List() {
+		ready[node.Ready]++
+		if c.ServerStore != nil {
+			switch _, outcome := c.Matcher.MatchServerForNode(node, c.ServerStore); outcome {
+			case MatchUnique:
+				matched["true"]++
+			case MatchAmbiguous:
+				matched[string(MatchAmbiguous)]++
+			default:
+				matched["false"]++
+			}
+		}
+		if node.Ready == corev1.ConditionTrue || node.NotReadySince.IsZero() {
+			continue
+		}
+		age := now.Sub(node.NotReadySince).Seconds()
+		if age < 0 {
+			age = 0
+		}
+		notReadyCount++
+		notReadySum += age
+		for _, bound := range nodeNotReadyAgeBuckets {
+			if age <= bound {
+				buckets[bound]++
+			}
+		}
+	}
+	for status, count := range ready {
+		ch <- prometheus.MustNewConstMetric(nodesDesc, prometheus.GaugeValue, float64(count), string(status))
+	}
+	if c.ServerStore != nil {
+		for value, count := range matched {
+			ch <- prometheus.MustNewConstMetric(nodesMatchedDesc, prometheus.GaugeValue, float64(count), value)
+		}
+	}
+	ch <- prometheus.MustNewConstHistogram(nodeNotReadyAgeDesc, notReadyCount, notReadySum, buckets)
+}
@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestStateMetricsCollectorReportsSnapshotState(t *testing.T) {
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	serverStore := NewServerStateStore()
+	serverStore.Replace([]KamateraServer{
+		{Name: "worker1", Datacenter: "EU", Power: "on"},
+		{Name: "worker2", Datacenter: "EU", Power: "off"},
+		{Name: "worker3", Datacenter: "US", Power: "on"},
+	})
+	nodeStore := NewNodeStateStore()
+	nodeStore.Replace(NodeSnapshot{Name: "worker1", Ready: corev1.ConditionTrue})
+	nodeStore.Replace(NodeSnapshot{Name: "worker2", Ready: corev1.ConditionFalse, NotReadySince: now.Add(-20 * time.Minute)})
+	nodeStore.Replace(NodeSnapshot{Name: "unmatched", Ready: corev1.ConditionUnknown, NotReadySince: now.Add(-2 * time.Hour)})
+	collector := &StateMetricsCollector{ServerStore: serverStore, NodeStore: nodeStore, Now: func() time.Time { return now }}
+
+	expected := `
+# HELP kamatera_rke2_controller_kamatera_servers Kamatera servers in the server snapshot by datacenter and power state.
+# TYPE kamatera_rke2_controller_kamatera_servers gauge
+kamatera_rke2_controller_kamatera_servers{datacenter="EU",power="off"} 1
+kamatera_rke2_controller_kamatera_servers{datacenter="EU",power="on"} 1
+kamatera_rke2_controller_kamatera_servers{datacenter="US",power="on"} 1
+# HELP kamatera_rke2_controller_nodes Nodes in the node snapshot by Ready condition status.
+# TYPE kamatera_rke2_controller_nodes gauge
+kamatera_rke2_controller_nodes{ready="False"} 1
+kamatera_rke2_controller_nodes{ready="True"} 1
+kamatera_rke2_controller_nodes{ready="Unknown"} 1
+# HELP kamatera_rke2_controller_nodes_matched Nodes by whether they are matched to a Kamatera server in the server snapshot: true, false, or ambiguous when several servers match.
+# TYPE kamatera_rke2_controller_nodes_matched gauge
+kamatera_rke2_controller_nodes_matched{matched="ambiguous"} 0
+kamatera_rke2_controller_nodes_matched{matched="false"} 1
+kamatera_rke2_controller_nodes_matched{matched="true"} 2
+# HELP kamatera_rke2_controller_node_not_ready_age_seconds How long the Nodes that are currently not Ready have been not Ready.
+# TYPE kamatera_rke2_controller_node_not_ready_age_seconds histogram
+kamatera_rke2_controller_node_not_ready_age_seconds_bucket{le="60"} 0
+kamatera_rke2_controller_node_not_ready_age_seconds_bucket{le="300"} 0
+kamatera_rke2_controller_node_not_ready_age_seconds_bucket{le="600"} 0
+kamatera_rke2_controller_node_not_ready_age_seconds_bucket{le="900"} 0
+kamatera_rke2_controller_node_not_ready_age_seconds_bucket{le="1800"} 1
+kamatera_rke2_controller_node_not_ready_age_seconds_bucket{le="3600"} 1
+kamatera_rke2_controller_node_not_ready_age_seconds_bucket{le="7200"} 2
+kamatera_rke2_controller_node_not_ready_age_seconds_bucket{le="21600"} 2
+kamatera_rke2_controller_node_not_ready_age_seconds_bucket{le="86400"} 2
+kamatera_rke2_controller_node_not_ready_age_seconds_bucket{le="+Inf"} 2
+kamatera_rke2_controller_node_not_ready_age_seconds_sum 8400
+kamatera_rke2_controller_node_not_ready_age_seconds_count 2
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"kamatera_rke2_controller_kamatera_servers",
+		"kamatera_rke2_controller_nodes",
+		"kamatera_rke2_controller_nodes_matched",
+		"kamatera_rke2_controller_node_not_ready_age_seconds",
+	); err != nil {
+		t.Fatalf("unexpected metrics: %v", err)
+	}
+}
+
+func TestStateMetricsCollectorOmitsListServersAgeBeforeFirstRefresh(t *testing.T) {
+	collector := &StateMetricsCollector{ServerStore: NewServerStateStore()}
+	if count := testutil.CollectAndCount(collector, "kamatera_rke2_controller_kamatera_list_servers_last_success_age_seconds"); count != 0 {
+		t.Fatalf("expected no list servers age before the first refresh, got %d", count)
+	}
+
+	collector.ServerStore.Replace(nil)
+	if count := testutil.CollectAndCount(collector, "kamatera_rke2_controller_kamatera_list_servers_last_success_age_seconds"); count != 1 {
+		t.Fatalf("expected list servers age after a refresh, got %d", count)
+	}
+}
+
+func TestNodeReconciler_CountsDeletionsByReason(t *testing.T) {
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "metrics-node"}}
+	node.Status.Conditions = []corev1.NodeCondition{{
+		Type:               corev1.NodeReady,
+		Status:             corev1.ConditionFalse,
+		LastTransitionTime: metav1.NewTime(now.Add(-20 * time.Minute)),
+	}}
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(node).Build()
+	serverStore := NewServerStateStore()
+	serverStore.Replace([]KamateraServer{{Name: node.Name, Datacenter: "EU", Power: "off"}})
+	before := testutil.ToFloat64(nodeDeletions.WithLabelValues("server_powered_off"))
+
+	r := &NodeReconciler{
+		Client:           c,
+		NotReadyDuration: 15 * time.Minute,
+		Now:              func() time.Time { return now },
+		Log:              logr.Discard(),
+		ServerStore:      serverStore,
+	}
+	if err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}}); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	if got := testutil.ToFloat64(nodeDeletions.WithLabelValues("server_powered_off")) - before; got != 1 {
+		t.Fatalf("expected one server_powered_off deletion to be counted, got %v", got)
+	}
+}
@@ -5,71 +5,390 @@ import (
 	"strings"
 )
 
+const (
+	// ServerNameAnnotation binds a Node to the Kamatera server with this name,
+	// overriding all other matching. It is an input set by operators and
+	// differs from KamateraServerNameLabel, which is written by the labeler.
+	ServerNameAnnotation = "kamatera.io/bind-server-name"
+	// ServerDatacenterAnnotation narrows ServerNameAnnotation down to a
+	// datacenter, for server names that exist in several datacenters.
+	ServerDatacenterAnnotation = "kamatera.io/bind-server-datacenter"
+)
+
+// MatchStrategy selects how Nodes without a Kamatera provider ID are matched
+// to Kamatera servers.
+type MatchStrategy string
+
+const (
+	// MatchStrategyName matches by name, optionally through a template.
+	MatchStrategyName MatchStrategy = "name"
+	// MatchStrategyIP matches a Node to the only server that shares an IP
+	// address with the Node's InternalIP and ExternalIP addresses.
+	MatchStrategyIP MatchStrategy = "ip"
+	// MatchStrategyNameThenIP matches by name and falls back to IP addresses
+	// when no unique server matches by name.
+	MatchStrategyNameThenIP MatchStrategy = "name-then-ip"
+)
+
+func ParseMatchStrategy(value string) (MatchStrategy, error) {
+	switch strategy := MatchStrategy(strings.TrimSpace(value)); strategy {
+	case "", MatchStrategyName:
+		return MatchStrategyName, nil
+	case MatchStrategyIP, MatchStrategyNameThenIP:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("unsupported match strategy %q, must be one of name, ip, name-then-ip", value)
+	}
+}
+
+// NameMatcher matches Nodes to Kamatera servers. Nodes bound to a server
+// with ServerNameAnnotation are matched to that server only. Nodes with a
+// Kamatera provider ID are matched by server ID when preferred, all others
+// with the match strategy. Name matching tries the matching rules in order until one
+// finds a unique match.
 type NameMatcher struct {
-	nodeToServerTemplate string
-	serverToNodeTemplate string
+	rules            []matchRule
+	preferProviderID bool
+	strategy         MatchStrategy
 }
 
+// NewNameMatcher returns a matcher with a single matching rule that
+// transforms Node names to server names with nodeToServerTemplate, or server
+// names to Node names with serverToNodeTemplate. A template is either a format
+// with exactly one %s, or a Go text/template with the name as dot and the
+// helpers lower, upper, trim, trimPrefix, trimSuffix, replace, regexReplace
+// and regexMatch.
 func NewNameMatcher(nodeToServerTemplate string, serverToNodeTemplate string) (NameMatcher, error) {
-	nodeToServerTemplate = strings.TrimSpace(nodeToServerTemplate)
-	serverToNodeTemplate = strings.TrimSpace(serverToNodeTemplate)
-	if nodeToServerTemplate != "" && serverToNodeTemplate != "" {
-		return NameMatcher{}, fmt.Errorf("only one matching template can be configured")
+	rule, err := newMatchRule(MatchRule{NodeToServerTemplate: nodeToServerTemplate, ServerToNodeTemplate: serverToNodeTemplate})
+	if err != nil {
+		return NameMatcher{}, err
 	}
-	if err := validateNameTemplate(nodeToServerTemplate); err != nil {
-		return NameMatcher{}, fmt.Errorf("invalid node-to-server template: %w", err)
+	return NameMatcher{rules: []matchRule{rule}}, nil
+}
+
+// NewNameMatcherFromRules returns a matcher that tries the rules in order.
+func NewNameMatcherFromRules(rules []MatchRule) (NameMatcher, error) {
+	if len(rules) == 0 {
+		return NameMatcher{}, fmt.Errorf("no matching rules configured")
 	}
-	if err := validateNameTemplate(serverToNodeTemplate); err != nil {
-		return NameMatcher{}, fmt.Errorf("invalid server-to-node template: %w", err)
+	matcher := NameMatcher{}
+	for i, rule := range rules {
+		parsed, err := newMatchRule(rule)
+		if err != nil {
+			return NameMatcher{}, fmt.Errorf("matching rule %s: %w", matchRuleName(rule, i), err)
+		}
+		matcher.rules = append(matcher.rules, parsed)
 	}
-	return NameMatcher{nodeToServerTemplate: nodeToServerTemplate, serverToNodeTemplate: serverToNodeTemplate}, nil
+	return matcher, nil
 }
 
 func DefaultNameMatcher() NameMatcher {
 	return NameMatcher{}
 }
 
+// WithPreferProviderID returns a copy of the matcher that, when prefer is
+// true, matches Nodes with a Kamatera provider ID by server ID and datacenter
+// only. Nodes without a Kamatera provider ID are still matched by the match
+// strategy.
+func (m NameMatcher) WithPreferProviderID(prefer bool) NameMatcher {
+	m.preferProviderID = prefer
+	return m
+}
+
+// WithStrategy returns a copy of the matcher that matches Nodes without a
+// Kamatera provider ID with the given strategy.
+func (m NameMatcher) WithStrategy(strategy MatchStrategy) NameMatcher {
+	m.strategy = strategy
+	return m
+}
+
+func (m NameMatcher) matchByName() bool {
+	return m.strategy != MatchStrategyIP
+}
+
+func (m NameMatcher) matchByIP() bool {
+	return m.strategy == MatchStrategyIP || m.strategy == MatchStrategyNameThenIP
+}
+
+// matchRules returns the matching rules, or a single exact name rule.
+func (m NameMatcher) matchRules() []matchRule {
+	if len(m.rules) == 0 {
+		return []matchRule{{}}
+	}
+	return m.rules
+}
+
+// Match reports whether the names match by any matching rule, regardless of
+// the rule scopes.
 func (m NameMatcher) Match(nodeName string, serverName string) bool {
-	if m.nodeToServerTemplate != "" {
-		return fmt.Sprintf(m.nodeToServerTemplate, nodeName) == serverName
+	for _, rule := range m.matchRules() {
+		if rule.matchNames(nodeName, serverName) {
+			return true
+		}
 	}
-	if m.serverToNodeTemplate != "" {
-		return fmt.Sprintf(m.serverToNodeTemplate, serverName) == nodeName
+	return false
+}
+
+// MatchOutcome is the result of matching a Node to a Kamatera server, or a
+// server to a Node.
+type MatchOutcome string
+
+const (
+	// MatchNone means nothing matched.
+	MatchNone MatchOutcome = "none"
+	// MatchUnique means exactly one candidate matched.
+	MatchUnique MatchOutcome = "unique"
+	// MatchAmbiguous means several candidates matched, e.g. the same server
+	// name in two datacenters, and none was picked. An ambiguous match must
+	// never be treated like a missing server.
+	MatchAmbiguous MatchOutcome = "ambiguous"
+)
+
+func matchOutcome(matches int) MatchOutcome {
+	switch {
+	case matches == 1:
+		return MatchUnique
+	case matches > 1:
+		return MatchAmbiguous
+	default:
+		return MatchNone
 	}
-	return nodeName == serverName
 }
 
-func (m NameMatcher) FindServerForNode(nodeName string, store *ServerStateStore) (KamateraServer, bool) {
+// FindServerForNode returns the server matching the node, and false unless
+// exactly one server matches.
+func (m NameMatcher) FindServerForNode(node NodeSnapshot, store *ServerStateStore) (KamateraServer, bool) {
+	server, outcome := m.MatchServerForNode(node, store)
+	return server, outcome == MatchUnique
+}
+
+// MatchServerForNode returns the server matching the node and whether the
+// match is unique, ambiguous or missing. With several matching stages, e.g.
+// matching rules or name-then-ip, a later unique match resolves an earlier
+// ambiguous one, but an ambiguous stage is never reported as MatchNone.
+func (m NameMatcher) MatchServerForNode(node NodeSnapshot, store *ServerStateStore) (KamateraServer, MatchOutcome) {
 	if store == nil {
-		return KamateraServer{}, false
+		return KamateraServer{}, MatchNone
+	}
+	if node.BoundServerName != "" {
+		return findServers(store.serversNamed(node.BoundServerName), func(server KamateraServer) bool { return isBoundServer(node, server) })
+	}
+	if m.preferProviderID && strings.HasPrefix(node.ProviderID, KamateraProviderIDPrefix) {
+		datacenter, id, err := ParseKamateraProviderID(node.ProviderID)
+		if err != nil {
+			return KamateraServer{}, MatchNone
+		}
+		server, ok := store.GetByID(datacenter, id)
+		if !ok {
+			return KamateraServer{}, MatchNone
+		}
+		return server, MatchUnique
 	}
-	if m.nodeToServerTemplate != "" {
-		return store.Get(fmt.Sprintf(m.nodeToServerTemplate, nodeName))
+	outcome := MatchNone
+	if m.matchByName() {
+		server, nameOutcome := m.findServerByName(node, store)
+		if nameOutcome == MatchUnique || !m.matchByIP() {
+			return server, nameOutcome
+		}
+		outcome = nameOutcome
+	}
+	server, ipOutcome := findServerByIP(node.IPs, store)
+	if ipOutcome == MatchUnique {
+		return server, ipOutcome
+	}
+	return KamateraServer{}, mergeMatchOutcomes(outcome, ipOutcome)
+}
+
+// mergeMatchOutcomes combines outcomes of matching stages none of which
+// found a unique match.
+func mergeMatchOutcomes(a MatchOutcome, b MatchOutcome) MatchOutcome {
+	if a == MatchAmbiguous || b == MatchAmbiguous {
+		return MatchAmbiguous
 	}
+	return MatchNone
+}
+
+func isBoundServer(node NodeSnapshot, server KamateraServer) bool {
+	if node.BoundServerName == "" || server.Name != node.BoundServerName {
+		return false
+	}
+	return node.BoundServerDatacenter == "" || server.Datacenter == node.BoundServerDatacenter
+}
+
+func findServers(servers []KamateraServer, match func(KamateraServer) bool) (KamateraServer, MatchOutcome) {
 	var matched KamateraServer
 	matches := 0
-	for _, server := range store.List() {
-		if m.Match(nodeName, server.Name) {
+	for _, server := range servers {
+		if match(server) {
 			matched = server
 			matches++
 		}
 	}
-	return matched, matches == 1
+	outcome := matchOutcome(matches)
+	if outcome != MatchUnique {
+		return KamateraServer{}, outcome
+	}
+	return matched, outcome
 }
 
-func (m NameMatcher) FindNodeForServer(serverName string, store *NodeStateStore) (NodeSnapshot, bool) {
+func (m NameMatcher) findServerByName(node NodeSnapshot, store *ServerStateStore) (KamateraServer, MatchOutcome) {
+	outcome := MatchNone
+	for _, rule := range m.matchRules() {
+		if !rule.matchesNode(node) {
+			continue
+		}
+		server, ruleOutcome := findServers(rule.serverCandidates(node.Name, store), rule.matchesServer)
+		if ruleOutcome == MatchUnique {
+			return server, ruleOutcome
+		}
+		outcome = mergeMatchOutcomes(outcome, ruleOutcome)
+	}
+	return KamateraServer{}, outcome
+}
+
+func findServerByIP(nodeIPs []string, store *ServerStateStore) (KamateraServer, MatchOutcome) {
+	if len(nodeIPs) == 0 {
+		return KamateraServer{}, MatchNone
+	}
+	servers := store.serversWithIPs(nodeIPs)
+	if outcome := matchOutcome(len(servers)); outcome != MatchUnique {
+		return KamateraServer{}, outcome
+	}
+	return servers[0], MatchUnique
+}
+
+// MatchPairs are the matches of all Nodes to Kamatera servers, computed once
+// so they can be queried by Node and by server.
+type MatchPairs struct {
+	nodes   map[string]nodeMatch
+	servers map[string][]string
+}
+
+type nodeMatch struct {
+	server  KamateraServer
+	outcome MatchOutcome
+}
+
+// Pairs matches all Nodes in the node store to servers, as MatchServerForNode.
+func (m NameMatcher) Pairs(nodes *NodeStateStore, servers *ServerStateStore) MatchPairs {
+	pairs := MatchPairs{nodes: map[string]nodeMatch{}, servers: map[string][]string{}}
+	if nodes == nil {
+		return pairs
+	}
+	for _, node := range nodes.List() {
+		server, outcome := m.MatchServerForNode(node, servers)
+		pairs.nodes[node.Name] = nodeMatch{server: server, outcome: outcome}
+		if outcome == MatchUnique {
+			key := serverStateKey(server)
+			pairs.servers[key] = append(pairs.servers[key], node.Name)
+		}
+	}
+	return pairs
+}
+
+// ServerForNode returns the server matched to the Node and whether the match
+// is unique, ambiguous or missing.
+func (p MatchPairs) ServerForNode(nodeName string) (KamateraServer, MatchOutcome) {
+	match, ok := p.nodes[nodeName]
+	if !ok {
+		return KamateraServer{}, MatchNone
+	}
+	return match.server, match.outcome
+}
+
+// NodesForServer returns the names of the Nodes uniquely matched to the
+// server, in name order.
+func (p MatchPairs) NodesForServer(server KamateraServer) []string {
+	return p.servers[serverStateKey(server)]
+}
+
+// FindNodeForServer returns the node matching the server, and false unless
+// exactly one node matches.
+func (m NameMatcher) FindNodeForServer(server KamateraServer, store *NodeStateStore) (NodeSnapshot, bool) {
+	node, outcome := m.MatchNodeForServer(server, store)
+	return node, outcome == MatchUnique
+}
+
+// MatchNodeForServer returns the node matching the server and whether the
+// match is unique, ambiguous or missing, with the same precedence as
+// MatchServerForNode.
+func (m NameMatcher) MatchNodeForServer(server KamateraServer, store *NodeStateStore) (NodeSnapshot, MatchOutcome) {
 	if store == nil {
-		return NodeSnapshot{}, false
+		return NodeSnapshot{}, MatchNone
+	}
+	if node, outcome := findNodes(store.nodesBoundTo(server.Name), func(node NodeSnapshot) bool { return isBoundServer(node, server) }); outcome != MatchNone {
+		return node, outcome
+	}
+	if m.preferProviderID {
+		if providerID := KamateraProviderID(server); providerID != "" {
+			node, outcome := findNodes(store.nodesWithProviderID(providerID), func(node NodeSnapshot) bool {
+				return node.BoundServerName == ""
+			})
+			if outcome != MatchNone {
+				return node, outcome
+			}
+		}
+	}
+	outcome := MatchNone
+	if m.matchByName() {
+		node, nameOutcome := m.findNodeByName(server, store)
+		if nameOutcome == MatchUnique || !m.matchByIP() {
+			return node, nameOutcome
+		}
+		outcome = nameOutcome
+	}
+	serverIPs := server.IPs()
+	if len(serverIPs) == 0 {
+		return NodeSnapshot{}, outcome
+	}
+	node, ipOutcome := findNodes(store.nodesWithIPs(serverIPs), m.nameMatchable)
+	if ipOutcome == MatchUnique {
+		return node, ipOutcome
+	}
+	return NodeSnapshot{}, mergeMatchOutcomes(outcome, ipOutcome)
+}
+
+func findNodes(nodes []NodeSnapshot, match func(NodeSnapshot) bool) (NodeSnapshot, MatchOutcome) {
+	var matched NodeSnapshot
+	matches := 0
+	for _, node := range nodes {
+		if match(node) {
+			matched = node
+			matches++
+		}
 	}
-	if m.serverToNodeTemplate != "" {
-		return store.Get(fmt.Sprintf(m.serverToNodeTemplate, serverName))
+	outcome := matchOutcome(matches)
+	if outcome != MatchUnique {
+		return NodeSnapshot{}, outcome
 	}
-	for _, node := range store.List() {
-		if m.Match(node.Name, serverName) {
-			return node, true
+	return matched, outcome
+}
+
+func (m NameMatcher) findNodeByName(server KamateraServer, store *NodeStateStore) (NodeSnapshot, MatchOutcome) {
+	outcome := MatchNone
+	for _, rule := range m.matchRules() {
+		if !rule.matchesServer(server) {
+			continue
+		}
+		node, ruleOutcome := findNodes(rule.nodeCandidates(server.Name, store), func(node NodeSnapshot) bool {
+			return m.nameMatchable(node) && rule.matchesNode(node)
+		})
+		if ruleOutcome == MatchUnique {
+			return node, ruleOutcome
 		}
+		outcome = mergeMatchOutcomes(outcome, ruleOutcome)
+	}
+	return NodeSnapshot{}, outcome
+}
+
+// nameMatchable returns false for Nodes that are bound by annotation or
+// matched by provider ID only, which are then not matched by name or IP
+// either.
+func (m NameMatcher) nameMatchable(node NodeSnapshot) bool {
+	if node.BoundServerName != "" {
+		return false
 	}
-	return NodeSnapshot{}, false
+	return !m.preferProviderID || !strings.HasPrefix(node.ProviderID, KamateraProviderIDPrefix)
 }
 
 func validateNameTemplate(template string) error {
@@ -1,6 +1,11 @@
 package controller
 
-import "testing"
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
 
 func TestNewNameMatcherRejectsBothTemplates(t *testing.T) {
 	_, err := NewNameMatcher("server-%s", "node-%s")
@@ -60,7 +65,7 @@ func TestNameMatcherFindServerForNode(t *testing.T) {
 		{Name: "kamatera-worker2", Datacenter: "EU", Power: "on"},
 	})
 
-	server, ok := matcher.FindServerForNode("worker1", store)
+	server, ok := matcher.FindServerForNode(NodeSnapshot{Name: "worker1"}, store)
 	if !ok {
 		t.Fatalf("expected server match")
 	}
@@ -80,7 +85,7 @@ func TestNameMatcherFindServerForNodeTreatsDuplicateExactMatchesAsAmbiguous(t *t
 		{Name: "worker1", Datacenter: "US", Power: "on"},
 	})
 
-	if server, ok := matcher.FindServerForNode("worker1", store); ok {
+	if server, ok := matcher.FindServerForNode(NodeSnapshot{Name: "worker1"}, store); ok {
 		t.Fatalf("expected duplicate exact matches to be ambiguous, got %+v", server)
 	}
 }
@@ -96,7 +101,234 @@ func TestNameMatcherFindServerForNodeTreatsDuplicateServerToNodeMatchesAsAmbiguo
 		{Name: "worker1", Datacenter: "US", Power: "on"},
 	})
 
-	if server, ok := matcher.FindServerForNode("node-worker1", store); ok {
+	if server, ok := matcher.FindServerForNode(NodeSnapshot{Name: "node-worker1"}, store); ok {
 		t.Fatalf("expected duplicate server-to-node matches to be ambiguous, got %+v", server)
 	}
 }
+
+func TestNameMatcherPreferProviderIDMatchesByServerID(t *testing.T) {
+	matcher, err := NewNameMatcher("", "")
+	if err != nil {
+		t.Fatalf("new matcher: %v", err)
+	}
+	matcher = matcher.WithPreferProviderID(true)
+	store := NewServerStateStore()
+	store.Replace([]KamateraServer{
+		{ID: "id-1", Name: "renamed", Datacenter: "EU", Power: "off"},
+		{ID: "id-2", Name: "worker1", Datacenter: "EU", Power: "on"},
+	})
+
+	server, ok := matcher.FindServerForNode(NodeSnapshot{Name: "worker1", ProviderID: "kamatera://EU/id-1"}, store)
+	if !ok || server.ID != "id-1" {
+		t.Fatalf("expected providerID match to id-1, got %+v ok=%v", server, ok)
+	}
+	if server, ok := matcher.FindServerForNode(NodeSnapshot{Name: "worker1", ProviderID: "kamatera://EU/id-3"}, store); ok {
+		t.Fatalf("did not expect name fallback for unknown kamatera providerID, got %+v", server)
+	}
+	server, ok = matcher.FindServerForNode(NodeSnapshot{Name: "worker1", ProviderID: "rke2://worker1"}, store)
+	if !ok || server.ID != "id-2" {
+		t.Fatalf("expected foreign providerID to be matched by name, got %+v ok=%v", server, ok)
+	}
+
+	nodes := NewNodeStateStore()
+	nodes.Replace(NodeSnapshot{Name: "worker1", ProviderID: "kamatera://EU/id-1"})
+	if node, ok := matcher.FindNodeForServer(KamateraServer{ID: "id-1", Name: "renamed", Datacenter: "EU"}, nodes); !ok || node.Name != "worker1" {
+		t.Fatalf("expected server id-1 to match node worker1 by providerID, got %+v ok=%v", node, ok)
+	}
+	if node, ok := matcher.FindNodeForServer(KamateraServer{ID: "id-2", Name: "worker1", Datacenter: "EU"}, nodes); ok {
+		t.Fatalf("did not expect node with another kamatera providerID to match by name, got %+v", node)
+	}
+}
+
+func TestNameMatcherIgnoresProviderIDByDefault(t *testing.T) {
+	matcher, err := NewNameMatcher("", "")
+	if err != nil {
+		t.Fatalf("new matcher: %v", err)
+	}
+	store := NewServerStateStore()
+	store.Replace([]KamateraServer{{ID: "id-1", Name: "worker1", Datacenter: "EU", Power: "off"}})
+
+	server, ok := matcher.FindServerForNode(NodeSnapshot{Name: "worker1", ProviderID: "kamatera://EU/id-9"}, store)
+	if !ok || server.ID != "id-1" {
+		t.Fatalf("expected name match without providerID preference, got %+v ok=%v", server, ok)
+	}
+}
+
+func TestParseMatchStrategy(t *testing.T) {
+	for value, expected := range map[string]MatchStrategy{
+		"":             MatchStrategyName,
+		"name":         MatchStrategyName,
+		"ip":           MatchStrategyIP,
+		"name-then-ip": MatchStrategyNameThenIP,
+	} {
+		strategy, err := ParseMatchStrategy(value)
+		if err != nil || strategy != expected {
+			t.Fatalf("parse %q: expected %q, got %q err=%v", value, expected, strategy, err)
+		}
+	}
+	if _, err := ParseMatchStrategy("mac"); err == nil {
+		t.Fatalf("expected unsupported strategy to be rejected")
+	}
+}
+
+func TestNameMatcherMatchesByIP(t *testing.T) {
+	matcher := DefaultNameMatcher().WithStrategy(MatchStrategyIP)
+	store := NewServerStateStore()
+	store.Replace([]KamateraServer{
+		{ID: "id-1", Name: "srv-a", Datacenter: "EU", Networks: []KamateraServerNetwork{{Name: "wan-eu", IPs: []string{"203.0.113.10"}}, {Name: "lan-1", IPs: []string{"172.16.0.10"}}}},
+		{ID: "id-2", Name: "worker1", Datacenter: "EU", Networks: []KamateraServerNetwork{{Name: "lan-1", IPs: []string{"172.16.0.11"}}}},
+		{ID: "id-3", Name: "srv-c", Datacenter: "US", Networks: []KamateraServerNetwork{{Name: "lan-2", IPs: []string{"172.16.0.12"}}}},
+		{ID: "id-4", Name: "srv-d", Datacenter: "IL", Networks: []KamateraServerNetwork{{Name: "lan-3", IPs: []string{"172.16.0.12"}}}},
+	})
+
+	server, ok := matcher.FindServerForNode(NodeSnapshot{Name: "worker1", IPs: []string{"172.16.0.10"}}, store)
+	if !ok || server.ID != "id-1" {
+		t.Fatalf("expected IP match to id-1, got %+v ok=%v", server, ok)
+	}
+	if server, ok := matcher.FindServerForNode(NodeSnapshot{Name: "worker1"}, store); ok {
+		t.Fatalf("did not expect node without IPs to match by name with ip strategy, got %+v", server)
+	}
+	if server, ok := matcher.FindServerForNode(NodeSnapshot{Name: "worker9", IPs: []string{"172.16.0.12"}}, store); ok {
+		t.Fatalf("expected IP shared by two servers to be ambiguous, got %+v", server)
+	}
+
+	nodes := NewNodeStateStore()
+	nodes.Replace(NodeSnapshot{Name: "worker1", IPs: []string{"10.0.0.1", "203.0.113.10"}})
+	nodes.Replace(NodeSnapshot{Name: "worker2", IPs: []string{"172.16.0.12"}})
+	nodes.Replace(NodeSnapshot{Name: "worker3", IPs: []string{"172.16.0.12"}})
+	if node, ok := matcher.FindNodeForServer(KamateraServer{Name: "srv-a", Networks: []KamateraServerNetwork{{Name: "wan-eu", IPs: []string{"203.0.113.10"}}}}, nodes); !ok || node.Name != "worker1" {
+		t.Fatalf("expected server to match node worker1 by IP, got %+v ok=%v", node, ok)
+	}
+	if node, ok := matcher.FindNodeForServer(KamateraServer{Name: "srv-c", Networks: []KamateraServerNetwork{{Name: "lan-2", IPs: []string{"172.16.0.12"}}}}, nodes); ok {
+		t.Fatalf("expected IP shared by two nodes to be ambiguous, got %+v", node)
+	}
+}
+
+func TestNameMatcherNameThenIPFallsBackToIP(t *testing.T) {
+	matcher := DefaultNameMatcher().WithStrategy(MatchStrategyNameThenIP)
+	store := NewServerStateStore()
+	store.Replace([]KamateraServer{
+		{ID: "id-1", Name: "worker1", Datacenter: "EU", Networks: []KamateraServerNetwork{{Name: "lan-1", IPs: []string{"172.16.0.10"}}}},
+		{ID: "id-2", Name: "srv-b", Datacenter: "EU", Networks: []KamateraServerNetwork{{Name: "lan-1", IPs: []string{"172.16.0.11"}}}},
+	})
+
+	server, ok := matcher.FindServerForNode(NodeSnapshot{Name: "worker1", IPs: []string{"172.16.0.11"}}, store)
+	if !ok || server.ID != "id-1" {
+		t.Fatalf("expected name match to win, got %+v ok=%v", server, ok)
+	}
+	server, ok = matcher.FindServerForNode(NodeSnapshot{Name: "ip-172-16-0-11", IPs: []string{"172.16.0.11"}}, store)
+	if !ok || server.ID != "id-2" {
+		t.Fatalf("expected fallback IP match to id-2, got %+v ok=%v", server, ok)
+	}
+}
+
+func TestNameMatcherServerNameAnnotationOverridesMatching(t *testing.T) {
+	matcher, err := NewNameMatcher("kamatera-%s", "")
+	if err != nil {
+		t.Fatalf("new matcher: %v", err)
+	}
+	matcher = matcher.WithPreferProviderID(true)
+	store := NewServerStateStore()
+	store.Replace([]KamateraServer{
+		{ID: "id-1", Name: "kamatera-worker1", Datacenter: "EU"},
+		{ID: "id-2", Name: "legacy-box", Datacenter: "EU"},
+		{ID: "id-3", Name: "legacy-box", Datacenter: "US"},
+	})
+	annotated := func(name string, serverName string, datacenter string) NodeSnapshot {
+		return NewNodeSnapshot(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: map[string]string{
+			ServerNameAnnotation:       serverName,
+			ServerDatacenterAnnotation: datacenter,
+		}}}, nil, nil)
+	}
+
+	worker1 := annotated("worker1", "legacy-box", "US")
+	worker1.ProviderID = "kamatera://EU/id-1"
+	server, ok := matcher.FindServerForNode(worker1, store)
+	if !ok || server.ID != "id-3" {
+		t.Fatalf("expected annotation to override template and providerID, got %+v ok=%v", server, ok)
+	}
+	if server, ok := matcher.FindServerForNode(annotated("worker1", "legacy-box", ""), store); ok {
+		t.Fatalf("expected server name in two datacenters to be ambiguous without datacenter annotation, got %+v", server)
+	}
+	if server, ok := matcher.FindServerForNode(annotated("worker1", "missing", ""), store); ok {
+		t.Fatalf("did not expect template fallback for bound node, got %+v", server)
+	}
+
+	nodes := NewNodeStateStore()
+	nodes.Replace(worker1)
+	nodes.Replace(NodeSnapshot{Name: "worker2"})
+	if node, ok := matcher.FindNodeForServer(KamateraServer{ID: "id-3", Name: "legacy-box", Datacenter: "US"}, nodes); !ok || node.Name != "worker1" {
+		t.Fatalf("expected bound server to match annotated node, got %+v ok=%v", node, ok)
+	}
+	if node, ok := matcher.FindNodeForServer(KamateraServer{ID: "id-1", Name: "kamatera-worker1", Datacenter: "EU"}, nodes); ok {
+		t.Fatalf("did not expect annotated node to match by template or providerID, got %+v", node)
+	}
+}
+
+func TestNameMatcherReportsAmbiguousMatches(t *testing.T) {
+	matcher := DefaultNameMatcher().WithStrategy(MatchStrategyNameThenIP)
+	store := NewServerStateStore()
+	store.Replace([]KamateraServer{
+		{ID: "id-1", Name: "worker1", Datacenter: "EU", Networks: []KamateraServerNetwork{{Name: "lan", IPs: []string{"172.16.0.10"}}}},
+		{ID: "id-2", Name: "worker1", Datacenter: "US", Networks: []KamateraServerNetwork{{Name: "lan", IPs: []string{"172.16.0.11"}}}},
+	})
+
+	if server, outcome := matcher.MatchServerForNode(NodeSnapshot{Name: "worker1"}, store); outcome != MatchAmbiguous || server.Name != "" {
+		t.Fatalf("expected ambiguous outcome without a server, got %q %+v", outcome, server)
+	}
+	if server, outcome := matcher.MatchServerForNode(NodeSnapshot{Name: "worker1", IPs: []string{"172.16.0.11"}}, store); outcome != MatchUnique || server.ID != "id-2" {
+		t.Fatalf("expected IP fallback to resolve the ambiguous name match, got %q %+v", outcome, server)
+	}
+	if _, outcome := matcher.MatchServerForNode(NodeSnapshot{Name: "worker2"}, store); outcome != MatchNone {
+		t.Fatalf("expected no match, got %q", outcome)
+	}
+
+	nodeToServer, err := NewNameMatcher("{{ . | lower }}", "")
+	if err != nil {
+		t.Fatalf("new matcher: %v", err)
+	}
+	nodes := NewNodeStateStore()
+	nodes.Replace(NodeSnapshot{Name: "Worker1"})
+	nodes.Replace(NodeSnapshot{Name: "WORKER1"})
+	if node, outcome := nodeToServer.MatchNodeForServer(KamateraServer{Name: "worker1", Datacenter: "EU"}, nodes); outcome != MatchAmbiguous || node.Name != "" {
+		t.Fatalf("expected server matched by two nodes to be ambiguous, got %q %+v", outcome, node)
+	}
+	if node, ok := nodeToServer.FindNodeForServer(KamateraServer{Name: "worker1", Datacenter: "EU"}, nodes); ok {
+		t.Fatalf("expected FindNodeForServer not to return an arbitrary node, got %+v", node)
+	}
+}
+
+func TestNameMatcherPairs(t *testing.T) {
+	matcher, err := NewNameMatcher("", `{{ . | trimPrefix "kamatera-" }}`)
+	if err != nil {
+		t.Fatalf("new matcher: %v", err)
+	}
+	servers := NewServerStateStore()
+	servers.Replace([]KamateraServer{
+		{Name: "kamatera-worker1", Datacenter: "EU"},
+		{Name: "kamatera-worker2", Datacenter: "EU"},
+		{Name: "kamatera-worker2", Datacenter: "US"},
+		{Name: "kamatera-spare", Datacenter: "EU"},
+	})
+	nodes := NewNodeStateStore()
+	for _, name := range []string{"worker1", "worker2", "worker3"} {
+		nodes.Replace(NodeSnapshot{Name: name})
+	}
+
+	pairs := matcher.Pairs(nodes, servers)
+	if server, outcome := pairs.ServerForNode("worker1"); outcome != MatchUnique || server.Name != "kamatera-worker1" {
+		t.Fatalf("unexpected worker1 match %+v %s", server, outcome)
+	}
+	if _, outcome := pairs.ServerForNode("worker2"); outcome != MatchAmbiguous {
+		t.Fatalf("expected worker2 to be ambiguous, got %s", outcome)
+	}
+	if _, outcome := pairs.ServerForNode("worker3"); outcome != MatchNone {
+		t.Fatalf("expected worker3 to be unmatched, got %s", outcome)
+	}
+	if names := pairs.NodesForServer(KamateraServer{Name: "kamatera-worker1", Datacenter: "EU"}); len(names) != 1 || names[0] != "worker1" {
+		t.Fatalf("unexpected nodes for server: %v", names)
+	}
+	if names := pairs.NodesForServer(KamateraServer{Name: "kamatera-spare", Datacenter: "EU"}); len(names) != 0 {
+		t.Fatalf("expected no nodes for the spare server, got %v", names)
+	}
+}
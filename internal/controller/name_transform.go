@@ -0,0 +1,109 @@
+package controller
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// nameTransformSample is the name a Go template transform is executed with at
+// startup, so template errors such as invalid regular expressions surface
+// before the first match.
+const nameTransformSample = "worker-1"
+
+// nameTransform maps a Node or server name to the name it must match on the
+// other side. It is either a %s format, e.g. kamatera-%s, or a Go text/template
+// with the name as dot, e.g. {{ . | trimPrefix "cluster-" | lower }}.
+type nameTransform struct {
+	spec     string
+	format   string
+	template *template.Template
+}
+
+func parseNameTransform(spec string) (*nameTransform, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+	if !strings.Contains(spec, "{{") {
+		if err := validateNameTemplate(spec); err != nil {
+			return nil, err
+		}
+		return &nameTransform{spec: spec, format: spec}, nil
+	}
+	tmpl, err := template.New("name").Option("missingkey=error").Funcs(nameTransformFuncs()).Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Go template: %w", err)
+	}
+	transform := &nameTransform{spec: spec, template: tmpl}
+	if _, err := transform.execute(nameTransformSample); err != nil {
+		return nil, err
+	}
+	return transform, nil
+}
+
+// apply returns the transformed name, or false when the transform fails or
+// yields an empty name, which then matches nothing.
+func (t *nameTransform) apply(name string) (string, bool) {
+	if t.template == nil {
+		return fmt.Sprintf(t.format, name), true
+	}
+	transformed, err := t.execute(name)
+	if err != nil || transformed == "" {
+		return "", false
+	}
+	return transformed, true
+}
+
+func (t *nameTransform) execute(name string) (string, error) {
+	var out strings.Builder
+	if err := t.template.Execute(&out, name); err != nil {
+		return "", fmt.Errorf("execute Go template: %w", err)
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// nameTransformFuncs are the helpers available in Go template transforms.
+// The name is the last argument, so they can be used in pipelines.
+func nameTransformFuncs() template.FuncMap {
+	return template.FuncMap{
+		"lower":      strings.ToLower,
+		"upper":      strings.ToUpper,
+		"trim":       strings.TrimSpace,
+		"trimPrefix": func(prefix string, s string) string { return strings.TrimPrefix(s, prefix) },
+		"trimSuffix": func(suffix string, s string) string { return strings.TrimSuffix(s, suffix) },
+		"replace":    func(old string, new string, s string) string { return strings.ReplaceAll(s, old, new) },
+		"regexReplace": func(pattern string, replacement string, s string) (string, error) {
+			re, err := compileTransformRegexp(pattern)
+			if err != nil {
+				return "", err
+			}
+			return re.ReplaceAllString(s, replacement), nil
+		},
+		"regexMatch": func(pattern string, s string) (bool, error) {
+			re, err := compileTransformRegexp(pattern)
+			if err != nil {
+				return false, err
+			}
+			return re.MatchString(s), nil
+		},
+	}
+}
+
+var transformRegexps sync.Map
+
+// compileTransformRegexp caches compiled patterns, since templates are
+// executed for every node and server name on each match.
+func compileTransformRegexp(pattern string) (*regexp.Regexp, error) {
+	if cached, ok := transformRegexps.Load(pattern); ok {
+		return cached.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	transformRegexps.Store(pattern, re)
+	return re, nil
+}
@@ -0,0 +1,95 @@
+package controller
+
+import "testing"
+
+func TestParseNameTransformGoTemplate(t *testing.T) {
+	for spec, cases := range map[string]map[string]string{
+		`kamatera-{{ . }}`:                                  {"worker1": "kamatera-worker1"},
+		`{{ . | lower }}`:                                   {"Worker-1": "worker-1"},
+		`{{ . | trimPrefix "cluster-" }}`:                   {"cluster-pool-123": "pool-123", "pool-1": "pool-1"},
+		`{{ . | trimSuffix ".example.com" }}`:               {"worker1.example.com": "worker1"},
+		`{{ . | replace "_" "-" | upper }}`:                 {"pool_a_1": "POOL-A-1"},
+		`{{ regexReplace "-[a-z0-9]{5}$" "" . }}`:           {"pool-a-x7k2p": "pool-a", "pool-a": "pool-a"},
+		`{{ regexReplace "^cluster-(pool-\\d+)$" "$1" . }}`: {"cluster-pool-123": "pool-123"},
+		`{{ if regexMatch "^rke2-" . }}{{ . }}{{ end }}`:    {"rke2-worker1": "rke2-worker1"},
+	} {
+		transform, err := parseNameTransform(spec)
+		if err != nil {
+			t.Fatalf("parse %q: %v", spec, err)
+		}
+		for name, expected := range cases {
+			got, ok := transform.apply(name)
+			if !ok || got != expected {
+				t.Fatalf("%q applied to %q: expected %q, got %q ok=%v", spec, name, expected, got, ok)
+			}
+		}
+	}
+}
+
+func TestNameTransformEmptyResultMatchesNothing(t *testing.T) {
+	transform, err := parseNameTransform(`{{ if regexMatch "^rke2-" . }}{{ . }}{{ end }}`)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if got, ok := transform.apply("worker1"); ok {
+		t.Fatalf("expected empty transform result not to match, got %q", got)
+	}
+}
+
+func TestParseNameTransformRejectsInvalidTemplates(t *testing.T) {
+	for _, spec := range []string{
+		`{{ . | lower `,
+		`{{ . | unknownFunc }}`,
+		`{{ regexReplace "(" "" . }}`,
+		`kamatera-%d`,
+	} {
+		if _, err := parseNameTransform(spec); err == nil {
+			t.Fatalf("expected %q to be rejected", spec)
+		}
+	}
+}
+
+func TestNameMatcherGoTemplatesRoundTrip(t *testing.T) {
+	nodeToServer, err := NewNameMatcher(`{{ . | replace "_" "-" | printf "kamatera-%s" }}`, "")
+	if err != nil {
+		t.Fatalf("new node-to-server matcher: %v", err)
+	}
+	serverToNode, err := NewNameMatcher("", `{{ . | trimPrefix "kamatera-" | replace "-" "_" }}`)
+	if err != nil {
+		t.Fatalf("new server-to-node matcher: %v", err)
+	}
+	store := NewServerStateStore()
+	store.Replace([]KamateraServer{{ID: "id-1", Name: "kamatera-pool-a-1", Datacenter: "EU"}})
+	nodes := NewNodeStateStore()
+	nodes.Replace(NodeSnapshot{Name: "pool_a_1"})
+
+	for name, matcher := range map[string]NameMatcher{"node-to-server": nodeToServer, "server-to-node": serverToNode} {
+		server, ok := matcher.FindServerForNode(NodeSnapshot{Name: "pool_a_1"}, store)
+		if !ok || server.ID != "id-1" {
+			t.Fatalf("%s: expected node to match server id-1, got %+v ok=%v", name, server, ok)
+		}
+		node, ok := matcher.FindNodeForServer(server, nodes)
+		if !ok || node.Name != "pool_a_1" {
+			t.Fatalf("%s: expected server to match node back, got %+v ok=%v", name, node, ok)
+		}
+	}
+}
+
+func TestNameMatcherRegexCaptureGroupsRoundTrip(t *testing.T) {
+	matcher, err := NewNameMatcher(`{{ regexReplace "^cluster-(pool-\\d+)$" "$1" . }}`, "")
+	if err != nil {
+		t.Fatalf("new matcher: %v", err)
+	}
+	store := NewServerStateStore()
+	store.Replace([]KamateraServer{{ID: "id-1", Name: "pool-123", Datacenter: "EU"}})
+	nodes := NewNodeStateStore()
+	nodes.Replace(NodeSnapshot{Name: "cluster-pool-123"})
+
+	server, ok := matcher.FindServerForNode(NodeSnapshot{Name: "cluster-pool-123"}, store)
+	if !ok || server.ID != "id-1" {
+		t.Fatalf("expected node to match server id-1, got %+v ok=%v", server, ok)
+	}
+	if node, ok := matcher.FindNodeForServer(server, nodes); !ok || node.Name != "cluster-pool-123" {
+		t.Fatalf("expected server to match node back, got %+v ok=%v", node, ok)
+	}
+}
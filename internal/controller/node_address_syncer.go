@@ -0,0 +1,184 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	cloudproviderapi "k8s.io/cloud-provider/api"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DefaultCloudControllerManagerLease is the leader election lease of a
+// cloud-controller-manager started with the default leader election flags.
+var DefaultCloudControllerManagerLease = types.NamespacedName{Namespace: "kube-system", Name: "cloud-controller-manager"}
+
+// NodeAddressSyncer keeps status.addresses of matched Nodes in sync with the
+// IPs of their Kamatera server's network interfaces. The Node's Hostname
+// address is kept, or set to the Node name when missing. Nodes without a
+// matching server, and servers without any IP covered by Rules, are left
+// alone.
+//
+// A cloud-controller-manager also writes status.addresses, so only Nodes
+// with a kamatera:// provider ID that no longer carry the
+// node.cloudprovider.kubernetes.io/uninitialized taint are synced, and the
+// syncer refuses to run while CloudControllerManagerLease is held.
+type NodeAddressSyncer struct {
+	client.Client
+
+	// Reader reads CloudControllerManagerLease. Nil skips the check.
+	Reader                      client.Reader
+	CloudControllerManagerLease types.NamespacedName
+
+	NodeStore   *NodeStateStore
+	ServerStore *ServerStateStore
+	Matcher     NameMatcher
+	Rules       NetworkAddressRules
+
+	Interval time.Duration
+
+	Recorder record.EventRecorder
+
+	// Now returns the current time, time.Now when nil.
+	Now func() time.Time
+
+	Log logr.Logger
+}
+
+func (s *NodeAddressSyncer) Start(ctx context.Context) error {
+	if s.Log.GetSink() == nil {
+		s.Log = ctrl.Log.WithName("controllers").WithName("NodeAddress")
+	}
+	if err := s.checkCloudControllerManager(ctx); err != nil {
+		return err
+	}
+	return runNodeSync(ctx, s.Interval, s.sync)
+}
+
+func (s *NodeAddressSyncer) NeedLeaderElection() bool {
+	return true
+}
+
+func (s *NodeAddressSyncer) now() time.Time {
+	if s.Now == nil {
+		return time.Now()
+	}
+	return s.Now()
+}
+
+// checkCloudControllerManager returns an error when a cloud-controller-manager
+// holds CloudControllerManagerLease, or when the lease cannot be read.
+func (s *NodeAddressSyncer) checkCloudControllerManager(ctx context.Context) error {
+	if s.Reader == nil {
+		return nil
+	}
+	key := s.CloudControllerManagerLease
+	if key.Name == "" {
+		key = DefaultCloudControllerManagerLease
+	}
+	var lease coordinationv1.Lease
+	if err := s.Reader.Get(ctx, key, &lease); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("get cloud-controller-manager lease %s: %w", key, err)
+	}
+	spec := lease.Spec
+	if spec.HolderIdentity == nil || *spec.HolderIdentity == "" || spec.RenewTime == nil || spec.LeaseDurationSeconds == nil {
+		return nil
+	}
+	expires := spec.RenewTime.Add(time.Duration(*spec.LeaseDurationSeconds) * time.Second)
+	if !s.now().Before(expires) {
+		return nil
+	}
+	return fmt.Errorf("cloud-controller-manager %s holds lease %s and manages node addresses, not syncing node addresses", *spec.HolderIdentity, key)
+}
+
+func (s *NodeAddressSyncer) sync(ctx context.Context) {
+	if err := s.checkCloudControllerManager(ctx); err != nil {
+		s.Log.Error(err, "skipping node address sync")
+		return
+	}
+	forEachMatchedNode(s.NodeStore, s.ServerStore, s.Matcher, func(snapshot NodeSnapshot, server KamateraServer) {
+		addresses := s.Rules.NodeAddresses(server)
+		if len(addresses) == 0 {
+			return
+		}
+		if err := s.syncNode(ctx, snapshot.Name, addresses); err != nil {
+			s.Log.Error(err, "failed to sync node addresses", "node", snapshot.Name, "serverName", server.Name)
+		}
+	})
+}
+
+func (s *NodeAddressSyncer) syncNode(ctx context.Context, nodeName string, addresses []corev1.NodeAddress) error {
+	var node corev1.Node
+	if err := s.Get(ctx, types.NamespacedName{Name: nodeName}, &node); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if !ownsNodeAddresses(&node) {
+		return nil
+	}
+	desired := append([]corev1.NodeAddress(nil), addresses...)
+	desired = append(desired, hostnameAddress(&node))
+	if reflect.DeepEqual(node.Status.Addresses, desired) {
+		return nil
+	}
+	previous := node.Status.Addresses
+	patch := client.MergeFrom(node.DeepCopy())
+	node.Status.Addresses = desired
+	if err := s.Status().Patch(ctx, &node, patch); err != nil {
+		return fmt.Errorf("patch node %s addresses: %w", nodeName, err)
+	}
+	s.Log.Info("synced node addresses", "node", nodeName, "addresses", formatNodeAddresses(desired), "previous", formatNodeAddresses(previous))
+	if s.Recorder != nil {
+		s.Recorder.Event(&node, corev1.EventTypeNormal, "AddressesSynced", "Synced addresses from Kamatera server: "+formatNodeAddresses(desired))
+	}
+	return nil
+}
+
+// ownsNodeAddresses returns whether the Node's addresses are left to the
+// syncer: Nodes with another provider ID are managed by another cloud
+// provider, and uninitialized Nodes are waiting for a cloud-controller-manager.
+func ownsNodeAddresses(node *corev1.Node) bool {
+	if !strings.HasPrefix(node.Spec.ProviderID, KamateraProviderIDPrefix) {
+		return false
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == cloudproviderapi.TaintExternalCloudProvider {
+			return false
+		}
+	}
+	return true
+}
+
+func hostnameAddress(node *corev1.Node) corev1.NodeAddress {
+	for _, address := range node.Status.Addresses {
+		if address.Type == corev1.NodeHostName {
+			return address
+		}
+	}
+	return corev1.NodeAddress{Type: corev1.NodeHostName, Address: node.Name}
+}
+
+func formatNodeAddresses(addresses []corev1.NodeAddress) string {
+	formatted := ""
+	for i, address := range addresses {
+		if i > 0 {
+			formatted += ", "
+		}
+		formatted += string(address.Type) + "=" + address.Address
+	}
+	return formatted
+}
@@ -0,0 +1,166 @@
+package controller
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	cloudproviderapi "k8s.io/cloud-provider/api"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestNodeAddressSyncerSyncsAddressesOfMatchedNodes(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker1"}, Spec: corev1.NodeSpec{ProviderID: "kamatera://EU/id-1"}}
+	node.Status.Addresses = []corev1.NodeAddress{
+		{Type: corev1.NodeInternalIP, Address: "10.0.0.1"},
+		{Type: corev1.NodeHostName, Address: "worker1.example"},
+	}
+	noIPs := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker2"}, Spec: corev1.NodeSpec{ProviderID: "kamatera://EU/id-2"}}
+	noIPs.Status.Addresses = []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.2"}}
+	c, nodeStore, serverStore := newNodeSyncTestStores(t, []*corev1.Node{node, noIPs}, []KamateraServer{
+		{ID: "id-1", Name: "worker1", Datacenter: "EU", Networks: []KamateraServerNetwork{
+			{Name: "wan-eu", IPs: []string{"203.0.113.10"}},
+			{Name: "lan-1-private", IPs: []string{"172.16.0.10"}},
+		}},
+		{ID: "id-2", Name: "worker2", Datacenter: "EU"},
+	})
+	recorder := record.NewFakeRecorder(10)
+	syncer := &NodeAddressSyncer{
+		Client:      c,
+		NodeStore:   nodeStore,
+		ServerStore: serverStore,
+		Rules:       DefaultNetworkAddressRules(),
+		Recorder:    recorder,
+		Log:         logr.Discard(),
+	}
+
+	syncer.sync(context.Background())
+	syncer.sync(context.Background())
+
+	var got corev1.Node
+	if err := c.Get(context.Background(), types.NamespacedName{Name: "worker1"}, &got); err != nil {
+		t.Fatalf("get node: %v", err)
+	}
+	expected := []corev1.NodeAddress{
+		{Type: corev1.NodeInternalIP, Address: "172.16.0.10"},
+		{Type: corev1.NodeExternalIP, Address: "203.0.113.10"},
+		{Type: corev1.NodeHostName, Address: "worker1.example"},
+	}
+	if !reflect.DeepEqual(got.Status.Addresses, expected) {
+		t.Fatalf("expected addresses %v, got %v", expected, got.Status.Addresses)
+	}
+	if err := c.Get(context.Background(), types.NamespacedName{Name: "worker2"}, &got); err != nil {
+		t.Fatalf("get node: %v", err)
+	}
+	if !reflect.DeepEqual(got.Status.Addresses, noIPs.Status.Addresses) {
+		t.Fatalf("expected addresses of server without IPs to be left alone, got %v", got.Status.Addresses)
+	}
+	want := []string{"Normal AddressesSynced Synced addresses from Kamatera server: InternalIP=172.16.0.10, ExternalIP=203.0.113.10, Hostname=worker1.example"}
+	if events := recordedEvents(recorder); !reflect.DeepEqual(events, want) {
+		t.Fatalf("expected events %v, got %v", want, events)
+	}
+}
+
+func TestNodeAddressSyncerLeavesNodesOwnedByOtherWritersAlone(t *testing.T) {
+	original := []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.1"}}
+	nodes := []*corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "no-provider-id"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "rke2"}, Spec: corev1.NodeSpec{ProviderID: "rke2://rke2"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "uninitialized"}, Spec: corev1.NodeSpec{
+			ProviderID: "kamatera://EU/id-uninitialized",
+			Taints:     []corev1.Taint{{Key: cloudproviderapi.TaintExternalCloudProvider, Value: "true", Effect: corev1.TaintEffectNoSchedule}},
+		}},
+	}
+	var servers []KamateraServer
+	for _, node := range nodes {
+		node.Status.Addresses = original
+		servers = append(servers, KamateraServer{ID: "id-" + node.Name, Name: node.Name, Datacenter: "EU", Networks: []KamateraServerNetwork{
+			{Name: "wan-eu", IPs: []string{"203.0.113.10"}},
+		}})
+	}
+	c, nodeStore, serverStore := newNodeSyncTestStores(t, nodes, servers)
+	recorder := record.NewFakeRecorder(10)
+	syncer := &NodeAddressSyncer{
+		Client:      c,
+		NodeStore:   nodeStore,
+		ServerStore: serverStore,
+		Rules:       DefaultNetworkAddressRules(),
+		Recorder:    recorder,
+		Log:         logr.Discard(),
+	}
+
+	syncer.sync(context.Background())
+
+	for _, node := range nodes {
+		var got corev1.Node
+		if err := c.Get(context.Background(), types.NamespacedName{Name: node.Name}, &got); err != nil {
+			t.Fatalf("get node: %v", err)
+		}
+		if !reflect.DeepEqual(got.Status.Addresses, original) {
+			t.Fatalf("expected addresses of node %s to be left alone, got %v", node.Name, got.Status.Addresses)
+		}
+	}
+	if events := recordedEvents(recorder); len(events) != 0 {
+		t.Fatalf("expected no events, got %v", events)
+	}
+}
+
+func TestNodeAddressSyncerRefusesToRunAlongsideCloudControllerManager(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	holder := "kamatera-cloud-controller-manager-0"
+	duration := int32(15)
+	for name, tc := range map[string]struct {
+		renewed time.Time
+		wantErr bool
+	}{
+		"held":    {renewed: now.Add(-5 * time.Second), wantErr: true},
+		"expired": {renewed: now.Add(-time.Minute)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			lease := &coordinationv1.Lease{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "cloud-controller-manager"},
+				Spec: coordinationv1.LeaseSpec{
+					HolderIdentity:       &holder,
+					LeaseDurationSeconds: &duration,
+					RenewTime:            &metav1.MicroTime{Time: tc.renewed},
+				},
+			}
+			c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(lease).Build()
+			syncer := &NodeAddressSyncer{
+				Client: c,
+				Reader: c,
+				Now:    func() time.Time { return now },
+				Log:    logr.Discard(),
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			err := syncer.Start(ctx)
+			if tc.wantErr != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if err != nil && !strings.Contains(err.Error(), holder) {
+				t.Fatalf("expected error to name the lease holder, got %v", err)
+			}
+		})
+	}
+}
+
+func TestNodeAddressSyncerRunsWithoutCloudControllerManagerLease(t *testing.T) {
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).Build()
+	syncer := &NodeAddressSyncer{Client: c, Reader: c, Log: logr.Discard()}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := syncer.Start(ctx); err != nil {
+		t.Fatalf("expected no error without a lease, got %v", err)
+	}
+}
@@ -2,11 +2,15 @@ package controller
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -18,11 +22,11 @@ const (
 // NodeReconciler deletes Node objects that have been NotReady for longer than
 // NotReadyDuration when a server snapshot is available and the matching
 // Kamatera server is absent from the snapshot or present with power=off.
+// Control-plane nodes are never acted on unless AllowControlPlane is set, and
+// Events are recorded when the decision for a Node changes.
 //
-// The reconciler is intentionally minimal: it does not cordon/drain, and it
-// refuses to delete control-plane nodes unless AllowControlPlane is set.
-//
-// This controller is meant to run in-cluster.
+// The optional fields below add safeguards in front of the deletion; each is
+// off when unset. This controller is meant to run in-cluster.
 type NodeReconciler struct {
 	client.Client
 
@@ -35,9 +39,41 @@ type NodeReconciler struct {
 	Log logr.Logger
 
 	ServerStore *ServerStateStore
-	Matcher     NameMatcher
+	// MaxServerStaleness blocks remediation and deletions while the server
+	// snapshot is older.
+	MaxServerStaleness time.Duration
+	Matcher            NameMatcher
+
+	// AbsentServerPolicy handles Nodes without a matching server, which are
+	// never deleted while the server list is suspicious.
+	AbsentServerPolicy           AbsentServerPolicy
+	AbsentServerConsecutivePolls int
+
+	// Remediator reboots or power-cycles the server of a NotReady Node that is
+	// still powered on; the Node is deleted once remediation is exhausted.
+	Remediator *NodeRemediator
+	// Drainer cordons the Node and evicts its pods before it is deleted.
+	Drainer *NodeDrainer
+
+	Recorder record.EventRecorder
+
+	// DryRun runs every check but only logs and records in
+	// DeletionCandidates what would be remediated, drained or deleted.
+	DryRun             bool
+	DeletionCandidates *DeletionCandidateStore
+
+	// Budget refuses deletions that do not fit in it and reserves each
+	// deletion before it is made.
+	Budget *DeletionBudget
 
 	ExtraLogValues []interface{}
+
+	absentServers absentServerTracker
+	events        nodeEventTracker
+
+	// uids are the UIDs of the nodes passed to the last retainNodes call.
+	uidsMu sync.Mutex
+	uids   map[string]types.UID
 }
 
 // Reconcile implements the reconciliation loop for Node objects.
@@ -46,6 +82,24 @@ type NodeReconciler struct {
 func (r *NodeReconciler) Reconcile(ctx context.Context, req ctrl.Request) error {
 	logger := r.Log.WithValues("node", req.Name)
 
+	// Determine current time, for testing
+	now := time.Now()
+	if r.Now != nil {
+		now = r.Now()
+	}
+
+	// In dry-run mode, keep the candidate list in sync with every decision.
+	var candidate *DeletionCandidate
+	if r.DryRun {
+		defer func() {
+			if candidate == nil {
+				r.DeletionCandidates.Forget(req.Name)
+				return
+			}
+			r.DeletionCandidates.Record(*candidate, now)
+		}()
+	}
+
 	var node corev1.Node
 	if err := r.Get(ctx, req.NamespacedName, &node); err != nil {
 		return client.IgnoreNotFound(err)
@@ -56,18 +110,6 @@ func (r *NodeReconciler) Reconcile(ctx context.Context, req ctrl.Request) error
 		return nil
 	}
 
-	// Skip control-plane nodes if not allowed.
-	if !r.AllowControlPlane && isControlPlaneNode(&node) {
-		logger.V(2).Info("skipping deletion of control-plane node", r.ExtraLogValues...)
-		return nil
-	}
-
-	// Determine current time, for testing
-	now := time.Now()
-	if r.Now != nil {
-		now = r.Now()
-	}
-
 	notReadyDuration := r.NotReadyDuration
 	if notReadyDuration <= 0 {
 		notReadyDuration = defaultNotReadyDuration
@@ -75,6 +117,7 @@ func (r *NodeReconciler) Reconcile(ctx context.Context, req ctrl.Request) error
 
 	readyCondition := nodeReadyCondition(&node)
 	if readyCondition != nil && readyCondition.Status == corev1.ConditionTrue {
+		r.forgetNode(node.Name)
 		return nil
 	}
 
@@ -83,39 +126,326 @@ func (r *NodeReconciler) Reconcile(ctx context.Context, req ctrl.Request) error
 	if notReadyFor < 0 {
 		notReadyFor = 0
 	}
-	if notReadyFor < notReadyDuration {
+
+	// Skip control-plane nodes if not allowed.
+	if !r.AllowControlPlane && isControlPlaneNode(&node) {
+		logger.V(2).Info("skipping deletion of control-plane node", r.ExtraLogValues...)
+		if notReadyFor >= notReadyDuration {
+			r.event(&node, corev1.EventTypeNormal, "DeletionSkipped", "Not deleting NotReady control-plane node")
+		}
+		return nil
+	}
+	remediationDue := r.Remediator.Enabled() && notReadyFor >= r.Remediator.notReadyDuration()
+	if notReadyFor < notReadyDuration && !remediationDue {
 		logger.V(1).Info("node NotReady duration is below threshold", append(r.ExtraLogValues, "notReadyFor", notReadyFor)...)
 		return nil
 	}
 
 	if r.ServerStore == nil {
 		logger.Info("node is NotReady but Kamatera server snapshot is unavailable", r.ExtraLogValues...)
+		r.event(&node, corev1.EventTypeWarning, "DeletionSkipped", "Not deleting NotReady node because the Kamatera server snapshot is unavailable")
+		return nil
+	}
+	if r.MaxServerStaleness > 0 {
+		if err := r.ServerStore.CheckFresh(now, r.MaxServerStaleness); err != nil {
+			logger.Info("not remediating or deleting node because the Kamatera server snapshot is stale", append(r.ExtraLogValues, "reason", err.Error(), "notReadyFor", notReadyFor)...)
+			if notReadyFor >= notReadyDuration {
+				r.event(&node, corev1.EventTypeWarning, "DeletionSkipped", "Not deleting NotReady node because the Kamatera server snapshot is stale")
+			}
+			return nil
+		}
+	}
+
+	server, outcome := r.Matcher.MatchServerForNode(NewNodeSnapshot(&node, nil, nil), r.ServerStore)
+	if outcome == MatchAmbiguous {
+		r.absentServers.forget(node.Name)
+		logger.Info("node is NotReady but matches several Kamatera servers, not remediating or deleting it", append(r.ExtraLogValues, "notReadyFor", notReadyFor)...)
+		if notReadyFor >= notReadyDuration {
+			r.event(&node, corev1.EventTypeWarning, "DeletionSkipped", "Not deleting NotReady node because it matches several Kamatera servers")
+		}
 		return nil
 	}
-	server, ok := r.Matcher.FindServerForNode(node.Name, r.ServerStore)
+	ok := outcome == MatchUnique
 	serverState := "unknown"
+	serverLogValues := []interface{}{}
 	if ok {
-		if server.Power != "off" {
+		r.absentServers.forget(node.Name)
+		serverLogValues = append(serverLogValues, "serverName", server.Name, "serverID", server.ID, "serverDatacenter", server.Datacenter)
+		switch {
+		case r.Remediator.InProgress(node.Name) || (server.Power != "off" && remediationDue):
+			if !r.remediate(ctx, logger, &node, server, notReadyFor, now) {
+				return nil
+			}
+			serverState = "not recovered by remediation"
+		case server.Power != "off":
 			logger.V(1).Info("node is NotReady but Kamatera server is not powered off", append(r.ExtraLogValues, "power", server.Power)...)
+			if notReadyFor >= notReadyDuration {
+				r.event(&node, corev1.EventTypeNormal, "DeletionSkipped", fmt.Sprintf("Not deleting NotReady node because Kamatera server %s is not powered off (power=%s)", server.Name, server.Power))
+			}
 			return nil
+		default:
+			if r.Remediator.CoolingDown(node.Name, now) {
+				logger.V(1).Info("node is NotReady but Kamatera server was recently remediated", append(r.ExtraLogValues, "power", server.Power)...)
+				r.event(&node, corev1.EventTypeNormal, "DeletionSkipped", fmt.Sprintf("Not deleting NotReady node because Kamatera server %s was recently remediated", server.Name))
+				return nil
+			}
+			serverState = "powered off"
 		}
-		serverState = "powered off"
 	}
 
+	if notReadyFor < notReadyDuration {
+		logger.V(1).Info("node NotReady duration is below threshold", append(r.ExtraLogValues, "notReadyFor", notReadyFor)...)
+		return nil
+	}
+
+	if !ok && !r.allowAbsentServerDeletion(logger, &node) {
+		return nil
+	}
+
+	if r.DryRun {
+		candidate = &DeletionCandidate{
+			NodeName:         node.Name,
+			ServerState:      serverState,
+			NotReadyFor:      notReadyFor.Round(time.Second).String(),
+			ServerName:       server.Name,
+			ServerID:         server.ID,
+			ServerDatacenter: server.Datacenter,
+			ServerPower:      server.Power,
+		}
+		logger.Info(
+			"dry-run: would delete node due to NotReady timeout and Kamatera server "+serverState,
+			append(append(r.ExtraLogValues, "notReadyFor", notReadyFor, "name", node.Name), serverLogValues...)...,
+		)
+		r.event(&node, corev1.EventTypeNormal, "DryRunDelete", fmt.Sprintf("Would delete node NotReady for more than %s with Kamatera server %s (dry-run)", notReadyDuration, serverState))
+		return nil
+	}
+
+	if allowed, reason := r.Budget.Allow(now); !allowed {
+		logger.Info("not deleting node because the deletion budget is exhausted", append(append(r.ExtraLogValues, "reason", reason, "serverState", serverState), serverLogValues...)...)
+		r.event(&node, corev1.EventTypeWarning, "DeletionBudgetExceeded", "Not deleting node: "+reason)
+		return nil
+	}
+
+	// cordoned is set when the node is cordoned for this deletion, so it can
+	// be uncordoned when the deletion is abandoned after the drain.
+	cordoned := false
+	if r.Drainer != nil {
+		cordoned = !node.Spec.Unschedulable
+		if err := r.Drainer.Drain(ctx, logger, &node); err != nil {
+			return err
+		}
+		// Draining can take up to the drain timeout, during which the server
+		// list may have stopped refreshing.
+		if r.MaxServerStaleness > 0 {
+			drainedAt := time.Now()
+			if r.Now != nil {
+				drainedAt = r.Now()
+			}
+			if err := r.ServerStore.CheckFresh(drainedAt, r.MaxServerStaleness); err != nil {
+				logger.Info("not deleting drained node because the Kamatera server snapshot is stale", append(r.ExtraLogValues, "reason", err.Error())...)
+				r.event(&node, corev1.EventTypeWarning, "DeletionSkipped", "Not deleting NotReady node because the Kamatera server snapshot is stale")
+				r.uncordon(ctx, logger, &node, cordoned)
+				return nil
+			}
+		}
+	}
+
+	allowed, reason, err := r.Budget.Reserve(ctx, now)
+	if err != nil {
+		r.uncordon(ctx, logger, &node, cordoned)
+		return fmt.Errorf("reserve node deletion in the deletion budget: %w", err)
+	}
+	if !allowed {
+		logger.Info("not deleting node because the deletion budget is exhausted", append(append(r.ExtraLogValues, "reason", reason, "serverState", serverState), serverLogValues...)...)
+		r.event(&node, corev1.EventTypeWarning, "DeletionBudgetExceeded", "Not deleting node: "+reason)
+		r.uncordon(ctx, logger, &node, cordoned)
+		return nil
+	}
 	if err := r.Delete(ctx, &node); err != nil {
+		r.Budget.Release(now)
 		if apierrors.IsNotFound(err) {
 			return nil
 		}
 		return err
 	}
 
+	r.forgetNode(node.Name)
+	nodeDeletions.WithLabelValues(nodeDeletionReason(serverState)).Inc()
+
 	logger.Info(
 		"deleted node due to NotReady timeout and Kamatera server "+serverState,
-		append(r.ExtraLogValues, "notReadyFor", notReadyFor, "name", node.Name)...,
+		append(append(r.ExtraLogValues, "notReadyFor", notReadyFor, "name", node.Name), serverLogValues...)...,
 	)
+	r.event(&node, corev1.EventTypeNormal, "Deleted", fmt.Sprintf("Deleted node after being NotReady for %s with Kamatera server %s", notReadyFor.Round(time.Second), serverState))
 	return nil
 }
 
+// uncordon reverts the cordon of a drained node whose deletion was abandoned,
+// when the node was cordoned for the deletion.
+func (r *NodeReconciler) uncordon(ctx context.Context, logger logr.Logger, node *corev1.Node, cordoned bool) {
+	if !cordoned {
+		return
+	}
+	if err := r.Drainer.Uncordon(ctx, logger, node); err != nil {
+		logger.Error(err, "failed to uncordon node after abandoning its deletion", r.ExtraLogValues...)
+	}
+}
+
+// allowAbsentServerDeletion returns whether a NotReady node without a matching
+// server may be deleted, according to the suspicious state of the server list
+// and the absent server policy.
+func (r *NodeReconciler) allowAbsentServerDeletion(logger logr.Logger, node *corev1.Node) bool {
+	if reason := r.ServerStore.Suspicious(); reason != "" {
+		logger.Info("not deleting node without a matching Kamatera server because the server list is suspicious", append(r.ExtraLogValues, "reason", reason)...)
+		r.event(node, corev1.EventTypeWarning, "DeletionSkipped", "Not deleting NotReady node without a matching Kamatera server because "+reason)
+		return false
+	}
+	switch r.AbsentServerPolicy {
+	case AbsentServerPolicySkip:
+		logger.V(1).Info("not deleting node without a matching Kamatera server", append(r.ExtraLogValues, "policy", r.AbsentServerPolicy)...)
+		r.event(node, corev1.EventTypeNormal, "DeletionSkipped", "Not deleting NotReady node without a matching Kamatera server")
+		return false
+	case AbsentServerPolicyRequireConsecutive:
+		required := r.AbsentServerConsecutivePolls
+		if required <= 0 {
+			required = defaultAbsentServerConsecutivePolls
+		}
+		polls := r.absentServers.observe(node.Name, r.ServerStore.Generation())
+		if polls < uint64(required) {
+			logger.V(1).Info("node has no matching Kamatera server, waiting for consecutive polls", append(r.ExtraLogValues, "polls", polls, "requiredPolls", required)...)
+			r.event(node, corev1.EventTypeNormal, "DeletionSkipped", fmt.Sprintf("Not deleting NotReady node until its Kamatera server is absent for %d consecutive polls", required))
+			return false
+		}
+	}
+	return true
+}
+
+// event records an Event on the node unless it is the same as the last Event
+// recorded for it.
+func (r *NodeReconciler) event(node *corev1.Node, eventType string, reason string, message string) {
+	if r.Recorder != nil && r.events.changed(node.Name, eventType+"/"+reason+"/"+message) {
+		r.Recorder.Event(node, eventType, reason, message)
+	}
+}
+
+// retainNodes drops the per-node state of nodes that are not in nodes, e.g.
+// nodes deleted outside the controller, and of nodes whose UID changed since
+// the last call because they were deleted and recreated under the same name.
+func (r *NodeReconciler) retainNodes(nodes []NodeSnapshot) {
+	nodeNames := make([]string, 0, len(nodes))
+	r.uidsMu.Lock()
+	previous := r.uids
+	r.uids = make(map[string]types.UID, len(nodes))
+	for _, node := range nodes {
+		nodeNames = append(nodeNames, node.Name)
+		r.uids[node.Name] = node.UID
+		if uid, ok := previous[node.Name]; ok && uid != node.UID {
+			r.forgetNode(node.Name)
+		}
+	}
+	r.uidsMu.Unlock()
+	r.Remediator.Retain(nodeNames)
+	r.absentServers.retain(nodeNames)
+	r.events.retain(nodeNames)
+}
+
+// forgetNode drops the per-node state of a node.
+func (r *NodeReconciler) forgetNode(nodeName string) {
+	r.Remediator.Forget(nodeName)
+	r.absentServers.forget(nodeName)
+	r.events.forget(nodeName)
+}
+
+// nodeEventTracker remembers the last Event recorded for each node, so a
+// decision that does not change between polls is recorded once.
+type nodeEventTracker struct {
+	mu   sync.Mutex
+	last map[string]string
+}
+
+// changed records key as the last Event of the node and returns whether it
+// differs from the previous one.
+func (t *nodeEventTracker) changed(nodeName string, key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.last == nil {
+		t.last = map[string]string{}
+	}
+	if previous, ok := t.last[nodeName]; ok && previous == key {
+		return false
+	}
+	t.last[nodeName] = key
+	return true
+}
+
+func (t *nodeEventTracker) forget(nodeName string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.last, nodeName)
+}
+
+func (t *nodeEventTracker) retain(nodeNames []string) {
+	keep := make(map[string]struct{}, len(nodeNames))
+	for _, name := range nodeNames {
+		keep[name] = struct{}{}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for name := range t.last {
+		if _, ok := keep[name]; !ok {
+			delete(t.last, name)
+		}
+	}
+}
+
+// remediate advances the remediation of a NotReady node whose server is
+// powered on or whose remediation is in progress. Nothing is started while the
+// server list is suspicious. It returns true when remediation is exhausted and
+// the node should fall back to the delete path.
+func (r *NodeReconciler) remediate(ctx context.Context, logger logr.Logger, node *corev1.Node, server KamateraServer, notReadyFor time.Duration, now time.Time) bool {
+	values := append(r.ExtraLogValues, "notReadyFor", notReadyFor, "serverName", server.Name, "power", server.Power, "mode", r.Remediator.Mode)
+	if r.DryRun {
+		logger.Info("dry-run: would remediate Kamatera server of NotReady node", values...)
+		return false
+	}
+	if reason := r.ServerStore.Suspicious(); reason != "" {
+		logger.Info("not remediating Kamatera server of NotReady node because the server list is suspicious", append(values, "reason", reason)...)
+		return false
+	}
+	if !r.ServerStore.ServerNameUnique(server.Name) {
+		logger.Info("not remediating Kamatera server of NotReady node because several Kamatera servers share its name", values...)
+		r.Remediator.Abandon(node.Name, now)
+		r.event(node, corev1.EventTypeWarning, "RemediationSkipped", fmt.Sprintf("Not remediating Kamatera server %s because several Kamatera servers share its name and power operations address servers by name", server.Name))
+		return false
+	}
+	result, err := r.Remediator.Remediate(ctx, node.Name, server, now)
+	attempts := r.Remediator.Attempts(node.Name)
+	switch result {
+	case RemediationWaiting:
+		logger.V(1).Info("node is NotReady and Kamatera server remediation is cooling down", append(values, "attempts", attempts)...)
+	case RemediationInProgress:
+		if err != nil {
+			logger.V(1).Info("failed to get the status of the Kamatera server remediation, will retry", append(values, "attempt", attempts, "error", err.Error())...)
+		} else {
+			logger.V(1).Info("node is NotReady and Kamatera server remediation is in progress", append(values, "attempt", attempts)...)
+		}
+	case RemediationExhausted:
+		logger.Info("node is still NotReady after Kamatera server remediation attempts, falling back to deletion", append(values, "attempts", attempts)...)
+		r.event(node, corev1.EventTypeWarning, "RemediationExhausted", fmt.Sprintf("Node is still NotReady after %d %s attempts of Kamatera server %s", attempts, r.Remediator.Mode, server.Name))
+		return true
+	case RemediationFailed:
+		logger.Error(err, "failed to remediate Kamatera server of NotReady node", append(values, "attempt", attempts)...)
+		r.event(node, corev1.EventTypeWarning, "RemediationFailed", fmt.Sprintf("Failed %s attempt %d of Kamatera server %s: %v", r.Remediator.Mode, attempts, server.Name, err))
+	case RemediationStarted:
+		logger.Info("started remediation of Kamatera server of NotReady node", append(values, "attempt", attempts)...)
+		r.event(node, corev1.EventTypeNormal, "RemediationStarted", fmt.Sprintf("Started %s attempt %d of Kamatera server %s", r.Remediator.Mode, attempts, server.Name))
+	case RemediationPerformed:
+		logger.Info("remediated Kamatera server of NotReady node", append(values, "attempt", attempts)...)
+		r.event(node, corev1.EventTypeNormal, "Remediated", fmt.Sprintf("Performed %s attempt %d of Kamatera server %s", r.Remediator.Mode, attempts, server.Name))
+	}
+	return false
+}
+
 func nodeNotReadySince(node *corev1.Node, readyCondition *corev1.NodeCondition, now time.Time) time.Time {
 	if readyCondition != nil {
 		notReadySince := readyCondition.LastTransitionTime.Time
@@ -2,16 +2,19 @@ package controller
 
 import (
 	"context"
+	"reflect"
 	"testing"
 	"time"
 
 	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/mock"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
@@ -358,3 +361,475 @@ func TestNodeReconciler_DoesNotDeleteNodeWithControlPlaneTaint(t *testing.T) {
 		t.Fatalf("expected tainted node to still exist: %v", err)
 	}
 }
+
+func TestNodeReconciler_RemediatesNotReadyNodeWithPoweredOnServer(t *testing.T) {
+	scheme := newTestScheme(t)
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	node := &corev1.Node{}
+	node.Name = "node-1"
+	node.Status.Conditions = []corev1.NodeCondition{{
+		Type:               corev1.NodeReady,
+		Status:             corev1.ConditionFalse,
+		LastTransitionTime: metav1.NewTime(now.Add(-12 * time.Minute)),
+	}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+	serverStore := NewServerStateStore()
+	serverStore.Replace([]KamateraServer{{Name: node.Name, Datacenter: "EU", Power: "on"}})
+	kclient := kamateraClientMock{}
+	kclient.On("StartServerPower", mock.Anything, node.Name, "restart", false).Return([]string{"1"}, nil)
+
+	r := &NodeReconciler{
+		Client:           c,
+		NotReadyDuration: 15 * time.Minute,
+		Now:              func() time.Time { return now },
+		Log:              logr.Discard(),
+		ServerStore:      serverStore,
+		Remediator:       &NodeRemediator{Client: &kclient, Mode: RemediationModeReboot, NotReadyDuration: 10 * time.Minute},
+	}
+
+	if err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}}); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	kclient.AssertNumberOfCalls(t, "StartServerPower", 1)
+	if !r.Remediator.InProgress(node.Name) {
+		t.Fatalf("expected remediation to be tracked as in progress")
+	}
+	var got corev1.Node
+	if err := c.Get(context.Background(), types.NamespacedName{Name: node.Name}, &got); err != nil {
+		t.Fatalf("expected remediated node to still exist: %v", err)
+	}
+}
+
+func TestNodeReconciler_FallsBackToDeleteWhenRemediationExhausted(t *testing.T) {
+	scheme := newTestScheme(t)
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	node := &corev1.Node{}
+	node.Name = "node-1"
+	node.Status.Conditions = []corev1.NodeCondition{{
+		Type:               corev1.NodeReady,
+		Status:             corev1.ConditionFalse,
+		LastTransitionTime: metav1.NewTime(now.Add(-20 * time.Minute)),
+	}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+	serverStore := NewServerStateStore()
+	serverStore.Replace([]KamateraServer{{Name: node.Name, Datacenter: "EU", Power: "on"}})
+	kclient := kamateraClientMock{}
+	kclient.On("StartServerPower", mock.Anything, node.Name, "restart", false).Return([]string{"1"}, nil)
+	kclient.On("CommandStatus", mock.Anything, "1").Return(KamateraCommand{ID: "1", Status: "complete"}, nil)
+	current := now
+	r := &NodeReconciler{
+		Client:           c,
+		NotReadyDuration: 15 * time.Minute,
+		Now:              func() time.Time { return current },
+		Log:              logr.Discard(),
+		ServerStore:      serverStore,
+		Remediator:       &NodeRemediator{Client: &kclient, Mode: RemediationModeReboot, MaxAttempts: 1, Cooldown: 5 * time.Minute},
+	}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}}
+
+	if err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("first reconcile: %v", err)
+	}
+	current = now.Add(time.Minute)
+	if err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("completion reconcile: %v", err)
+	}
+	current = now.Add(5 * time.Minute)
+	if err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("cooldown reconcile: %v", err)
+	}
+	var got corev1.Node
+	if err := c.Get(context.Background(), req.NamespacedName, &got); err != nil {
+		t.Fatalf("expected node to remain during remediation cooldown: %v", err)
+	}
+
+	current = now.Add(6 * time.Minute)
+	if err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("exhausted reconcile: %v", err)
+	}
+	kclient.AssertNumberOfCalls(t, "StartServerPower", 1)
+	if err := c.Get(context.Background(), req.NamespacedName, &got); err == nil || !apierrors.IsNotFound(err) {
+		t.Fatalf("expected node to be deleted after remediation was exhausted, got err=%v", err)
+	}
+}
+
+func TestNodeReconciler_DoesNotDeletePoweredOffServerDuringRemediationCooldown(t *testing.T) {
+	scheme := newTestScheme(t)
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	node := &corev1.Node{}
+	node.Name = "node-1"
+	node.Status.Conditions = []corev1.NodeCondition{{
+		Type:               corev1.NodeReady,
+		Status:             corev1.ConditionFalse,
+		LastTransitionTime: metav1.NewTime(now.Add(-20 * time.Minute)),
+	}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+	serverStore := NewServerStateStore()
+	serverStore.Replace([]KamateraServer{{Name: node.Name, Datacenter: "EU", Power: "on"}})
+	kclient := kamateraClientMock{}
+	kclient.On("StartServerPower", mock.Anything, node.Name, "off", true).Return([]string{"1"}, nil)
+	kclient.On("CommandStatus", mock.Anything, "1").Return(KamateraCommand{ID: "1", Status: "running"}, nil)
+	r := &NodeReconciler{
+		Client:           c,
+		NotReadyDuration: 15 * time.Minute,
+		Now:              func() time.Time { return now },
+		Log:              logr.Discard(),
+		ServerStore:      serverStore,
+		Remediator:       &NodeRemediator{Client: &kclient, Mode: RemediationModePowerCycle, Cooldown: 5 * time.Minute},
+	}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}}
+	if err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	serverStore.Replace([]KamateraServer{{Name: node.Name, Datacenter: "EU", Power: "off"}})
+	if err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	var got corev1.Node
+	if err := c.Get(context.Background(), req.NamespacedName, &got); err != nil {
+		t.Fatalf("expected node to remain while power-cycle snapshot is transient: %v", err)
+	}
+}
+
+func TestNodeReconciler_DoesNotRemediateOnStaleOrSuspiciousServerList(t *testing.T) {
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	node := &corev1.Node{}
+	node.Name = "node-1"
+	node.Status.Conditions = []corev1.NodeCondition{{
+		Type:               corev1.NodeReady,
+		Status:             corev1.ConditionFalse,
+		LastTransitionTime: metav1.NewTime(now.Add(-20 * time.Minute)),
+	}}
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(node).Build()
+	current := now.Add(-time.Hour)
+	clock := func() time.Time { return current }
+	serverStore := NewServerStateStore()
+	serverStore.Now = clock
+	serverStore.Replace([]KamateraServer{{Name: node.Name, Datacenter: "EU", Power: "on"}})
+	current = now
+	kclient := kamateraClientMock{}
+	r := &NodeReconciler{
+		Client:             c,
+		NotReadyDuration:   15 * time.Minute,
+		Now:                clock,
+		Log:                logr.Discard(),
+		ServerStore:        serverStore,
+		MaxServerStaleness: 10 * time.Minute,
+		Remediator:         &NodeRemediator{Client: &kclient, Mode: RemediationModeReboot},
+	}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}}
+	if err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("stale reconcile: %v", err)
+	}
+
+	serverStore.ReplaceSuspicious([]KamateraServer{{Name: node.Name, Datacenter: "EU", Power: "on"}}, "the filtered Kamatera server list dropped from 10 to 1 servers")
+	if err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("suspicious reconcile: %v", err)
+	}
+	kclient.AssertNotCalled(t, "StartServerPower", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestNodeReconciler_DoesNotRemediateServerWhoseNameIsShared(t *testing.T) {
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker1"}}
+	node.Status.Conditions = []corev1.NodeCondition{{
+		Type:               corev1.NodeReady,
+		Status:             corev1.ConditionFalse,
+		LastTransitionTime: metav1.NewTime(now.Add(-20 * time.Minute)),
+	}}
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(node).Build()
+	// The server filter keeps only the EU server, so the node matches it
+	// uniquely, but a power operation by name could hit the US server.
+	filter, err := NewServerFilter("EU", "")
+	if err != nil {
+		t.Fatalf("new filter: %v", err)
+	}
+	serverStore := NewServerStateStore()
+	kclient := kamateraClientMock{}
+	kclient.On("ListServers", mock.Anything).Return([]KamateraServer{
+		{ID: "1", Name: node.Name, Datacenter: "EU", Power: "on"},
+		{ID: "2", Name: node.Name, Datacenter: "US", Power: "on"},
+	}, nil)
+	servers := KamateraServersController{Client: &kclient, Store: serverStore, Filter: filter, Log: logr.Discard()}
+	if err := servers.poll(context.Background()); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	recorder := record.NewFakeRecorder(10)
+
+	r := &NodeReconciler{
+		Client:           c,
+		NotReadyDuration: 15 * time.Minute,
+		Now:              func() time.Time { return now },
+		Log:              logr.Discard(),
+		ServerStore:      serverStore,
+		Remediator:       &NodeRemediator{Client: &kclient, Mode: RemediationModeReboot},
+		Recorder:         recorder,
+	}
+	if err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}}); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	kclient.AssertNotCalled(t, "StartServerPower", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	expected := []string{
+		"Warning RemediationSkipped Not remediating Kamatera server worker1 because several Kamatera servers share its name and power operations address servers by name",
+	}
+	if events := recordedEvents(recorder); !reflect.DeepEqual(events, expected) {
+		t.Fatalf("expected events %v, got %v", expected, events)
+	}
+}
+
+func TestNodeReconciler_DryRunRecordsCandidateWithoutDeleting(t *testing.T) {
+	scheme := newTestScheme(t)
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	node := &corev1.Node{}
+	node.Name = "node-1"
+	node.Status.Conditions = []corev1.NodeCondition{{
+		Type:               corev1.NodeReady,
+		Status:             corev1.ConditionFalse,
+		LastTransitionTime: metav1.NewTime(now.Add(-20 * time.Minute)),
+	}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+	serverStore := NewServerStateStore()
+	serverStore.Replace([]KamateraServer{{ID: "42", Name: node.Name, Datacenter: "EU", Power: "off"}})
+	candidates := NewDeletionCandidateStore()
+	sink := &recordingLogSink{}
+
+	r := &NodeReconciler{
+		Client:             c,
+		NotReadyDuration:   15 * time.Minute,
+		Now:                func() time.Time { return now },
+		Log:                logr.New(sink),
+		ServerStore:        serverStore,
+		DryRun:             true,
+		DeletionCandidates: candidates,
+	}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}}
+	if err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	var got corev1.Node
+	if err := c.Get(context.Background(), req.NamespacedName, &got); err != nil {
+		t.Fatalf("expected node to remain in dry-run mode: %v", err)
+	}
+	list := candidates.List()
+	if len(list) != 1 || list[0].NodeName != node.Name || list[0].ServerState != "powered off" || list[0].ServerID != "42" {
+		t.Fatalf("expected node to be recorded as deletion candidate, got %+v", list)
+	}
+	if len(sink.messages) != 1 || sink.messages[0] != "dry-run: would delete node due to NotReady timeout and Kamatera server powered off" {
+		t.Fatalf("expected dry-run log line, got %v", sink.messages)
+	}
+
+	serverStore.Replace([]KamateraServer{{ID: "42", Name: node.Name, Datacenter: "EU", Power: "on"}})
+	if err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if list := candidates.List(); len(list) != 0 {
+		t.Fatalf("expected node to be removed from candidates once no longer eligible, got %+v", list)
+	}
+}
+
+func TestNodeReconciler_DryRunDoesNotRemediate(t *testing.T) {
+	scheme := newTestScheme(t)
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	node := &corev1.Node{}
+	node.Name = "node-1"
+	node.Status.Conditions = []corev1.NodeCondition{{
+		Type:               corev1.NodeReady,
+		Status:             corev1.ConditionFalse,
+		LastTransitionTime: metav1.NewTime(now.Add(-20 * time.Minute)),
+	}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+	serverStore := NewServerStateStore()
+	serverStore.Replace([]KamateraServer{{Name: node.Name, Datacenter: "EU", Power: "on"}})
+	kclient := kamateraClientMock{}
+
+	r := &NodeReconciler{
+		Client:             c,
+		NotReadyDuration:   15 * time.Minute,
+		Now:                func() time.Time { return now },
+		Log:                logr.Discard(),
+		ServerStore:        serverStore,
+		Remediator:         &NodeRemediator{Client: &kclient, Mode: RemediationModeReboot},
+		DryRun:             true,
+		DeletionCandidates: NewDeletionCandidateStore(),
+	}
+	if err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}}); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	kclient.AssertNotCalled(t, "StartServerPower", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestNodeReconciler_RecordsEventsWhenSkippingEligibleNode(t *testing.T) {
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	notReady := []corev1.NodeCondition{{
+		Type:               corev1.NodeReady,
+		Status:             corev1.ConditionFalse,
+		LastTransitionTime: metav1.NewTime(now.Add(-20 * time.Minute)),
+	}}
+	controlPlane := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "cp-1", Labels: map[string]string{"node-role.kubernetes.io/control-plane": ""}}}
+	controlPlane.Status.Conditions = notReady
+	running := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1"}}
+	running.Status.Conditions = notReady
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(controlPlane, running).Build()
+	serverStore := NewServerStateStore()
+	serverStore.Replace([]KamateraServer{{Name: running.Name, Datacenter: "EU", Power: "on"}})
+	recorder := record.NewFakeRecorder(10)
+
+	r := &NodeReconciler{
+		Client:           c,
+		NotReadyDuration: 15 * time.Minute,
+		Now:              func() time.Time { return now },
+		Log:              logr.Discard(),
+		ServerStore:      serverStore,
+		Recorder:         recorder,
+	}
+	for _, name := range []string{controlPlane.Name, running.Name} {
+		if err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: name}}); err != nil {
+			t.Fatalf("reconcile %s: %v", name, err)
+		}
+	}
+
+	expected := []string{
+		"Normal DeletionSkipped Not deleting NotReady control-plane node",
+		"Normal DeletionSkipped Not deleting NotReady node because Kamatera server worker-1 is not powered off (power=on)",
+	}
+	if events := recordedEvents(recorder); !reflect.DeepEqual(events, expected) {
+		t.Fatalf("expected events %v, got %v", expected, events)
+	}
+}
+
+func TestNodeReconciler_RecordsEventsOnlyWhenDecisionChanges(t *testing.T) {
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1"}}
+	node.Status.Conditions = []corev1.NodeCondition{{
+		Type:               corev1.NodeReady,
+		Status:             corev1.ConditionFalse,
+		LastTransitionTime: metav1.NewTime(now.Add(-20 * time.Minute)),
+	}}
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(node).Build()
+	serverStore := NewServerStateStore()
+	serverStore.Replace([]KamateraServer{{Name: node.Name, Datacenter: "EU", Power: "on"}})
+	recorder := record.NewFakeRecorder(10)
+
+	r := &NodeReconciler{
+		Client:           c,
+		NotReadyDuration: 15 * time.Minute,
+		Now:              func() time.Time { return now },
+		Log:              logr.Discard(),
+		ServerStore:      serverStore,
+		Recorder:         recorder,
+	}
+	reconcile := func() {
+		t.Helper()
+		if err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}}); err != nil {
+			t.Fatalf("reconcile: %v", err)
+		}
+	}
+	reconcile()
+	reconcile()
+	expected := []string{"Normal DeletionSkipped Not deleting NotReady node because Kamatera server worker-1 is not powered off (power=on)"}
+	if events := recordedEvents(recorder); !reflect.DeepEqual(events, expected) {
+		t.Fatalf("expected a single event for an unchanged decision, got %v", events)
+	}
+
+	serverStore.Replace([]KamateraServer{{Name: node.Name, Datacenter: "EU", Power: "on"}, {Name: node.Name, Datacenter: "US", Power: "on"}})
+	reconcile()
+	serverStore.Replace([]KamateraServer{{Name: node.Name, Datacenter: "EU", Power: "on"}})
+	reconcile()
+	expected = []string{
+		"Warning DeletionSkipped Not deleting NotReady node because it matches several Kamatera servers",
+		"Normal DeletionSkipped Not deleting NotReady node because Kamatera server worker-1 is not powered off (power=on)",
+	}
+	if events := recordedEvents(recorder); !reflect.DeepEqual(events, expected) {
+		t.Fatalf("expected events on decision changes %v, got %v", expected, events)
+	}
+}
+
+func TestNodeReconciler_DoesNotDeleteWhenServerSnapshotIsStale(t *testing.T) {
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	node.Status.Conditions = []corev1.NodeCondition{{
+		Type:               corev1.NodeReady,
+		Status:             corev1.ConditionFalse,
+		LastTransitionTime: metav1.NewTime(now.Add(-20 * time.Minute)),
+	}}
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(node).Build()
+	current := now.Add(-time.Hour)
+	clock := func() time.Time { return current }
+	serverStore := NewServerStateStore()
+	serverStore.Now = clock
+	serverStore.Replace([]KamateraServer{{Name: node.Name, Datacenter: "EU", Power: "off"}})
+	current = now
+	recorder := record.NewFakeRecorder(10)
+
+	r := &NodeReconciler{
+		Client:             c,
+		NotReadyDuration:   15 * time.Minute,
+		Now:                clock,
+		Log:                logr.Discard(),
+		ServerStore:        serverStore,
+		MaxServerStaleness: 10 * time.Minute,
+		Recorder:           recorder,
+	}
+	if err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}}); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	var got corev1.Node
+	if err := c.Get(context.Background(), types.NamespacedName{Name: node.Name}, &got); err != nil {
+		t.Fatalf("expected node not to be deleted with a stale snapshot: %v", err)
+	}
+	expected := []string{"Warning DeletionSkipped Not deleting NotReady node because the Kamatera server snapshot is stale"}
+	if events := recordedEvents(recorder); !reflect.DeepEqual(events, expected) {
+		t.Fatalf("expected events %v, got %v", expected, events)
+	}
+
+	serverStore.Replace([]KamateraServer{{Name: node.Name, Datacenter: "EU", Power: "off"}})
+	if err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}}); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if err := c.Get(context.Background(), types.NamespacedName{Name: node.Name}, &got); !apierrors.IsNotFound(err) {
+		t.Fatalf("expected node to be deleted with a fresh snapshot, got err=%v", err)
+	}
+}
+
+func TestNodeReconciler_DoesNotDeleteAmbiguouslyMatchedNode(t *testing.T) {
+	now := time.Now()
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	node.Status.Conditions = []corev1.NodeCondition{{
+		Type:               corev1.NodeReady,
+		Status:             corev1.ConditionFalse,
+		LastTransitionTime: metav1.NewTime(now.Add(-20 * time.Minute)),
+	}}
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(node).Build()
+	serverStore := NewServerStateStore()
+	serverStore.Replace([]KamateraServer{
+		{Name: node.Name, Datacenter: "EU", Power: "off"},
+		{Name: node.Name, Datacenter: "US", Power: "off"},
+	})
+	recorder := record.NewFakeRecorder(10)
+
+	r := &NodeReconciler{
+		Client:           c,
+		NotReadyDuration: 15 * time.Minute,
+		Now:              func() time.Time { return now },
+		Log:              logr.Discard(),
+		ServerStore:      serverStore,
+		Recorder:         recorder,
+	}
+	if err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}}); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	var got corev1.Node
+	if err := c.Get(context.Background(), types.NamespacedName{Name: node.Name}, &got); err != nil {
+		t.Fatalf("expected ambiguously matched node not to be deleted: %v", err)
+	}
+	expected := []string{"Warning DeletionSkipped Not deleting NotReady node because it matches several Kamatera servers"}
+	if events := recordedEvents(recorder); !reflect.DeepEqual(events, expected) {
+		t.Fatalf("expected events %v, got %v", expected, events)
+	}
+}
@@ -58,10 +58,44 @@ func (p *NodeDeletePoller) poll(ctx context.Context) error {
 		p.Log.Info("skipping node deletion poll because node reconciler is unavailable")
 		return nil
 	}
-	for _, node := range p.NodeStore.List() {
+	nodes := p.NodeStore.List()
+	if p.Reconciler.Budget != nil {
+		p.Reconciler.Budget.SetMatchedNodes(p.matchedNodes(nodes))
+		p.syncBudget(ctx)
+		defer p.syncBudget(ctx)
+	}
+	nodeNames := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		nodeNames = append(nodeNames, node.Name)
+	}
+	p.Reconciler.DeletionCandidates.Retain(nodeNames)
+	p.Reconciler.retainNodes(nodes)
+	for _, node := range nodes {
 		if err := p.Reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}}); err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+// syncBudget persists the deletion budget and applies resets requested through
+// its ConfigMap. Until its state is loaded, the budget refuses all deletions.
+func (p *NodeDeletePoller) syncBudget(ctx context.Context) {
+	now := time.Now()
+	if p.Reconciler.Now != nil {
+		now = p.Reconciler.Now()
+	}
+	if err := p.Reconciler.Budget.Sync(ctx, now); err != nil {
+		p.Log.Error(err, "failed to sync the deletion budget state")
+	}
+}
+
+func (p *NodeDeletePoller) matchedNodes(nodes []NodeSnapshot) int {
+	matched := 0
+	for _, node := range nodes {
+		if _, ok := p.Reconciler.Matcher.FindServerForNode(node, p.Reconciler.ServerStore); ok {
+			matched++
+		}
+	}
+	return matched
+}
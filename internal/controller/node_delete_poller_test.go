@@ -2,6 +2,7 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -10,7 +11,9 @@ import (
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
 )
 
 func TestNodeDeletePollerPollDeletesMatchedPoweredOffUnknownNode(t *testing.T) {
@@ -101,6 +104,45 @@ func TestNodeDeletePollerPollOnlyProcessesStoredNodes(t *testing.T) {
 	}
 }
 
+func TestNodeDeletePollerPollPrunesCandidatesOfVanishedNodes(t *testing.T) {
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	node := &corev1.Node{}
+	node.Name = "node-1"
+	node.Status.Conditions = []corev1.NodeCondition{{
+		Type:               corev1.NodeReady,
+		Status:             corev1.ConditionUnknown,
+		LastTransitionTime: metav1.NewTime(now.Add(-20 * time.Minute)),
+	}}
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(node).Build()
+	nodeStore := NewNodeStateStore()
+	nodeStore.Replace(NewNodeSnapshot(node, nil, nil))
+	serverStore := NewServerStateStore()
+	serverStore.Replace([]KamateraServer{{Name: node.Name, Datacenter: "EU", Power: "off"}})
+	candidates := NewDeletionCandidateStore()
+	candidates.Record(DeletionCandidate{NodeName: "deleted-node", ServerState: "powered off"}, now.Add(-time.Hour))
+
+	poller := &NodeDeletePoller{
+		NodeStore: nodeStore,
+		Reconciler: &NodeReconciler{
+			Client:             c,
+			ServerStore:        serverStore,
+			NotReadyDuration:   15 * time.Minute,
+			Now:                func() time.Time { return now },
+			Log:                logr.Discard(),
+			DryRun:             true,
+			DeletionCandidates: candidates,
+		},
+		Log: logr.Discard(),
+	}
+	if err := poller.poll(context.Background()); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+
+	if list := candidates.List(); len(list) != 1 || list[0].NodeName != node.Name {
+		t.Fatalf("expected only the candidate of the stored node, got %+v", list)
+	}
+}
+
 func TestNodeDeletePollerDefaultInterval(t *testing.T) {
 	if got := (&NodeDeletePoller{}).interval(); got != time.Minute {
 		t.Fatalf("expected default poll interval 1m, got %v", got)
@@ -149,3 +191,108 @@ func TestNodeDeletePollerStartDoesNotPollBeforeInterval(t *testing.T) {
 		t.Fatalf("expected node to remain before first poll interval: %v", err)
 	}
 }
+
+func TestNodeDeletePollerPollStopsAtDeletionBudget(t *testing.T) {
+	scheme := newTestScheme(t)
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	var objects []client.Object
+	nodeStore := NewNodeStateStore()
+	var servers []KamateraServer
+	for _, name := range []string{"node-1", "node-2", "node-3"} {
+		node := &corev1.Node{}
+		node.Name = name
+		node.Status.Conditions = []corev1.NodeCondition{{
+			Type:               corev1.NodeReady,
+			Status:             corev1.ConditionFalse,
+			LastTransitionTime: metav1.NewTime(now.Add(-20 * time.Minute)),
+		}}
+		objects = append(objects, node)
+		nodeStore.Replace(NewNodeSnapshot(node, nil, nil))
+		servers = append(servers, KamateraServer{Name: name, Datacenter: "EU", Power: "off"})
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+	serverStore := NewServerStateStore()
+	serverStore.Replace(servers)
+	budget := &DeletionBudget{MaxPercent: 50}
+
+	poller := &NodeDeletePoller{
+		NodeStore: nodeStore,
+		Reconciler: &NodeReconciler{
+			Client:           c,
+			ServerStore:      serverStore,
+			NotReadyDuration: 15 * time.Minute,
+			Now:              func() time.Time { return now },
+			Log:              logr.Discard(),
+			Budget:           budget,
+		},
+		Log: logr.Discard(),
+	}
+	if err := poller.poll(context.Background()); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+
+	var nodes corev1.NodeList
+	if err := c.List(context.Background(), &nodes); err != nil {
+		t.Fatalf("list nodes: %v", err)
+	}
+	if len(nodes.Items) != 2 {
+		t.Fatalf("expected only one of three nodes to be deleted within a 50%% budget, %d remain", len(nodes.Items))
+	}
+	if status := budget.Status(now); !status.Tripped || status.MatchedNodes != 3 {
+		t.Fatalf("expected tripped budget with 3 matched nodes, got %+v", status)
+	}
+}
+
+func TestNodeDeletePollerPollDoesNotDeleteWhenBudgetCannotBePersisted(t *testing.T) {
+	scheme := newTestScheme(t)
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	node := &corev1.Node{}
+	node.Name = "node-1"
+	node.Status.Conditions = []corev1.NodeCondition{{
+		Type:               corev1.NodeReady,
+		Status:             corev1.ConditionFalse,
+		LastTransitionTime: metav1.NewTime(now.Add(-20 * time.Minute)),
+	}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).WithInterceptorFuncs(interceptor.Funcs{
+		Update: func(ctx context.Context, client client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+			if _, ok := obj.(*corev1.ConfigMap); ok {
+				return errors.New("etcd unavailable")
+			}
+			return client.Update(ctx, obj, opts...)
+		},
+	}).Build()
+	nodeStore := NewNodeStateStore()
+	nodeStore.Replace(NewNodeSnapshot(node, nil, nil))
+	serverStore := NewServerStateStore()
+	serverStore.Replace([]KamateraServer{{Name: "node-1", Datacenter: "EU", Power: "off"}})
+	budget := &DeletionBudget{
+		MaxDeletions: 5,
+		Client:       c,
+		ConfigMap:    types.NamespacedName{Namespace: "kube-system", Name: "deletion-budget"},
+		Log:          logr.Discard(),
+	}
+
+	poller := &NodeDeletePoller{
+		NodeStore: nodeStore,
+		Reconciler: &NodeReconciler{
+			Client:           c,
+			ServerStore:      serverStore,
+			NotReadyDuration: 15 * time.Minute,
+			Now:              func() time.Time { return now },
+			Log:              logr.Discard(),
+			Budget:           budget,
+		},
+		Log: logr.Discard(),
+	}
+	if err := poller.poll(context.Background()); err == nil {
+		t.Fatalf("expected poll to fail when the deletion cannot be reserved")
+	}
+
+	var got corev1.Node
+	if err := c.Get(context.Background(), types.NamespacedName{Name: node.Name}, &got); err != nil {
+		t.Fatalf("expected node to remain when the deletion budget cannot be persisted: %v", err)
+	}
+	if status := budget.Status(now); status.RecentDeletions != 0 {
+		t.Fatalf("expected no deletion to be counted, got %+v", status)
+	}
+}
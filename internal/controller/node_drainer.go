@@ -0,0 +1,204 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	defaultDrainTimeout      = 5 * time.Minute
+	defaultDrainPollInterval = 5 * time.Second
+
+	podNodeNameField = "spec.nodeName"
+)
+
+// NodeDrainer cordons a Node and evicts its pods through the Eviction API, so
+// PodDisruptionBudgets are respected. DaemonSet pods, mirror pods and pods
+// that already finished are skipped. A pod counts as drained once its
+// eviction was accepted, since pods on a node whose server is down never finish
+// terminating.
+//
+// Drain gives up after Timeout and returns without error; the caller is
+// expected to continue with deleting the Node.
+type NodeDrainer struct {
+	client.Client
+
+	// Reader is used to list the pods of a node with a spec.nodeName field
+	// selector. It should be an uncached reader so the manager does not cache
+	// all pods in the cluster.
+	Reader client.Reader
+
+	Timeout      time.Duration
+	PollInterval time.Duration
+
+	Recorder record.EventRecorder
+}
+
+func (d *NodeDrainer) timeout() time.Duration {
+	if d.Timeout <= 0 {
+		return defaultDrainTimeout
+	}
+	return d.Timeout
+}
+
+func (d *NodeDrainer) pollInterval() time.Duration {
+	if d.PollInterval <= 0 {
+		return defaultDrainPollInterval
+	}
+	return d.PollInterval
+}
+
+// Drain cordons the node and evicts its pods. It returns an error only when
+// the node could not be cordoned or its pods could not be listed.
+func (d *NodeDrainer) Drain(ctx context.Context, logger logr.Logger, node *corev1.Node) error {
+	if err := d.cordon(ctx, logger, node); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, d.timeout())
+	defer cancel()
+	ticker := time.NewTicker(d.pollInterval())
+	defer ticker.Stop()
+	startedDraining := false
+	for {
+		pods, err := d.podsToEvict(ctx, node.Name)
+		if err != nil {
+			if ctx.Err() != nil {
+				d.drainTimedOut(ctx, logger, node)
+				return nil
+			}
+			return fmt.Errorf("list pods of node %s: %w", node.Name, err)
+		}
+		if len(pods) == 0 {
+			if startedDraining {
+				logger.Info("drained node")
+				d.event(node, corev1.EventTypeNormal, "Drained", "Evicted all pods from node")
+			}
+			return nil
+		}
+		if !startedDraining {
+			startedDraining = true
+			logger.Info("draining node", "pods", len(pods))
+			d.event(node, corev1.EventTypeNormal, "Draining", fmt.Sprintf("Evicting %d pods from node", len(pods)))
+		}
+		for i := range pods {
+			d.evict(ctx, logger, &pods[i])
+		}
+		select {
+		case <-ctx.Done():
+			d.drainTimedOut(ctx, logger, node)
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *NodeDrainer) drainTimedOut(ctx context.Context, logger logr.Logger, node *corev1.Node) {
+	pending, _ := d.podsToEvict(context.WithoutCancel(ctx), node.Name)
+	logger.Info("timed out draining node, continuing with deletion", "timeout", d.timeout(), "pendingPods", podNames(pending))
+	d.event(node, corev1.EventTypeWarning, "DrainTimeout", fmt.Sprintf("Timed out after %s evicting pods from node, %d pods were not evicted", d.timeout(), len(pending)))
+}
+
+func (d *NodeDrainer) cordon(ctx context.Context, logger logr.Logger, node *corev1.Node) error {
+	if node.Spec.Unschedulable {
+		return nil
+	}
+	patch := client.MergeFrom(node.DeepCopy())
+	node.Spec.Unschedulable = true
+	if err := d.Patch(ctx, node, patch); err != nil {
+		return fmt.Errorf("cordon node %s: %w", node.Name, err)
+	}
+	logger.Info("cordoned node")
+	d.event(node, corev1.EventTypeNormal, "Cordoned", "Marked node unschedulable before deletion")
+	return nil
+}
+
+// Uncordon marks a node cordoned by Drain schedulable again, for when its
+// deletion is abandoned after the drain. Evicted pods are not brought back.
+func (d *NodeDrainer) Uncordon(ctx context.Context, logger logr.Logger, node *corev1.Node) error {
+	if !node.Spec.Unschedulable {
+		return nil
+	}
+	patch := client.MergeFrom(node.DeepCopy())
+	node.Spec.Unschedulable = false
+	if err := d.Patch(ctx, node, patch); err != nil {
+		return fmt.Errorf("uncordon node %s: %w", node.Name, err)
+	}
+	logger.Info("uncordoned node")
+	d.event(node, corev1.EventTypeNormal, "Uncordoned", "Marked node schedulable again because it is no longer being deleted")
+	return nil
+}
+
+func (d *NodeDrainer) evict(ctx context.Context, logger logr.Logger, pod *corev1.Pod) {
+	eviction := &policyv1.Eviction{ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace}}
+	err := d.SubResource("eviction").Create(ctx, pod, eviction)
+	switch {
+	case err == nil:
+		logger.V(1).Info("evicted pod", "pod", client.ObjectKeyFromObject(pod))
+	case apierrors.IsNotFound(err):
+	case apierrors.IsTooManyRequests(err):
+		logger.V(1).Info("pod eviction blocked by PodDisruptionBudget, will retry", "pod", client.ObjectKeyFromObject(pod))
+	default:
+		logger.Error(err, "failed to evict pod, will retry", "pod", client.ObjectKeyFromObject(pod))
+	}
+}
+
+func (d *NodeDrainer) podsToEvict(ctx context.Context, nodeName string) ([]corev1.Pod, error) {
+	reader := d.Reader
+	if reader == nil {
+		reader = d.Client
+	}
+	var list corev1.PodList
+	if err := reader.List(ctx, &list, client.MatchingFields{podNodeNameField: nodeName}); err != nil {
+		return nil, err
+	}
+	pods := make([]corev1.Pod, 0, len(list.Items))
+	for _, pod := range list.Items {
+		if pod.Spec.NodeName != nodeName || !needsEviction(&pod) {
+			continue
+		}
+		pods = append(pods, pod)
+	}
+	return pods, nil
+}
+
+func (d *NodeDrainer) event(node *corev1.Node, eventType string, reason string, message string) {
+	if d.Recorder != nil {
+		d.Recorder.Event(node, eventType, reason, message)
+	}
+}
+
+func needsEviction(pod *corev1.Pod) bool {
+	if pod.DeletionTimestamp != nil {
+		return false
+	}
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return false
+	}
+	if _, ok := pod.Annotations[corev1.MirrorPodAnnotationKey]; ok {
+		return false
+	}
+	for _, owner := range pod.OwnerReferences {
+		if owner.Controller != nil && *owner.Controller && owner.Kind == "DaemonSet" {
+			return false
+		}
+	}
+	return true
+}
+
+func podNames(pods []corev1.Pod) []string {
+	names := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		names = append(names, pod.Namespace+"/"+pod.Name)
+	}
+	return names
+}
@@ -0,0 +1,221 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func newDrainTestClientBuilder(t *testing.T, objects ...client.Object) *fake.ClientBuilder {
+	t.Helper()
+	return fake.NewClientBuilder().
+		WithScheme(newTestScheme(t)).
+		WithObjects(objects...).
+		WithIndex(&corev1.Pod{}, podNodeNameField, func(obj client.Object) []string {
+			return []string{obj.(*corev1.Pod).Spec.NodeName}
+		})
+}
+
+func newDrainTestPod(name string, nodeName string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       corev1.PodSpec{NodeName: nodeName},
+	}
+}
+
+func recordedEvents(recorder *record.FakeRecorder) []string {
+	var events []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestNodeDrainerCordonsAndEvictsPods(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker1"}}
+	regular := newDrainTestPod("regular", node.Name)
+	otherNode := newDrainTestPod("other-node", "worker2")
+	isController := true
+	daemonSet := newDrainTestPod("daemonset", node.Name)
+	daemonSet.OwnerReferences = []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "DaemonSet", Name: "ds", UID: "ds-uid", Controller: &isController}}
+	mirror := newDrainTestPod("mirror", node.Name)
+	mirror.Annotations = map[string]string{corev1.MirrorPodAnnotationKey: "hash"}
+	succeeded := newDrainTestPod("succeeded", node.Name)
+	succeeded.Status.Phase = corev1.PodSucceeded
+	c := newDrainTestClientBuilder(t, node, regular, otherNode, daemonSet, mirror, succeeded).Build()
+	recorder := record.NewFakeRecorder(10)
+	drainer := &NodeDrainer{Client: c, Timeout: time.Second, PollInterval: time.Millisecond, Recorder: recorder}
+
+	if err := drainer.Drain(context.Background(), logr.Discard(), node); err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+
+	var gotNode corev1.Node
+	if err := c.Get(context.Background(), types.NamespacedName{Name: node.Name}, &gotNode); err != nil {
+		t.Fatalf("get node: %v", err)
+	}
+	if !gotNode.Spec.Unschedulable {
+		t.Fatalf("expected node to be cordoned")
+	}
+	var pod corev1.Pod
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(regular), &pod); !apierrors.IsNotFound(err) {
+		t.Fatalf("expected regular pod to be evicted, got err=%v", err)
+	}
+	for _, remaining := range []*corev1.Pod{otherNode, daemonSet, mirror, succeeded} {
+		if err := c.Get(context.Background(), client.ObjectKeyFromObject(remaining), &pod); err != nil {
+			t.Fatalf("expected pod %s to remain: %v", remaining.Name, err)
+		}
+	}
+	events := strings.Join(recordedEvents(recorder), "\n")
+	for _, reason := range []string{"Cordoned", "Draining", "Drained"} {
+		if !strings.Contains(events, reason) {
+			t.Fatalf("expected %s event, got %q", reason, events)
+		}
+	}
+}
+
+func TestNodeDrainerGivesUpWhenEvictionBlockedByDisruptionBudget(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker1"}}
+	pod := newDrainTestPod("protected", node.Name)
+	c := newDrainTestClientBuilder(t, node, pod).WithInterceptorFuncs(interceptor.Funcs{
+		SubResourceCreate: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+			return apierrors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 0)
+		},
+	}).Build()
+	recorder := record.NewFakeRecorder(10)
+	drainer := &NodeDrainer{Client: c, Timeout: 20 * time.Millisecond, PollInterval: time.Millisecond, Recorder: recorder}
+
+	if err := drainer.Drain(context.Background(), logr.Discard(), node); err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+
+	var got corev1.Pod
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(pod), &got); err != nil {
+		t.Fatalf("expected protected pod to remain: %v", err)
+	}
+	events := strings.Join(recordedEvents(recorder), "\n")
+	if !strings.Contains(events, "DrainTimeout") {
+		t.Fatalf("expected DrainTimeout event, got %q", events)
+	}
+}
+
+func TestNodeReconciler_DrainsBeforeDeletingNode(t *testing.T) {
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker1"}}
+	node.Status.Conditions = []corev1.NodeCondition{{
+		Type:               corev1.NodeReady,
+		Status:             corev1.ConditionFalse,
+		LastTransitionTime: metav1.NewTime(now.Add(-20 * time.Minute)),
+	}}
+	pod := newDrainTestPod("app", node.Name)
+	c := newDrainTestClientBuilder(t, node, pod).Build()
+	serverStore := NewServerStateStore()
+	serverStore.Replace([]KamateraServer{{Name: node.Name, Datacenter: "EU", Power: "off"}})
+	recorder := record.NewFakeRecorder(10)
+
+	r := &NodeReconciler{
+		Client:           c,
+		NotReadyDuration: 15 * time.Minute,
+		Now:              func() time.Time { return now },
+		Log:              logr.Discard(),
+		ServerStore:      serverStore,
+		Drainer:          &NodeDrainer{Client: c, Timeout: time.Second, PollInterval: time.Millisecond, Recorder: recorder},
+		Recorder:         recorder,
+	}
+	if err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}}); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	var gotPod corev1.Pod
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(pod), &gotPod); !apierrors.IsNotFound(err) {
+		t.Fatalf("expected pod to be evicted before node deletion, got err=%v", err)
+	}
+	var gotNode corev1.Node
+	if err := c.Get(context.Background(), types.NamespacedName{Name: node.Name}, &gotNode); !apierrors.IsNotFound(err) {
+		t.Fatalf("expected node to be deleted, got err=%v", err)
+	}
+	events := recordedEvents(recorder)
+	if len(events) != 4 || !strings.Contains(events[3], "Deleted") {
+		t.Fatalf("expected cordon, drain and delete events, got %v", events)
+	}
+}
+
+func TestNodeReconciler_DoesNotDeleteDrainedNodeWhenSnapshotWentStale(t *testing.T) {
+	for name, alreadyCordoned := range map[string]bool{
+		"uncordons the node":         false,
+		"keeps an operator's cordon": true,
+	} {
+		t.Run(name, func(t *testing.T) {
+			now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+			node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker1"}, Spec: corev1.NodeSpec{Unschedulable: alreadyCordoned}}
+			node.Status.Conditions = []corev1.NodeCondition{{
+				Type:               corev1.NodeReady,
+				Status:             corev1.ConditionFalse,
+				LastTransitionTime: metav1.NewTime(now.Add(-20 * time.Minute)),
+			}}
+			c := newDrainTestClientBuilder(t, node, newDrainTestPod("app", node.Name)).Build()
+			// The clock moves past the staleness limit after the refresh and the
+			// start of the reconcile, i.e. while the node is drained.
+			readings := 0
+			clock := func() time.Time {
+				readings++
+				if readings > 2 {
+					return now.Add(time.Hour)
+				}
+				return now
+			}
+			serverStore := NewServerStateStore()
+			serverStore.Now = clock
+			serverStore.Replace([]KamateraServer{{Name: node.Name, Datacenter: "EU", Power: "off"}})
+			recorder := record.NewFakeRecorder(10)
+
+			r := &NodeReconciler{
+				Client:             c,
+				NotReadyDuration:   15 * time.Minute,
+				Now:                clock,
+				Log:                logr.Discard(),
+				ServerStore:        serverStore,
+				MaxServerStaleness: 10 * time.Minute,
+				Drainer:            &NodeDrainer{Client: c, Timeout: time.Second, PollInterval: time.Millisecond, Recorder: recorder},
+				Recorder:           recorder,
+			}
+			if err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}}); err != nil {
+				t.Fatalf("reconcile: %v", err)
+			}
+
+			var gotNode corev1.Node
+			if err := c.Get(context.Background(), types.NamespacedName{Name: node.Name}, &gotNode); err != nil {
+				t.Fatalf("expected drained node not to be deleted with a stale snapshot: %v", err)
+			}
+			if gotNode.Spec.Unschedulable != alreadyCordoned {
+				t.Fatalf("expected unschedulable %v after abandoning the deletion, got %v", alreadyCordoned, gotNode.Spec.Unschedulable)
+			}
+			events := recordedEvents(recorder)
+			if !alreadyCordoned {
+				if len(events) == 0 || events[len(events)-1] != "Normal Uncordoned Marked node schedulable again because it is no longer being deleted" {
+					t.Fatalf("expected an uncordon event after abandoning the deletion, got %v", events)
+				}
+				events = events[:len(events)-1]
+			}
+			if len(events) == 0 || !strings.Contains(events[len(events)-1], "snapshot is stale") {
+				t.Fatalf("expected a stale snapshot event after draining, got %v", events)
+			}
+		})
+	}
+}
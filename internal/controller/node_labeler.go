@@ -0,0 +1,154 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// KamateraServerNameLabel holds the name of the Node's Kamatera server.
+	KamateraServerNameLabel = "kamatera.io/server-name"
+	// KamateraBillingLabel holds the billing cycle of the Node's Kamatera
+	// server, e.g. hourly or monthly.
+	KamateraBillingLabel = "kamatera.io/billing"
+	// KamateraTagLabelPrefix prefixes one label per Kamatera server tag, with
+	// the value "true".
+	KamateraTagLabelPrefix = "kamatera.io/tag-"
+)
+
+// KamateraNodeLabels returns the labels derived from a server's attributes.
+// Attributes that are unknown or cannot be expressed as a label are left out.
+func KamateraNodeLabels(server KamateraServer) map[string]string {
+	labels := map[string]string{}
+	if datacenter := labelValue(server.Datacenter); datacenter != "" {
+		labels[corev1.LabelTopologyRegion] = datacenter
+		labels[corev1.LabelTopologyZone] = datacenter
+	}
+	if instanceType := labelValue(KamateraInstanceType(server)); instanceType != "" {
+		labels[corev1.LabelInstanceTypeStable] = instanceType
+	}
+	if name := labelValue(server.Name); name != "" {
+		labels[KamateraServerNameLabel] = name
+	}
+	if billing := labelValue(server.Billing); billing != "" {
+		labels[KamateraBillingLabel] = billing
+	}
+	for _, tag := range server.Tags {
+		key := KamateraTagLabelPrefix + labelValue(tag)
+		if key == KamateraTagLabelPrefix || len(validation.IsQualifiedName(key)) > 0 {
+			continue
+		}
+		labels[key] = "true"
+	}
+	return labels
+}
+
+// labelValue returns value as a valid label value, replacing invalid
+// characters with dashes, or an empty string when that is not possible.
+func labelValue(value string) string {
+	value = invalidLabelValueChars.ReplaceAllString(strings.TrimSpace(value), "-")
+	value = strings.Trim(value, "._-")
+	if len(value) > validation.LabelValueMaxLength {
+		value = strings.TrimRight(value[:validation.LabelValueMaxLength], "._-")
+	}
+	if len(validation.IsValidLabelValue(value)) > 0 {
+		return ""
+	}
+	return value
+}
+
+// isKamateraOwnedLabel reports whether the NodeLabeler removes the label from
+// a Node when the server no longer has the attribute.
+func isKamateraOwnedLabel(key string) bool {
+	return key == KamateraServerNameLabel || key == KamateraBillingLabel || strings.HasPrefix(key, KamateraTagLabelPrefix)
+}
+
+// NodeLabeler keeps topology, instance type and kamatera.io labels of matched
+// Nodes in sync with the attributes of their Kamatera server. The topology
+// and instance type labels are only ever set, never removed, since other
+// components may manage them too. Nodes without a matching server are left
+// alone.
+type NodeLabeler struct {
+	client.Client
+
+	NodeStore   *NodeStateStore
+	ServerStore *ServerStateStore
+	Matcher     NameMatcher
+
+	Interval time.Duration
+
+	Recorder record.EventRecorder
+
+	Log logr.Logger
+}
+
+func (l *NodeLabeler) Start(ctx context.Context) error {
+	if l.Log.GetSink() == nil {
+		l.Log = ctrl.Log.WithName("controllers").WithName("NodeLabeler")
+	}
+	return runNodeSync(ctx, l.Interval, l.sync)
+}
+
+func (l *NodeLabeler) NeedLeaderElection() bool {
+	return true
+}
+
+func (l *NodeLabeler) sync(ctx context.Context) {
+	forEachMatchedNode(l.NodeStore, l.ServerStore, l.Matcher, func(snapshot NodeSnapshot, server KamateraServer) {
+		if err := l.syncNode(ctx, snapshot.Name, KamateraNodeLabels(server)); err != nil {
+			l.Log.Error(err, "failed to sync node labels", "node", snapshot.Name, "serverName", server.Name)
+		}
+	})
+}
+
+func (l *NodeLabeler) syncNode(ctx context.Context, nodeName string, desired map[string]string) error {
+	var node corev1.Node
+	if err := l.Get(ctx, types.NamespacedName{Name: nodeName}, &node); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	var changed []string
+	patch := client.MergeFrom(node.DeepCopy())
+	for key, value := range desired {
+		if current, ok := node.Labels[key]; ok && current == value {
+			continue
+		}
+		if node.Labels == nil {
+			node.Labels = map[string]string{}
+		}
+		node.Labels[key] = value
+		changed = append(changed, key)
+	}
+	for key := range node.Labels {
+		if _, ok := desired[key]; !ok && isKamateraOwnedLabel(key) {
+			delete(node.Labels, key)
+			changed = append(changed, key)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	sort.Strings(changed)
+	if err := l.Patch(ctx, &node, patch); err != nil {
+		return fmt.Errorf("patch node %s labels: %w", nodeName, err)
+	}
+	l.Log.Info("synced node labels", "node", nodeName, "changed", changed)
+	if l.Recorder != nil {
+		l.Recorder.Event(&node, corev1.EventTypeNormal, "LabelsSynced", "Synced labels from Kamatera server: "+strings.Join(changed, ", "))
+	}
+	return nil
+}
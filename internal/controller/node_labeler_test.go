@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestKamateraNodeLabels(t *testing.T) {
+	labels := KamateraNodeLabels(KamateraServer{
+		Name:       "worker 1",
+		Datacenter: "EU-FR",
+		CPU:        "2B",
+		RAMMB:      4096,
+		Billing:    "hourly",
+		Tags:       []string{"gpu", "team/a", "", strings.Repeat("x", 80)},
+	})
+	expected := map[string]string{
+		"topology.kubernetes.io/region":    "EU-FR",
+		"topology.kubernetes.io/zone":      "EU-FR",
+		"node.kubernetes.io/instance-type": "2B-4096MB",
+		"kamatera.io/server-name":          "worker-1",
+		"kamatera.io/billing":              "hourly",
+		"kamatera.io/tag-gpu":              "true",
+		"kamatera.io/tag-team-a":           "true",
+	}
+	if !reflect.DeepEqual(labels, expected) {
+		t.Fatalf("expected labels %v, got %v", expected, labels)
+	}
+	if labels := KamateraNodeLabels(KamateraServer{}); len(labels) != 0 {
+		t.Fatalf("expected no labels for server without attributes, got %v", labels)
+	}
+}
+
+func TestNodeLabelerSyncsLabelsOfMatchedNodes(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker1", Labels: map[string]string{
+		"kubernetes.io/hostname": "worker1",
+		"kamatera.io/billing":    "monthly",
+		"kamatera.io/tag-old":    "true",
+	}}}
+	unmatched := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker2", Labels: map[string]string{"kamatera.io/tag-old": "true"}}}
+	c, nodeStore, serverStore := newNodeSyncTestStores(t, []*corev1.Node{node, unmatched}, []KamateraServer{
+		{ID: "id-1", Name: "worker1", Datacenter: "EU", CPU: "2B", RAMMB: 2048, Tags: []string{"gpu"}},
+	})
+	recorder := record.NewFakeRecorder(10)
+	labeler := &NodeLabeler{
+		Client:      c,
+		NodeStore:   nodeStore,
+		ServerStore: serverStore,
+		Recorder:    recorder,
+		Log:         logr.Discard(),
+	}
+
+	labeler.sync(context.Background())
+	labeler.sync(context.Background())
+
+	var got corev1.Node
+	if err := c.Get(context.Background(), types.NamespacedName{Name: "worker1"}, &got); err != nil {
+		t.Fatalf("get node: %v", err)
+	}
+	expected := map[string]string{
+		"kubernetes.io/hostname":           "worker1",
+		"topology.kubernetes.io/region":    "EU",
+		"topology.kubernetes.io/zone":      "EU",
+		"node.kubernetes.io/instance-type": "2B-2048MB",
+		"kamatera.io/server-name":          "worker1",
+		"kamatera.io/tag-gpu":              "true",
+	}
+	if !reflect.DeepEqual(got.Labels, expected) {
+		t.Fatalf("expected labels %v, got %v", expected, got.Labels)
+	}
+	if err := c.Get(context.Background(), types.NamespacedName{Name: "worker2"}, &got); err != nil {
+		t.Fatalf("get node: %v", err)
+	}
+	if got.Labels["kamatera.io/tag-old"] != "true" {
+		t.Fatalf("expected labels of unmatched node to be left alone, got %v", got.Labels)
+	}
+	events := recordedEvents(recorder)
+	if len(events) != 1 || !strings.HasPrefix(events[0], "Normal LabelsSynced ") {
+		t.Fatalf("expected a single LabelsSynced event, got %v", events)
+	}
+}
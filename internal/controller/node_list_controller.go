@@ -31,7 +31,7 @@ func (r *NodeListReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 				if trackedTaints == nil {
 					trackedTaints = parseTrackedKeys(defaultTrackedTaintsCSV)
 				}
-				matchedServer, matched := r.Matcher.FindServerForNode(previous.Name, r.ServerStore)
+				matchedServer, matched := r.Matcher.FindServerForNode(previous, r.ServerStore)
 				logger.Info("node deleted", nodeLogValues(previous, trackedTaints, r.TrackedAnnotations, matchedServer, matched)...)
 			}
 			return ctrl.Result{}, nil
@@ -47,7 +47,7 @@ func (r *NodeListReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 	}
 	snapshot := NewNodeSnapshot(&node, trackedTaints, r.TrackedAnnotations)
 	diff := r.Store.Replace(snapshot)
-	matchedServer, matched := r.Matcher.FindServerForNode(node.Name, r.ServerStore)
+	matchedServer, matched := r.Matcher.FindServerForNode(snapshot, r.ServerStore)
 	r.logDiff(logger, diff, trackedTaints, r.TrackedAnnotations, matchedServer, matched)
 	return ctrl.Result{}, nil
 }
@@ -83,6 +83,7 @@ func nodeLogValues(snapshot NodeSnapshot, trackedTaints map[string]struct{}, tra
 		"trackedAnnotations", trackedAnnotationPresence(snapshot, trackedAnnotations),
 		"matchedServer", matched,
 		"serverName", matchedServer.Name,
+		"serverID", matchedServer.ID,
 		"serverDatacenter", matchedServer.Datacenter,
 		"serverPower", matchedServer.Power,
 	}
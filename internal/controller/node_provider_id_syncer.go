@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// NodeProviderIDSyncer sets spec.providerID on Nodes that have none to the
+// provider ID of their uniquely matched Kamatera server, of the form
+// kamatera://<datacenter>/<server-id>. Kubernetes does not allow changing a
+// provider ID once set, so Nodes that already have one are left alone.
+type NodeProviderIDSyncer struct {
+	client.Client
+
+	NodeStore   *NodeStateStore
+	ServerStore *ServerStateStore
+	Matcher     NameMatcher
+
+	Interval time.Duration
+
+	Recorder record.EventRecorder
+
+	Log logr.Logger
+}
+
+func (s *NodeProviderIDSyncer) Start(ctx context.Context) error {
+	if s.Log.GetSink() == nil {
+		s.Log = ctrl.Log.WithName("controllers").WithName("NodeProviderID")
+	}
+	return runNodeSync(ctx, s.Interval, s.sync)
+}
+
+func (s *NodeProviderIDSyncer) NeedLeaderElection() bool {
+	return true
+}
+
+func (s *NodeProviderIDSyncer) sync(ctx context.Context) {
+	forEachMatchedNode(s.NodeStore, s.ServerStore, s.Matcher, func(snapshot NodeSnapshot, server KamateraServer) {
+		providerID := KamateraProviderID(server)
+		if snapshot.ProviderID != "" || providerID == "" {
+			return
+		}
+		if err := s.setProviderID(ctx, snapshot.Name, providerID); err != nil {
+			s.Log.Error(err, "failed to set node providerID", "node", snapshot.Name, "providerID", providerID, "serverName", server.Name)
+		}
+	})
+}
+
+func (s *NodeProviderIDSyncer) setProviderID(ctx context.Context, nodeName string, providerID string) error {
+	var node corev1.Node
+	if err := s.Get(ctx, types.NamespacedName{Name: nodeName}, &node); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if node.Spec.ProviderID != "" {
+		return nil
+	}
+	patch := client.MergeFrom(node.DeepCopy())
+	node.Spec.ProviderID = providerID
+	if err := s.Patch(ctx, &node, patch); err != nil {
+		return fmt.Errorf("patch node %s: %w", nodeName, err)
+	}
+	s.Log.Info("set node providerID", "node", nodeName, "providerID", providerID)
+	if s.Recorder != nil {
+		s.Recorder.Event(&node, corev1.EventTypeNormal, "ProviderIDSet", "Set providerID to "+providerID)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestNodeProviderIDSyncerSetsProviderIDOfUniquelyMatchedNodes(t *testing.T) {
+	matched := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker1"}}
+	rke2 := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker2"}, Spec: corev1.NodeSpec{ProviderID: "rke2://worker2"}}
+	ambiguous := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker3"}}
+	unmatched := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker4"}}
+	c, nodeStore, serverStore := newNodeSyncTestStores(t, []*corev1.Node{matched, rke2, ambiguous, unmatched}, []KamateraServer{
+		{ID: "id-1", Name: "worker1", Datacenter: "EU", Power: "on"},
+		{ID: "id-2", Name: "worker2", Datacenter: "EU", Power: "on"},
+		{ID: "id-3a", Name: "worker3", Datacenter: "EU", Power: "on"},
+		{ID: "id-3b", Name: "worker3", Datacenter: "US", Power: "on"},
+	})
+	recorder := record.NewFakeRecorder(10)
+	syncer := &NodeProviderIDSyncer{
+		Client:      c,
+		NodeStore:   nodeStore,
+		ServerStore: serverStore,
+		Recorder:    recorder,
+		Log:         logr.Discard(),
+	}
+
+	syncer.sync(context.Background())
+
+	expected := map[string]string{
+		"worker1": "kamatera://EU/id-1",
+		"worker2": "rke2://worker2",
+		"worker3": "",
+		"worker4": "",
+	}
+	for name, providerID := range expected {
+		var node corev1.Node
+		if err := c.Get(context.Background(), types.NamespacedName{Name: name}, &node); err != nil {
+			t.Fatalf("get node %s: %v", name, err)
+		}
+		if node.Spec.ProviderID != providerID {
+			t.Fatalf("expected node %s providerID %q, got %q", name, providerID, node.Spec.ProviderID)
+		}
+	}
+	if events, want := recordedEvents(recorder), []string{"Normal ProviderIDSet Set providerID to kamatera://EU/id-1"}; !reflect.DeepEqual(events, want) {
+		t.Fatalf("expected events %v, got %v", want, events)
+	}
+}
+
+func TestNodeProviderIDSyncerSkipsNodesWithProviderIDSetSinceSnapshot(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker1"}}
+	nodeStore := NewNodeStateStore()
+	nodeStore.Replace(NewNodeSnapshot(node, nil, nil))
+	node.Spec.ProviderID = "rke2://worker1"
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(node).Build()
+
+	serverStore := NewServerStateStore()
+	serverStore.Replace([]KamateraServer{{ID: "id-1", Name: "worker1", Datacenter: "EU", Power: "on"}})
+	syncer := &NodeProviderIDSyncer{
+		Client:      c,
+		NodeStore:   nodeStore,
+		ServerStore: serverStore,
+		Log:         logr.Discard(),
+	}
+
+	syncer.sync(context.Background())
+
+	var got corev1.Node
+	if err := c.Get(context.Background(), types.NamespacedName{Name: "worker1"}, &got); err != nil {
+		t.Fatalf("get node: %v", err)
+	}
+	if got.Spec.ProviderID != "rke2://worker1" {
+		t.Fatalf("expected providerID to be left alone, got %q", got.Spec.ProviderID)
+	}
+}
@@ -0,0 +1,308 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRemediationNotReadyDuration = 10 * time.Minute
+	defaultRemediationMaxAttempts      = 2
+	defaultRemediationCooldown         = 15 * time.Minute
+)
+
+type RemediationMode string
+
+const (
+	RemediationModeNone       RemediationMode = "none"
+	RemediationModeReboot     RemediationMode = "reboot"
+	RemediationModePowerCycle RemediationMode = "power-cycle"
+)
+
+func ParseRemediationMode(value string) (RemediationMode, error) {
+	switch mode := RemediationMode(strings.TrimSpace(value)); mode {
+	case "", RemediationModeNone:
+		return RemediationModeNone, nil
+	case RemediationModeReboot, RemediationModePowerCycle:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unsupported remediation mode %q, must be one of none, reboot, power-cycle", value)
+	}
+}
+
+type RemediationResult string
+
+const (
+	// RemediationWaiting means the node is still within the cooldown of a
+	// previous remediation attempt.
+	RemediationWaiting RemediationResult = "waiting"
+	// RemediationStarted means the Kamatera commands of a new attempt were
+	// started.
+	RemediationStarted RemediationResult = "started"
+	// RemediationInProgress means the Kamatera commands of the current attempt
+	// are still running.
+	RemediationInProgress RemediationResult = "in-progress"
+	// RemediationPerformed means the server was rebooted or power-cycled.
+	RemediationPerformed RemediationResult = "performed"
+	// RemediationFailed means the current attempt failed.
+	RemediationFailed RemediationResult = "failed"
+	// RemediationExhausted means all attempts were used and the last attempt
+	// did not bring the node back; the caller should fall back to deletion.
+	RemediationExhausted RemediationResult = "exhausted"
+)
+
+// NodeRemediator reboots or power-cycles the Kamatera server of a NotReady Node
+// whose server is still powered on. Attempts are tracked per node in memory and
+// limited by MaxAttempts, with at least Cooldown between the end of an attempt
+// and the next one. The state of a node is reset when it is forgotten, which
+// happens once it is Ready again.
+//
+// Remediate never waits for the Kamatera commands of an attempt: it starts them
+// and tracks them by command ID on the following calls, so one slow server does
+// not hold up the other nodes. A power-cycle powers the server on once its
+// power off command completed. Commands that do not complete within
+// CommandTimeout fail the attempt.
+type NodeRemediator struct {
+	Client kamateraAPIClient
+	Mode   RemediationMode
+
+	NotReadyDuration time.Duration
+	MaxAttempts      int
+	Cooldown         time.Duration
+	CommandTimeout   time.Duration
+
+	mu    sync.Mutex
+	nodes map[string]remediationState
+}
+
+type remediationState struct {
+	Attempts int
+	// LastAttempt is when the last attempt started, or ended once it is no
+	// longer in progress.
+	LastAttempt time.Time
+	// Commands are the IDs of the running Kamatera commands of the current
+	// attempt, empty when no attempt is in progress.
+	Commands []string
+	// NextPower is the power operation to start once Commands complete.
+	NextPower string
+	// StepStarted is when Commands were started.
+	StepStarted time.Time
+}
+
+// Enabled returns true when the remediator is configured to act.
+func (m *NodeRemediator) Enabled() bool {
+	return m != nil && m.Mode != "" && m.Mode != RemediationModeNone
+}
+
+func (m *NodeRemediator) notReadyDuration() time.Duration {
+	if m.NotReadyDuration <= 0 {
+		return defaultRemediationNotReadyDuration
+	}
+	return m.NotReadyDuration
+}
+
+func (m *NodeRemediator) maxAttempts() int {
+	if m.MaxAttempts <= 0 {
+		return defaultRemediationMaxAttempts
+	}
+	return m.MaxAttempts
+}
+
+func (m *NodeRemediator) cooldown() time.Duration {
+	if m.Cooldown <= 0 {
+		return defaultRemediationCooldown
+	}
+	return m.Cooldown
+}
+
+func (m *NodeRemediator) commandTimeout() time.Duration {
+	if m.CommandTimeout <= 0 {
+		return defaultCommandTimeout
+	}
+	return m.CommandTimeout
+}
+
+// Attempts returns the number of remediation attempts made for a node.
+func (m *NodeRemediator) Attempts(nodeName string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.nodes[nodeName].Attempts
+}
+
+// InProgress returns true while the Kamatera commands of a remediation attempt
+// of a node are running.
+func (m *NodeRemediator) InProgress(nodeName string) bool {
+	if !m.Enabled() {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.nodes[nodeName].Commands) > 0
+}
+
+// CoolingDown returns true while a remediation attempt of a node is in progress
+// or within the cooldown of its end. Server snapshots taken during that window
+// may show the transient power state of a power-cycle, so they must not drive
+// deletion.
+func (m *NodeRemediator) CoolingDown(nodeName string, now time.Time) bool {
+	if !m.Enabled() {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state := m.nodes[nodeName]
+	return len(state.Commands) > 0 || (!state.LastAttempt.IsZero() && now.Sub(state.LastAttempt) < m.cooldown())
+}
+
+// Abandon ends the attempt in progress of a node without starting its next
+// step. The attempt counts and starts the cooldown.
+func (m *NodeRemediator) Abandon(nodeName string, now time.Time) {
+	if !m.Enabled() {
+		return
+	}
+	if state := m.state(nodeName); len(state.Commands) > 0 {
+		m.endAttempt(nodeName, state, now)
+	}
+}
+
+// Forget resets the remediation state of a node.
+func (m *NodeRemediator) Forget(nodeName string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.nodes, nodeName)
+}
+
+// Retain resets the remediation state of nodes that are not in nodeNames.
+func (m *NodeRemediator) Retain(nodeNames []string) {
+	if m == nil {
+		return
+	}
+	keep := make(map[string]struct{}, len(nodeNames))
+	for _, name := range nodeNames {
+		keep[name] = struct{}{}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name := range m.nodes {
+		if _, ok := keep[name]; !ok {
+			delete(m.nodes, name)
+		}
+	}
+}
+
+func (m *NodeRemediator) state(nodeName string) remediationState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.nodes[nodeName]
+}
+
+func (m *NodeRemediator) setState(nodeName string, state remediationState) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.nodes == nil {
+		m.nodes = map[string]remediationState{}
+	}
+	m.nodes[nodeName] = state
+}
+
+// Remediate advances the remediation of a node: it checks the commands of the
+// attempt in progress, or starts the next attempt if one is due. A failed
+// attempt still counts as an attempt.
+func (m *NodeRemediator) Remediate(ctx context.Context, nodeName string, server KamateraServer, now time.Time) (RemediationResult, error) {
+	state := m.state(nodeName)
+	if len(state.Commands) > 0 {
+		return m.track(ctx, nodeName, server, state, now)
+	}
+	if !state.LastAttempt.IsZero() && now.Sub(state.LastAttempt) < m.cooldown() {
+		return RemediationWaiting, nil
+	}
+	if state.Attempts >= m.maxAttempts() {
+		return RemediationExhausted, nil
+	}
+	state.Attempts++
+	state.LastAttempt = now
+	m.setState(nodeName, state)
+
+	if m.Client == nil {
+		return RemediationFailed, fmt.Errorf("kamatera API client is unavailable")
+	}
+	var power, nextPower string
+	force := false
+	switch m.Mode {
+	case RemediationModeReboot:
+		power = "restart"
+	case RemediationModePowerCycle:
+		power, nextPower, force = "off", "on", true
+	default:
+		return RemediationFailed, fmt.Errorf("unsupported remediation mode %q", m.Mode)
+	}
+	commandIDs, err := m.Client.StartServerPower(ctx, server, power, force)
+	if err != nil {
+		return RemediationFailed, fmt.Errorf("power %s server %s: %w", power, server.Name, err)
+	}
+	state.Commands = commandIDs
+	state.NextPower = nextPower
+	state.StepStarted = now
+	m.setState(nodeName, state)
+	return RemediationStarted, nil
+}
+
+// track checks the running commands of the attempt in progress and starts the
+// next step of a power-cycle once they completed. Errors getting the status of
+// a command leave the attempt in progress.
+func (m *NodeRemediator) track(ctx context.Context, nodeName string, server KamateraServer, state remediationState, now time.Time) (RemediationResult, error) {
+	if now.Sub(state.StepStarted) >= m.commandTimeout() {
+		m.endAttempt(nodeName, state, now)
+		return RemediationFailed, fmt.Errorf("kamatera commands %v of server %s did not complete within %s", state.Commands, server.Name, m.commandTimeout())
+	}
+	var running []string
+	for _, commandID := range state.Commands {
+		command, err := m.Client.CommandStatus(ctx, commandID)
+		if err != nil {
+			return RemediationInProgress, fmt.Errorf("get status of Kamatera command %s: %w", commandID, err)
+		}
+		if !command.Done() {
+			running = append(running, commandID)
+			continue
+		}
+		if err := command.Err(); err != nil {
+			m.endAttempt(nodeName, state, now)
+			return RemediationFailed, err
+		}
+	}
+	state.Commands = running
+	if len(running) > 0 {
+		m.setState(nodeName, state)
+		return RemediationInProgress, nil
+	}
+	if state.NextPower == "" {
+		m.endAttempt(nodeName, state, now)
+		return RemediationPerformed, nil
+	}
+	power := state.NextPower
+	commandIDs, err := m.Client.StartServerPower(ctx, server, power, false)
+	if err != nil {
+		m.endAttempt(nodeName, state, now)
+		return RemediationFailed, fmt.Errorf("power %s server %s: %w", power, server.Name, err)
+	}
+	state.Commands = commandIDs
+	state.NextPower = ""
+	state.StepStarted = now
+	m.setState(nodeName, state)
+	return RemediationInProgress, nil
+}
+
+// endAttempt records the end of the attempt in progress, which starts the
+// cooldown.
+func (m *NodeRemediator) endAttempt(nodeName string, state remediationState, now time.Time) {
+	state.Commands = nil
+	state.NextPower = ""
+	state.LastAttempt = now
+	m.setState(nodeName, state)
+}
@@ -0,0 +1,178 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+)
+
+func TestParseRemediationMode(t *testing.T) {
+	for input, want := range map[string]RemediationMode{"": RemediationModeNone, "none": RemediationModeNone, "reboot": RemediationModeReboot, " power-cycle ": RemediationModePowerCycle} {
+		got, err := ParseRemediationMode(input)
+		if err != nil || got != want {
+			t.Fatalf("ParseRemediationMode(%q) = %q, %v; want %q", input, got, err, want)
+		}
+	}
+	if _, err := ParseRemediationMode("terminate"); err == nil {
+		t.Fatalf("expected unsupported mode error")
+	}
+}
+
+func TestNodeRemediatorRebootsUntilAttemptsExhausted(t *testing.T) {
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	kclient := kamateraClientMock{}
+	kclient.On("StartServerPower", mock.Anything, "kamatera-worker1", "restart", false).Return([]string{"7"}, nil)
+	kclient.On("CommandStatus", mock.Anything, "7").Return(KamateraCommand{ID: "7", Status: "complete"}, nil)
+	remediator := &NodeRemediator{Client: &kclient, Mode: RemediationModeReboot, MaxAttempts: 2, Cooldown: 10 * time.Minute}
+	server := KamateraServer{Name: "kamatera-worker1", Datacenter: "EU", Power: "on"}
+
+	steps := []struct {
+		at   time.Duration
+		want RemediationResult
+	}{
+		{at: 0, want: RemediationStarted},
+		{at: time.Minute, want: RemediationPerformed},
+		{at: 5 * time.Minute, want: RemediationWaiting},
+		{at: 11 * time.Minute, want: RemediationStarted},
+		{at: 12 * time.Minute, want: RemediationPerformed},
+		{at: 15 * time.Minute, want: RemediationWaiting},
+		{at: 22 * time.Minute, want: RemediationExhausted},
+	}
+	for _, step := range steps {
+		got, err := remediator.Remediate(context.Background(), "worker1", server, now.Add(step.at))
+		if err != nil {
+			t.Fatalf("remediate at %v: %v", step.at, err)
+		}
+		if got != step.want {
+			t.Fatalf("remediate at %v = %q, want %q", step.at, got, step.want)
+		}
+	}
+	kclient.AssertNumberOfCalls(t, "StartServerPower", 2)
+	if remediator.Attempts("worker1") != 2 {
+		t.Fatalf("expected 2 attempts, got %d", remediator.Attempts("worker1"))
+	}
+
+	remediator.Forget("worker1")
+	if got, _ := remediator.Remediate(context.Background(), "worker1", server, now.Add(23*time.Minute)); got != RemediationStarted {
+		t.Fatalf("expected remediation to restart after forget, got %q", got)
+	}
+}
+
+func TestNodeRemediatorRetainResetsRemovedNodes(t *testing.T) {
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	kclient := kamateraClientMock{}
+	kclient.On("StartServerPower", mock.Anything, mock.Anything, "restart", false).Return([]string{"7"}, nil)
+	remediator := &NodeRemediator{Client: &kclient, Mode: RemediationModeReboot, MaxAttempts: 2}
+	for _, name := range []string{"worker1", "worker2"} {
+		if _, err := remediator.Remediate(context.Background(), name, KamateraServer{Name: name, Datacenter: "EU", Power: "on"}, now); err != nil {
+			t.Fatalf("remediate %s: %v", name, err)
+		}
+	}
+
+	remediator.Retain([]string{"worker2"})
+	if remediator.Attempts("worker1") != 0 || remediator.InProgress("worker1") {
+		t.Fatalf("expected state of removed node to be reset")
+	}
+	if remediator.Attempts("worker2") != 1 || !remediator.InProgress("worker2") {
+		t.Fatalf("expected state of retained node to be kept")
+	}
+}
+
+func TestNodeRemediatorTracksPowerCycleCommandsAcrossCalls(t *testing.T) {
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	kclient := kamateraClientMock{}
+	powerOff := kclient.On("StartServerPower", mock.Anything, "worker1", "off", true).Return([]string{"1"}, nil).Once()
+	kclient.On("CommandStatus", mock.Anything, "1").Return(KamateraCommand{ID: "1", Status: "running"}, nil).Once()
+	kclient.On("CommandStatus", mock.Anything, "1").Return(KamateraCommand{ID: "1", Status: "complete"}, nil).Once()
+	kclient.On("StartServerPower", mock.Anything, "worker1", "on", false).Return([]string{"2"}, nil).Once().NotBefore(powerOff)
+	kclient.On("CommandStatus", mock.Anything, "2").Return(KamateraCommand{}, errors.New("api down")).Once()
+	kclient.On("CommandStatus", mock.Anything, "2").Return(KamateraCommand{ID: "2", Status: "complete"}, nil).Once()
+	remediator := &NodeRemediator{Client: &kclient, Mode: RemediationModePowerCycle}
+	server := KamateraServer{Name: "worker1", Power: "on"}
+
+	steps := []struct {
+		want    RemediationResult
+		wantErr bool
+	}{
+		{want: RemediationStarted},
+		{want: RemediationInProgress},
+		{want: RemediationInProgress},
+		{want: RemediationInProgress, wantErr: true},
+		{want: RemediationPerformed},
+	}
+	for i, step := range steps {
+		at := now.Add(time.Duration(i) * time.Minute)
+		if i > 0 && !remediator.InProgress("worker1") {
+			t.Fatalf("expected remediation to be in progress before step %d", i)
+		}
+		got, err := remediator.Remediate(context.Background(), "worker1", server, at)
+		if got != step.want || (err != nil) != step.wantErr {
+			t.Fatalf("step %d: remediate = %q, %v; want %q", i, got, err, step.want)
+		}
+	}
+	kclient.AssertExpectations(t)
+	if remediator.InProgress("worker1") || !remediator.CoolingDown("worker1", now.Add(5*time.Minute)) {
+		t.Fatalf("expected finished remediation to be cooling down")
+	}
+}
+
+func TestNodeRemediatorFailsAttemptOnCommandErrorAndTimeout(t *testing.T) {
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	kclient := kamateraClientMock{}
+	kclient.On("StartServerPower", mock.Anything, "worker1", "restart", false).Return([]string{"1"}, nil).Once()
+	kclient.On("CommandStatus", mock.Anything, "1").Return(KamateraCommand{ID: "1", Status: "error", Log: "server is locked"}, nil)
+	kclient.On("StartServerPower", mock.Anything, "worker1", "restart", false).Return([]string{"2"}, nil).Once()
+	kclient.On("CommandStatus", mock.Anything, "2").Return(KamateraCommand{ID: "2", Status: "running"}, nil)
+	remediator := &NodeRemediator{Client: &kclient, Mode: RemediationModeReboot, Cooldown: time.Minute, CommandTimeout: 10 * time.Minute}
+	server := KamateraServer{Name: "worker1", Power: "on"}
+
+	if got, _ := remediator.Remediate(context.Background(), "worker1", server, now); got != RemediationStarted {
+		t.Fatalf("expected attempt to start, got %q", got)
+	}
+	if got, err := remediator.Remediate(context.Background(), "worker1", server, now.Add(time.Minute)); got != RemediationFailed || err == nil || !strings.Contains(err.Error(), "server is locked") {
+		t.Fatalf("expected failed command to fail the attempt, got %q, %v", got, err)
+	}
+	if got, _ := remediator.Remediate(context.Background(), "worker1", server, now.Add(2*time.Minute)); got != RemediationStarted {
+		t.Fatalf("expected second attempt to start after the cooldown, got %q", got)
+	}
+	if got, _ := remediator.Remediate(context.Background(), "worker1", server, now.Add(11*time.Minute)); got != RemediationInProgress {
+		t.Fatalf("expected running command to keep the attempt in progress, got %q", got)
+	}
+	if got, err := remediator.Remediate(context.Background(), "worker1", server, now.Add(12*time.Minute)); got != RemediationFailed || err == nil {
+		t.Fatalf("expected command timeout to fail the attempt, got %q, %v", got, err)
+	}
+	if remediator.InProgress("worker1") {
+		t.Fatalf("expected timed out attempt to end")
+	}
+}
+
+func TestNodeRemediatorCountsFailedAttempts(t *testing.T) {
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	kclient := kamateraClientMock{}
+	kclient.On("StartServerPower", mock.Anything, "worker1", "restart", false).Return(nil, errors.New("api down"))
+	remediator := &NodeRemediator{Client: &kclient, Mode: RemediationModeReboot, MaxAttempts: 1, Cooldown: time.Minute}
+
+	if got, err := remediator.Remediate(context.Background(), "worker1", KamateraServer{Name: "worker1"}, now); got != RemediationFailed || err == nil {
+		t.Fatalf("expected reboot error, got %q, %v", got, err)
+	}
+	if !remediator.CoolingDown("worker1", now.Add(30*time.Second)) {
+		t.Fatalf("expected node to be cooling down after failed attempt")
+	}
+	if got, _ := remediator.Remediate(context.Background(), "worker1", KamateraServer{Name: "worker1"}, now.Add(time.Minute)); got != RemediationExhausted {
+		t.Fatalf("expected failed attempt to count towards the limit, got %q", got)
+	}
+}
+
+func TestNodeRemediatorDisabledByDefault(t *testing.T) {
+	var remediator *NodeRemediator
+	if remediator.Enabled() {
+		t.Fatalf("expected nil remediator to be disabled")
+	}
+	if (&NodeRemediator{Mode: RemediationModeNone}).Enabled() {
+		t.Fatalf("expected none mode to be disabled")
+	}
+}
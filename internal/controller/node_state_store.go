@@ -4,19 +4,31 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 const defaultTrackedTaintsCSV = "ToBeDeletedByClusterAutoscaler,DeletionCandidateOfClusterAutoscaler"
 
 type NodeSnapshot struct {
 	Name          string
+	UID           types.UID
+	ProviderID    string
+	IPs           []string
+	Labels        map[string]string
 	Ready         corev1.ConditionStatus
+	NotReadySince time.Time
 	Deleting      bool
 	Unschedulable bool
 	Taints        map[string]TrackedTaint
 	Annotations   map[string]string
+
+	// BoundServerName and BoundServerDatacenter are the Kamatera server the
+	// Node is bound to by annotation, if any.
+	BoundServerName       string
+	BoundServerDatacenter string
 }
 
 type TrackedTaint struct {
@@ -28,6 +40,18 @@ type TrackedTaint struct {
 type NodeStateStore struct {
 	mu    sync.RWMutex
 	nodes map[string]NodeSnapshot
+
+	// The indexes below map to names of nodes and are updated by Replace and
+	// Delete.
+	byProviderID  map[string]map[string]struct{}
+	byBoundServer map[string]map[string]struct{}
+	byIP          map[string]map[string]struct{}
+
+	// nameIndexes map names transformed by a matching template to names of
+	// nodes. They are registered on first use and updated by Replace and
+	// Delete.
+	nameIndexMu sync.Mutex
+	nameIndexes map[string]*transformedNameIndex
 }
 
 type NodeStateDiff struct {
@@ -43,7 +67,12 @@ type NodeStateDiff struct {
 }
 
 func NewNodeStateStore() *NodeStateStore {
-	return &NodeStateStore{nodes: map[string]NodeSnapshot{}}
+	return &NodeStateStore{
+		nodes:         map[string]NodeSnapshot{},
+		byProviderID:  map[string]map[string]struct{}{},
+		byBoundServer: map[string]map[string]struct{}{},
+		byIP:          map[string]map[string]struct{}{},
+	}
 }
 
 func DefaultTrackedTaintsCSV() string {
@@ -68,12 +97,25 @@ func parseTrackedKeys(csv string) map[string]struct{} {
 
 func NewNodeSnapshot(node *corev1.Node, trackedTaints map[string]struct{}, trackedAnnotations map[string]struct{}) NodeSnapshot {
 	snapshot := NodeSnapshot{
-		Name:          node.Name,
-		Ready:         nodeReadyStatus(node),
-		Deleting:      node.DeletionTimestamp != nil,
-		Unschedulable: node.Spec.Unschedulable,
-		Taints:        map[string]TrackedTaint{},
-		Annotations:   map[string]string{},
+		Name:                  node.Name,
+		UID:                   node.UID,
+		ProviderID:            node.Spec.ProviderID,
+		Ready:                 nodeReadyStatus(node),
+		Deleting:              node.DeletionTimestamp != nil,
+		Unschedulable:         node.Spec.Unschedulable,
+		Labels:                copyStringMap(node.Labels),
+		BoundServerName:       strings.TrimSpace(node.Annotations[ServerNameAnnotation]),
+		BoundServerDatacenter: strings.TrimSpace(node.Annotations[ServerDatacenterAnnotation]),
+		Taints:                map[string]TrackedTaint{},
+		Annotations:           map[string]string{},
+	}
+	for _, address := range node.Status.Addresses {
+		if address.Type == corev1.NodeInternalIP || address.Type == corev1.NodeExternalIP {
+			snapshot.IPs = append(snapshot.IPs, address.Address)
+		}
+	}
+	if snapshot.Ready != corev1.ConditionTrue {
+		snapshot.NotReadySince = nodeNotReadySince(node, nodeReadyCondition(node), time.Time{})
 	}
 	for _, taint := range node.Spec.Taints {
 		if _, ok := trackedTaints[taint.Key]; ok {
@@ -101,7 +143,11 @@ func (s *NodeStateStore) Replace(snapshot NodeSnapshot) NodeStateDiff {
 		diff.TaintsChanged = changedTaintKeys(previous.Taints, current.Taints)
 		diff.AnnotationsChanged = changedAnnotationKeys(previous.Annotations, current.Annotations)
 	}
+	if existed {
+		s.unindex(previous)
+	}
 	s.nodes[snapshot.Name] = current
+	s.index(current, !existed)
 	return diff
 }
 
@@ -111,6 +157,8 @@ func (s *NodeStateStore) Delete(name string) (NodeSnapshot, bool) {
 	previous, ok := s.nodes[name]
 	if ok {
 		delete(s.nodes, name)
+		s.unindex(previous)
+		s.unindexName(name)
 	}
 	return copyNodeSnapshot(previous), ok
 }
@@ -133,7 +181,143 @@ func (s *NodeStateStore) List() []NodeSnapshot {
 	return nodes
 }
 
+// index adds the snapshot to the indexes, and to the transformed name indexes
+// when the node is new, since node names never change. s.mu must be held for
+// writing.
+func (s *NodeStateStore) index(snapshot NodeSnapshot, added bool) {
+	addToNameSet(s.byProviderID, snapshot.ProviderID, snapshot.Name)
+	addToNameSet(s.byBoundServer, snapshot.BoundServerName, snapshot.Name)
+	for _, ip := range snapshot.IPs {
+		addToNameSet(s.byIP, ip, snapshot.Name)
+	}
+	if !added {
+		return
+	}
+	s.nameIndexMu.Lock()
+	defer s.nameIndexMu.Unlock()
+	for _, index := range s.nameIndexes {
+		if transformed, ok := index.transform.apply(snapshot.Name); ok {
+			index.keys[transformed] = append(index.keys[transformed], snapshot.Name)
+		}
+	}
+}
+
+func (s *NodeStateStore) unindex(snapshot NodeSnapshot) {
+	removeFromNameSet(s.byProviderID, snapshot.ProviderID, snapshot.Name)
+	removeFromNameSet(s.byBoundServer, snapshot.BoundServerName, snapshot.Name)
+	for _, ip := range snapshot.IPs {
+		removeFromNameSet(s.byIP, ip, snapshot.Name)
+	}
+}
+
+func (s *NodeStateStore) unindexName(name string) {
+	s.nameIndexMu.Lock()
+	defer s.nameIndexMu.Unlock()
+	for _, index := range s.nameIndexes {
+		transformed, ok := index.transform.apply(name)
+		if !ok {
+			continue
+		}
+		names := index.keys[transformed]
+		for i, indexed := range names {
+			if indexed == name {
+				names = append(names[:i:i], names[i+1:]...)
+				break
+			}
+		}
+		if len(names) == 0 {
+			delete(index.keys, transformed)
+		} else {
+			index.keys[transformed] = names
+		}
+	}
+}
+
+func addToNameSet(index map[string]map[string]struct{}, key string, name string) {
+	if key == "" {
+		return
+	}
+	if index[key] == nil {
+		index[key] = map[string]struct{}{}
+	}
+	index[key][name] = struct{}{}
+}
+
+func removeFromNameSet(index map[string]map[string]struct{}, key string, name string) {
+	if names, ok := index[key]; ok {
+		delete(names, name)
+		if len(names) == 0 {
+			delete(index, key)
+		}
+	}
+}
+
+// nodesWithProviderID returns the nodes with the given provider ID.
+func (s *NodeStateStore) nodesWithProviderID(providerID string) []NodeSnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.nodesByNames(s.byProviderID[providerID])
+}
+
+// nodesBoundTo returns the nodes bound to a server with the given name by
+// annotation, in any datacenter.
+func (s *NodeStateStore) nodesBoundTo(serverName string) []NodeSnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.nodesByNames(s.byBoundServer[serverName])
+}
+
+// nodesWithIPs returns the nodes that have any of the IPs.
+func (s *NodeStateStore) nodesWithIPs(ips []string) []NodeSnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := map[string]struct{}{}
+	for _, ip := range ips {
+		for name := range s.byIP[ip] {
+			names[name] = struct{}{}
+		}
+	}
+	return s.nodesByNames(names)
+}
+
+// nodesWithTransformedName returns the nodes whose name transforms to name.
+func (s *NodeStateStore) nodesWithTransformedName(transform *nameTransform, name string) []NodeSnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.nameIndexMu.Lock()
+	defer s.nameIndexMu.Unlock()
+	index, ok := s.nameIndexes[transform.spec]
+	if !ok {
+		if s.nameIndexes == nil {
+			s.nameIndexes = map[string]*transformedNameIndex{}
+		}
+		index = &transformedNameIndex{transform: transform, keys: map[string][]string{}}
+		for nodeName := range s.nodes {
+			if transformed, ok := transform.apply(nodeName); ok {
+				index.keys[transformed] = append(index.keys[transformed], nodeName)
+			}
+		}
+		s.nameIndexes[transform.spec] = index
+	}
+	nodes := make([]NodeSnapshot, 0, len(index.keys[name]))
+	for _, nodeName := range index.keys[name] {
+		nodes = append(nodes, copyNodeSnapshot(s.nodes[nodeName]))
+	}
+	return nodes
+}
+
+func (s *NodeStateStore) nodesByNames(names map[string]struct{}) []NodeSnapshot {
+	nodes := make([]NodeSnapshot, 0, len(names))
+	for name := range names {
+		nodes = append(nodes, copyNodeSnapshot(s.nodes[name]))
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	return nodes
+}
+
 func copyNodeSnapshot(snapshot NodeSnapshot) NodeSnapshot {
+	snapshot.IPs = append([]string(nil), snapshot.IPs...)
+	snapshot.Labels = copyStringMap(snapshot.Labels)
 	snapshot.Taints = copyTrackedTaints(snapshot.Taints)
 	snapshot.Annotations = copyStringMap(snapshot.Annotations)
 	return snapshot
@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"reflect"
 	"testing"
 
 	corev1 "k8s.io/api/core/v1"
@@ -215,3 +216,54 @@ func TestNewNodeSnapshotDetectsDeletingNode(t *testing.T) {
 		t.Fatalf("expected deleting snapshot")
 	}
 }
+
+func TestNewNodeSnapshotTracksIPAddresses(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	node.Status.Addresses = []corev1.NodeAddress{
+		{Type: corev1.NodeHostName, Address: "node-1"},
+		{Type: corev1.NodeInternalIP, Address: "172.16.0.10"},
+		{Type: corev1.NodeExternalIP, Address: "203.0.113.10"},
+	}
+	snapshot := NewNodeSnapshot(node, nil, nil)
+	if !reflect.DeepEqual(snapshot.IPs, []string{"172.16.0.10", "203.0.113.10"}) {
+		t.Fatalf("unexpected snapshot IPs %v", snapshot.IPs)
+	}
+}
+
+func TestNodeStateStoreIndexesFollowReplaceAndDelete(t *testing.T) {
+	transform, err := parseNameTransform("kamatera-%s")
+	if err != nil {
+		t.Fatalf("parse transform: %v", err)
+	}
+	store := NewNodeStateStore()
+	store.Replace(NodeSnapshot{Name: "worker1", ProviderID: "kamatera://EU/id-1", IPs: []string{"10.0.0.1"}})
+	if nodes := store.nodesWithTransformedName(transform, "kamatera-worker1"); len(nodes) != 1 {
+		t.Fatalf("unexpected nodes by transformed name: %+v", nodes)
+	}
+	store.Replace(NodeSnapshot{Name: "worker2", BoundServerName: "legacy", IPs: []string{"10.0.0.2"}})
+
+	store.Replace(NodeSnapshot{Name: "worker1", ProviderID: "kamatera://EU/id-9", IPs: []string{"10.0.0.3"}})
+	if nodes := store.nodesWithProviderID("kamatera://EU/id-1"); len(nodes) != 0 {
+		t.Fatalf("expected the previous provider ID to be unindexed, got %+v", nodes)
+	}
+	if nodes := store.nodesWithProviderID("kamatera://EU/id-9"); len(nodes) != 1 || nodes[0].Name != "worker1" {
+		t.Fatalf("unexpected nodes by provider ID: %+v", nodes)
+	}
+	if nodes := store.nodesWithIPs([]string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}); len(nodes) != 2 || nodes[0].Name != "worker1" || nodes[1].Name != "worker2" {
+		t.Fatalf("unexpected nodes by IP: %+v", nodes)
+	}
+	if nodes := store.nodesBoundTo("legacy"); len(nodes) != 1 || nodes[0].Name != "worker2" {
+		t.Fatalf("unexpected bound nodes: %+v", nodes)
+	}
+	if nodes := store.nodesWithTransformedName(transform, "kamatera-worker2"); len(nodes) != 1 {
+		t.Fatalf("expected added nodes to be indexed by transformed name, got %+v", nodes)
+	}
+
+	store.Delete("worker2")
+	if nodes := store.nodesBoundTo("legacy"); len(nodes) != 0 {
+		t.Fatalf("expected deleted node to be unindexed, got %+v", nodes)
+	}
+	if nodes := store.nodesWithTransformedName(transform, "kamatera-worker2"); len(nodes) != 0 {
+		t.Fatalf("expected deleted node to be unindexed by transformed name, got %+v", nodes)
+	}
+}
@@ -0,0 +1,49 @@
+package controller
+
+import (
+	"context"
+	"time"
+)
+
+// defaultNodeSyncInterval is the sync interval of the node syncers, which
+// keep an attribute of matched Nodes in sync with their Kamatera server, when
+// none is configured.
+const defaultNodeSyncInterval = time.Minute
+
+// runNodeSync implements Start of the node syncers: it calls sync every
+// interval, or defaultNodeSyncInterval when interval is not positive, until
+// ctx is done.
+func runNodeSync(ctx context.Context, interval time.Duration, sync func(context.Context)) error {
+	if interval <= 0 {
+		interval = defaultNodeSyncInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			sync(ctx)
+		}
+	}
+}
+
+// forEachMatchedNode calls fn with every Node of nodeStore that is not being
+// deleted and its matching server in serverStore. Nodes without a matching
+// server are skipped.
+func forEachMatchedNode(nodeStore *NodeStateStore, serverStore *ServerStateStore, matcher NameMatcher, fn func(NodeSnapshot, KamateraServer)) {
+	if nodeStore == nil || serverStore == nil {
+		return
+	}
+	for _, snapshot := range nodeStore.List() {
+		if snapshot.Deleting {
+			continue
+		}
+		server, ok := matcher.FindServerForNode(snapshot, serverStore)
+		if !ok {
+			continue
+		}
+		fn(snapshot, server)
+	}
+}
@@ -0,0 +1,55 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newNodeSyncTestStores returns a fake client with the given nodes and the
+// node and server stores the node syncers read them from.
+func newNodeSyncTestStores(t *testing.T, nodes []*corev1.Node, servers []KamateraServer) (client.Client, *NodeStateStore, *ServerStateStore) {
+	t.Helper()
+	builder := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithStatusSubresource(&corev1.Node{})
+	nodeStore := NewNodeStateStore()
+	for _, node := range nodes {
+		builder = builder.WithObjects(node)
+		nodeStore.Replace(NewNodeSnapshot(node, nil, nil))
+	}
+	serverStore := NewServerStateStore()
+	serverStore.Replace(servers)
+	return builder.Build(), nodeStore, serverStore
+}
+
+func TestForEachMatchedNodeSkipsDeletingAndUnmatchedNodes(t *testing.T) {
+	deleting := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:              "worker2",
+		DeletionTimestamp: &metav1.Time{},
+		Finalizers:        []string{"example.com/finalizer"},
+	}}
+	nodes := []*corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "worker1"}},
+		deleting,
+		{ObjectMeta: metav1.ObjectMeta{Name: "worker3"}},
+	}
+	_, nodeStore, serverStore := newNodeSyncTestStores(t, nodes, []KamateraServer{
+		{ID: "id-1", Name: "worker1", Datacenter: "EU"},
+		{ID: "id-2", Name: "worker2", Datacenter: "EU"},
+	})
+
+	var got []string
+	forEachMatchedNode(nodeStore, serverStore, NameMatcher{}, func(snapshot NodeSnapshot, server KamateraServer) {
+		got = append(got, snapshot.Name+"="+server.ID)
+	})
+	if want := []string{"worker1=id-1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected matched nodes %v, got %v", want, got)
+	}
+
+	forEachMatchedNode(nil, serverStore, NameMatcher{}, func(NodeSnapshot, KamateraServer) {
+		t.Fatalf("expected no nodes without a node store")
+	})
+}
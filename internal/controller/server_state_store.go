@@ -1,16 +1,41 @@
 package controller
 
 import (
+	"fmt"
+	"net/http"
 	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 )
 
 type KamateraServer struct {
-	Name       string
-	Datacenter string
-	Power      string
+	ID          string
+	Name        string
+	Datacenter  string
+	Power       string
+	CPU         string
+	RAMMB       int
+	DiskSizesGB []int
+	Networks    []KamateraServerNetwork
+	Billing     string
+	Tags        []string
+	CreatedAt   time.Time
+}
+
+type KamateraServerNetwork struct {
+	Name string
+	IPs  []string
+}
+
+// IPs returns all IP addresses of the server across its networks.
+func (s KamateraServer) IPs() []string {
+	var ips []string
+	for _, network := range s.Networks {
+		ips = append(ips, network.IPs...)
+	}
+	return ips
 }
 
 type ServerFilter struct {
@@ -51,9 +76,39 @@ func (f ServerFilter) Match(server KamateraServer) bool {
 }
 
 type ServerStateStore struct {
-	mu          sync.RWMutex
-	initialized bool
-	servers     map[string]KamateraServer
+	// Now returns the current time, used to stamp refreshes. It defaults to
+	// time.Now and must be the clock freshness is checked against.
+	Now func() time.Time
+
+	mu                  sync.RWMutex
+	initialized         bool
+	lastRefresh         time.Time
+	consecutiveFailures int
+	generation          uint64
+	suspicious          string
+	servers             map[string]KamateraServer
+	// listedNames counts the servers per name in the unfiltered server list,
+	// including servers excluded by the server filter.
+	listedNames map[string]int
+
+	// The indexes below map to keys of servers and are rebuilt by Replace.
+	// sorted holds all keys in List order.
+	sorted []string
+	byName map[string][]string
+	byID   map[string]string
+	byIP   map[string][]string
+
+	// nameIndexes map names transformed by a matching template to keys of
+	// servers. They are registered on first use and rebuilt by Replace.
+	nameIndexMu sync.Mutex
+	nameIndexes map[string]*transformedNameIndex
+}
+
+// transformedNameIndex maps the transformed names of all objects in a store to
+// the keys of the objects.
+type transformedNameIndex struct {
+	transform *nameTransform
+	keys      map[string][]string
 }
 
 type ServerStateDiff struct {
@@ -69,19 +124,34 @@ type ServerPowerChange struct {
 	Datacenter string
 	OldPower   string
 	NewPower   string
+	Server     KamateraServer
 }
 
 func NewServerStateStore() *ServerStateStore {
 	return &ServerStateStore{servers: map[string]KamateraServer{}}
 }
 
+// Replace publishes a trusted server list, clearing the suspicious mark.
 func (s *ServerStateStore) Replace(servers []KamateraServer) ServerStateDiff {
+	return s.replace(servers, servers, "")
+}
+
+// ReplaceSuspicious publishes a server list marked as suspicious for the given
+// reason. The servers and the mark are published together, so readers never
+// see the list without its verdict.
+func (s *ServerStateStore) ReplaceSuspicious(servers []KamateraServer, reason string) ServerStateDiff {
+	return s.replace(servers, servers, reason)
+}
+
+// replace publishes servers, the filtered server list, together with the names
+// of all listed servers and the suspicious mark.
+func (s *ServerStateStore) replace(servers []KamateraServer, listed []KamateraServer, suspicious string) ServerStateDiff {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	next := map[string]KamateraServer{}
 	for _, server := range servers {
-		next[serverStateKey(server)] = server
+		next[serverStateKey(server)] = copyKamateraServer(server)
 	}
 
 	diff := ServerStateDiff{Initial: !s.initialized, Current: sortedServers(next)}
@@ -89,53 +159,269 @@ func (s *ServerStateStore) Replace(servers []KamateraServer) ServerStateDiff {
 		for key, server := range next {
 			previous, ok := s.servers[key]
 			if !ok {
-				diff.Added = append(diff.Added, server)
+				diff.Added = append(diff.Added, copyKamateraServer(server))
 				continue
 			}
 			if previous.Power != server.Power {
-				diff.PowerChanged = append(diff.PowerChanged, ServerPowerChange{Name: server.Name, Datacenter: server.Datacenter, OldPower: previous.Power, NewPower: server.Power})
+				diff.PowerChanged = append(diff.PowerChanged, ServerPowerChange{Name: server.Name, Datacenter: server.Datacenter, OldPower: previous.Power, NewPower: server.Power, Server: copyKamateraServer(server)})
 			}
 		}
 		for name, server := range s.servers {
 			if _, ok := next[name]; !ok {
-				diff.Removed = append(diff.Removed, server)
+				diff.Removed = append(diff.Removed, copyKamateraServer(server))
 			}
 		}
 	}
 
 	s.initialized = true
+	s.lastRefresh = s.now()
+	s.consecutiveFailures = 0
+	s.generation++
+	s.suspicious = suspicious
 	s.servers = next
+	s.listedNames = make(map[string]int, len(listed))
+	for _, server := range listed {
+		s.listedNames[server.Name]++
+	}
+	s.reindex()
 	sortServers(diff.Added)
 	sortServers(diff.Removed)
 	sort.Slice(diff.PowerChanged, func(i, j int) bool { return diff.PowerChanged[i].Name < diff.PowerChanged[j].Name })
 	return diff
 }
 
+func (s *ServerStateStore) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+// reindex rebuilds the indexes from s.servers. s.mu must be held for writing.
+func (s *ServerStateStore) reindex() {
+	s.sorted = make([]string, 0, len(s.servers))
+	s.byName = map[string][]string{}
+	s.byID = map[string]string{}
+	s.byIP = map[string][]string{}
+	for key := range s.servers {
+		s.sorted = append(s.sorted, key)
+	}
+	sort.Slice(s.sorted, func(i, j int) bool {
+		a, b := s.servers[s.sorted[i]], s.servers[s.sorted[j]]
+		if a.Name == b.Name {
+			return a.Datacenter < b.Datacenter
+		}
+		return a.Name < b.Name
+	})
+	for _, key := range s.sorted {
+		server := s.servers[key]
+		s.byName[server.Name] = append(s.byName[server.Name], key)
+		s.byID[server.Datacenter+"/"+server.ID] = key
+		for _, ip := range server.IPs() {
+			if keys := s.byIP[ip]; len(keys) == 0 || keys[len(keys)-1] != key {
+				s.byIP[ip] = append(keys, key)
+			}
+		}
+	}
+	s.nameIndexMu.Lock()
+	defer s.nameIndexMu.Unlock()
+	for _, index := range s.nameIndexes {
+		s.buildNameIndex(index)
+	}
+}
+
+func (s *ServerStateStore) buildNameIndex(index *transformedNameIndex) {
+	index.keys = map[string][]string{}
+	for _, key := range s.sorted {
+		if name, ok := index.transform.apply(s.servers[key].Name); ok {
+			index.keys[name] = append(index.keys[name], key)
+		}
+	}
+}
+
 func (s *ServerStateStore) Get(name string) (KamateraServer, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	var matched KamateraServer
-	matches := 0
-	for _, server := range s.servers {
-		if server.Name != name {
-			continue
+	keys := s.byName[name]
+	if len(keys) != 1 {
+		return KamateraServer{}, false
+	}
+	return copyKamateraServer(s.servers[keys[0]]), true
+}
+
+// Len returns the number of servers.
+func (s *ServerStateStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.servers)
+}
+
+// serversNamed returns the servers with the given name in all datacenters.
+func (s *ServerStateStore) serversNamed(name string) []KamateraServer {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.serversByKeys(s.byName[name])
+}
+
+// serversWithIPs returns the servers that have any of the IPs.
+func (s *ServerStateStore) serversWithIPs(ips []string) []KamateraServer {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(ips) == 1 {
+		return s.serversByKeys(s.byIP[ips[0]])
+	}
+	seen := map[string]struct{}{}
+	var keys []string
+	for _, ip := range ips {
+		for _, key := range s.byIP[ip] {
+			if _, ok := seen[key]; !ok {
+				seen[key] = struct{}{}
+				keys = append(keys, key)
+			}
+		}
+	}
+	return s.serversByKeys(keys)
+}
+
+// serversWithTransformedName returns the servers whose name transforms to
+// name.
+func (s *ServerStateStore) serversWithTransformedName(transform *nameTransform, name string) []KamateraServer {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.nameIndexMu.Lock()
+	defer s.nameIndexMu.Unlock()
+	index, ok := s.nameIndexes[transform.spec]
+	if !ok {
+		if s.nameIndexes == nil {
+			s.nameIndexes = map[string]*transformedNameIndex{}
 		}
-		matched = server
-		matches++
+		index = &transformedNameIndex{transform: transform}
+		s.buildNameIndex(index)
+		s.nameIndexes[transform.spec] = index
 	}
-	return matched, matches == 1
+	return s.serversByKeys(index.keys[name])
+}
+
+func (s *ServerStateStore) serversByKeys(keys []string) []KamateraServer {
+	servers := make([]KamateraServer, 0, len(keys))
+	for _, key := range keys {
+		servers = append(servers, copyKamateraServer(s.servers[key]))
+	}
+	return servers
+}
+
+// LastRefresh returns when the store was last replaced with a server list, or
+// the zero time if it never was.
+func (s *ServerStateStore) LastRefresh() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastRefresh
+}
+
+// RecordRefreshFailure counts a failed attempt to refresh the server list. The
+// previous servers are kept.
+func (s *ServerStateStore) RecordRefreshFailure() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.consecutiveFailures++
+}
+
+// ConsecutiveFailures returns the number of failed refreshes since the last
+// successful one.
+func (s *ServerStateStore) ConsecutiveFailures() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.consecutiveFailures
+}
+
+// Generation returns the number of times the store was replaced with a server
+// list, so callers can count polls.
+func (s *ServerStateStore) Generation() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.generation
+}
+
+// SetSuspicious marks the current server list as suspicious for the given
+// reason, or clears the mark when reason is empty, without replacing it. It is
+// used while a suspicious list is held back and the previous one is kept.
+func (s *ServerStateStore) SetSuspicious(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.suspicious = reason
+}
+
+// Suspicious returns why the current server list is suspicious, or an empty
+// string when it is not. Nodes must not be deleted for being absent from a
+// suspicious list.
+func (s *ServerStateStore) Suspicious() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.suspicious
+}
+
+// ServerNameUnique returns whether no other server of the Kamatera account,
+// including servers excluded by the server filter, has the name. The Kamatera
+// API addresses power operations by server name only.
+func (s *ServerStateStore) ServerNameUnique(name string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.listedNames[name] <= 1
+}
+
+// Initialized returns true once the store was replaced with a server list.
+func (s *ServerStateStore) Initialized() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.initialized
+}
+
+// CheckFresh returns an error when the store was never refreshed or was last
+// refreshed more than maxStaleness before now.
+func (s *ServerStateStore) CheckFresh(now time.Time, maxStaleness time.Duration) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.initialized {
+		return fmt.Errorf("kamatera server snapshot was never refreshed")
+	}
+	if age := now.Sub(s.lastRefresh); age > maxStaleness {
+		return fmt.Errorf("kamatera server snapshot is %s old, more than %s, after %d consecutive refresh failures", age.Round(time.Second), maxStaleness, s.consecutiveFailures)
+	}
+	return nil
+}
+
+// ReadyCheck returns a readiness check that fails while the store is stale. A
+// store that was never refreshed passes, since only the leader refreshes it.
+func (s *ServerStateStore) ReadyCheck(maxStaleness time.Duration) func(*http.Request) error {
+	return func(_ *http.Request) error {
+		if maxStaleness <= 0 || !s.Initialized() {
+			return nil
+		}
+		return s.CheckFresh(s.now(), maxStaleness)
+	}
+}
+
+// GetByID returns the server with the given ID in the given datacenter.
+func (s *ServerStateStore) GetByID(datacenter string, id string) (KamateraServer, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok := s.byID[datacenter+"/"+id]
+	if !ok {
+		return KamateraServer{}, false
+	}
+	return copyKamateraServer(s.servers[key]), true
 }
 
 func (s *ServerStateStore) List() []KamateraServer {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return sortedServers(s.servers)
+	return s.serversByKeys(s.sorted)
 }
 
 func sortedServers(servers map[string]KamateraServer) []KamateraServer {
 	items := make([]KamateraServer, 0, len(servers))
 	for _, server := range servers {
-		items = append(items, server)
+		items = append(items, copyKamateraServer(server))
 	}
 	sortServers(items)
 	return items
@@ -150,6 +436,19 @@ func sortServers(servers []KamateraServer) {
 	})
 }
 
+func copyKamateraServer(server KamateraServer) KamateraServer {
+	server.DiskSizesGB = append([]int(nil), server.DiskSizesGB...)
+	server.Tags = append([]string(nil), server.Tags...)
+	if server.Networks != nil {
+		networks := make([]KamateraServerNetwork, len(server.Networks))
+		for i, network := range server.Networks {
+			networks[i] = KamateraServerNetwork{Name: network.Name, IPs: append([]string(nil), network.IPs...)}
+		}
+		server.Networks = networks
+	}
+	return server
+}
+
 func serverStateKey(server KamateraServer) string {
 	return server.Datacenter + "/" + server.Name
 }
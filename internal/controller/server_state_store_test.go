@@ -1,6 +1,9 @@
 package controller
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestServerFilterMatchesDatacenterAndGlob(t *testing.T) {
 	filter, err := NewServerFilter("EU, US", "cwmc-*")
@@ -88,3 +91,107 @@ func TestServerStateStoreGetTreatsDuplicateServerNamesAsAmbiguous(t *testing.T)
 		t.Fatalf("expected duplicate server name to be ambiguous, got %+v", server)
 	}
 }
+
+func TestServerStateStoreReturnsCopiesOfServerSlices(t *testing.T) {
+	store := NewServerStateStore()
+	servers := []KamateraServer{{Name: "node-1", Datacenter: "EU", Power: "on", Networks: []KamateraServerNetwork{{Name: "wan-eu", IPs: []string{"1.2.3.4"}}}}}
+	store.Replace(servers)
+	servers[0].Networks[0].IPs[0] = "5.6.7.8"
+
+	server, ok := store.Get("node-1")
+	if !ok {
+		t.Fatalf("expected server in store")
+	}
+	if got := server.IPs(); len(got) != 1 || got[0] != "1.2.3.4" {
+		t.Fatalf("expected stored server to be isolated from caller slices, got %v", got)
+	}
+	server.Networks[0].IPs[0] = "9.9.9.9"
+	if again, _ := store.Get("node-1"); again.IPs()[0] != "1.2.3.4" {
+		t.Fatalf("expected returned server to be a copy, got %v", again.IPs())
+	}
+}
+
+func TestServerStateStoreTracksRefreshFailuresAndStaleness(t *testing.T) {
+	store := NewServerStateStore()
+	if err := store.CheckFresh(time.Now(), time.Minute); err == nil {
+		t.Fatalf("expected never refreshed store not to be fresh")
+	}
+	if err := store.ReadyCheck(time.Minute)(nil); err != nil {
+		t.Fatalf("expected ready check to pass before the first refresh, got %v", err)
+	}
+
+	store.Replace([]KamateraServer{{Name: "worker1", Datacenter: "EU", Power: "on"}})
+	store.RecordRefreshFailure()
+	store.RecordRefreshFailure()
+	if got := store.ConsecutiveFailures(); got != 2 {
+		t.Fatalf("expected 2 consecutive failures, got %d", got)
+	}
+	if err := store.CheckFresh(time.Now(), time.Minute); err != nil {
+		t.Fatalf("expected recently refreshed store to be fresh, got %v", err)
+	}
+	if err := store.CheckFresh(time.Now().Add(2*time.Minute), time.Minute); err == nil {
+		t.Fatalf("expected store to be stale after max staleness")
+	}
+	if _, ok := store.Get("worker1"); !ok {
+		t.Fatalf("expected servers to be kept after refresh failures")
+	}
+
+	store.Replace(nil)
+	if got := store.ConsecutiveFailures(); got != 0 {
+		t.Fatalf("expected successful refresh to reset failures, got %d", got)
+	}
+}
+
+func TestServerStateStorePublishesSuspiciousMarkWithServers(t *testing.T) {
+	store := NewServerStateStore()
+	store.ReplaceSuspicious(nil, "the filtered Kamatera server list is empty")
+	if store.Suspicious() == "" || store.Len() != 0 {
+		t.Fatalf("expected an empty suspicious list, got %d servers, suspicious %q", store.Len(), store.Suspicious())
+	}
+
+	store.Replace([]KamateraServer{{Name: "worker1", Datacenter: "EU"}})
+	if store.Suspicious() != "" || store.Len() != 1 {
+		t.Fatalf("expected a trusted list to clear the mark, got %d servers, suspicious %q", store.Len(), store.Suspicious())
+	}
+}
+
+func TestServerStateStoreIndexesAreRebuiltOnReplace(t *testing.T) {
+	transform, err := parseNameTransform("kamatera-%s")
+	if err != nil {
+		t.Fatalf("parse transform: %v", err)
+	}
+	store := NewServerStateStore()
+	store.Replace([]KamateraServer{
+		{ID: "id-1", Name: "worker1", Datacenter: "EU", Networks: []KamateraServerNetwork{{Name: "wan", IPs: []string{"203.0.113.1", "10.0.0.1"}}}},
+		{ID: "id-2", Name: "worker1", Datacenter: "US"},
+	})
+	if servers := store.serversNamed("worker1"); len(servers) != 2 || servers[0].Datacenter != "EU" || servers[1].Datacenter != "US" {
+		t.Fatalf("unexpected servers by name: %+v", servers)
+	}
+	if server, ok := store.GetByID("US", "id-2"); !ok || server.Name != "worker1" {
+		t.Fatalf("unexpected server by ID: %+v %v", server, ok)
+	}
+	if servers := store.serversWithIPs([]string{"10.0.0.1", "203.0.113.1"}); len(servers) != 1 || servers[0].ID != "id-1" {
+		t.Fatalf("unexpected servers by IP: %+v", servers)
+	}
+	if servers := store.serversWithTransformedName(transform, "kamatera-worker1"); len(servers) != 2 {
+		t.Fatalf("unexpected servers by transformed name: %+v", servers)
+	}
+
+	store.Replace([]KamateraServer{{ID: "id-3", Name: "worker2", Datacenter: "EU", Networks: []KamateraServerNetwork{{Name: "wan", IPs: []string{"10.0.0.1"}}}}})
+	if servers := store.serversNamed("worker1"); len(servers) != 0 {
+		t.Fatalf("expected removed servers to be unindexed, got %+v", servers)
+	}
+	if _, ok := store.GetByID("EU", "id-1"); ok {
+		t.Fatalf("expected removed server ID to be unindexed")
+	}
+	if servers := store.serversWithIPs([]string{"10.0.0.1"}); len(servers) != 1 || servers[0].ID != "id-3" {
+		t.Fatalf("unexpected servers by IP after replace: %+v", servers)
+	}
+	if servers := store.serversWithTransformedName(transform, "kamatera-worker2"); len(servers) != 1 || servers[0].ID != "id-3" {
+		t.Fatalf("expected transformed name index to be rebuilt, got %+v", servers)
+	}
+	if store.Len() != 1 {
+		t.Fatalf("expected 1 server, got %d", store.Len())
+	}
+}
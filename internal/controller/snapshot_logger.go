@@ -49,20 +49,23 @@ func (l *SnapshotLogger) logSnapshots() {
 	if l.ServerStore == nil || l.NodeStore == nil {
 		return
 	}
-	loggedServers := map[string]struct{}{}
+	pairs := l.Matcher.Pairs(l.NodeStore, l.ServerStore)
 	for _, node := range l.NodeStore.List() {
-		server, matched := l.Matcher.FindServerForNode(node.Name, l.ServerStore)
-		if matched {
-			loggedServers[serverStateKey(server)] = struct{}{}
-			l.Log.Info("snapshot node/server match", "nodeName", node.Name, "nodeReady", node.Ready, "serverName", server.Name, "serverPower", server.Power)
+		server, outcome := pairs.ServerForNode(node.Name)
+		if outcome == MatchAmbiguous {
+			l.Log.Info("snapshot node ambiguous match", "nodeName", node.Name, "nodeReady", node.Ready)
+			continue
+		}
+		if outcome == MatchUnique {
+			l.Log.Info("snapshot node/server match", "nodeName", node.Name, "nodeReady", node.Ready, "serverName", server.Name, "serverPower", server.Power, "serverID", server.ID, "serverDatacenter", server.Datacenter)
 			continue
 		}
 		l.Log.Info("snapshot node unmatched", "nodeName", node.Name, "nodeReady", node.Ready)
 	}
 	for _, server := range l.ServerStore.List() {
-		if _, ok := loggedServers[serverStateKey(server)]; ok {
+		if len(pairs.NodesForServer(server)) > 0 {
 			continue
 		}
-		l.Log.Info("snapshot server unmatched", "serverName", server.Name, "serverPower", server.Power)
+		l.Log.Info("snapshot server unmatched", "serverName", server.Name, "serverPower", server.Power, "serverID", server.ID, "serverDatacenter", server.Datacenter)
 	}
 }
@@ -58,7 +58,7 @@ func TestSnapshotLoggerLogsMatchedAndUnmatchedSnapshots(t *testing.T) {
 	}
 }
 
-func TestSnapshotLoggerLogsAmbiguousDuplicateServersAsUnmatched(t *testing.T) {
+func TestSnapshotLoggerLogsAmbiguousDuplicateServers(t *testing.T) {
 	serverStore := NewServerStateStore()
 	serverStore.Replace([]KamateraServer{
 		{Name: "node-1", Datacenter: "EU", Power: "on"},
@@ -72,10 +72,10 @@ func TestSnapshotLoggerLogsAmbiguousDuplicateServersAsUnmatched(t *testing.T) {
 	logger.logSnapshots()
 
 	if len(sink.messages) != 3 {
-		t.Fatalf("expected unmatched node plus both ambiguous servers, got %d lines: %v", len(sink.messages), sink.messages)
+		t.Fatalf("expected ambiguous node plus both ambiguous servers, got %d lines: %v", len(sink.messages), sink.messages)
 	}
-	if sink.messages[0] != "snapshot node unmatched" {
-		t.Fatalf("expected node to be unmatched, got %q", sink.messages[0])
+	if sink.messages[0] != "snapshot node ambiguous match" {
+		t.Fatalf("expected node to be ambiguous, got %q", sink.messages[0])
 	}
 	seenServers := map[string]bool{}
 	for _, values := range sink.values[1:] {
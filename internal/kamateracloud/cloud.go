@@ -0,0 +1,263 @@
+package kamateracloud
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+
+	nodecontroller "github.com/kamatera/kamatera-rke2-controller/internal/controller"
+)
+
+// ProviderName is the name of the cloud provider, passed to the
+// cloud-controller-manager with --cloud-provider.
+const ProviderName = "kamatera"
+
+const (
+	defaultServerListInterval         = time.Minute
+	defaultMaxServerSnapshotStaleness = 10 * time.Minute
+	defaultMaxServerListDropPercent   = 50
+	defaultExternalNetworks           = "wan*"
+	defaultInternalNetworks           = "*"
+)
+
+func init() {
+	cloudprovider.RegisterCloudProvider(ProviderName, func(config io.Reader) (cloudprovider.Interface, error) {
+		cfg, err := ReadConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		return NewCloud(cfg)
+	})
+}
+
+// Config is the cloud config file of the Kamatera cloud provider, passed to
+// the cloud-controller-manager with --cloud-config. The Kamatera API
+// credentials are read from the KAMATERA_API_CLIENT_ID and KAMATERA_API_SECRET
+// environment variables.
+type Config struct {
+	APIURL                      string                     `json:"apiUrl,omitempty"`
+	ServerDatacenters           string                     `json:"serverDatacenters,omitempty"`
+	ServerNameGlob              string                     `json:"serverNameGlob,omitempty"`
+	MatchNodeToServerTemplate   string                     `json:"matchNodeToServerTemplate,omitempty"`
+	MatchServerToNodeTemplate   string                     `json:"matchServerToNodeTemplate,omitempty"`
+	MatchStrategy               string                     `json:"matchStrategy,omitempty"`
+	MatchRules                  []nodecontroller.MatchRule `json:"matchRules,omitempty"`
+	ServerListInterval          metav1.Duration            `json:"serverListInterval,omitempty"`
+	MaxServerSnapshotStaleness  metav1.Duration            `json:"maxServerSnapshotStaleness,omitempty"`
+	MaxServerListDropPercent    *int                       `json:"maxServerListDropPercent,omitempty"`
+	ServerListDropConfirmations int                        `json:"serverListDropConfirmations,omitempty"`
+	ExternalNetworks            *string                    `json:"externalNetworks,omitempty"`
+	InternalNetworks            *string                    `json:"internalNetworks,omitempty"`
+	APIQPS                      *float64                   `json:"apiQPS,omitempty"`
+	APIBurst                    int                        `json:"apiBurst,omitempty"`
+}
+
+// ReadConfig parses a YAML or JSON cloud config. A nil reader returns the
+// default config.
+func ReadConfig(config io.Reader) (Config, error) {
+	var cfg Config
+	if config != nil {
+		data, err := io.ReadAll(config)
+		if err != nil {
+			return Config{}, fmt.Errorf("read cloud config: %w", err)
+		}
+		if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+			return Config{}, fmt.Errorf("parse cloud config: %w", err)
+		}
+	}
+	if cfg.APIURL == "" {
+		cfg.APIURL = os.Getenv("KAMATERA_API_URL")
+	}
+	if cfg.APIURL == "" {
+		cfg.APIURL = "https://cloudcli.cloudwm.com"
+	}
+	if cfg.ServerListInterval.Duration <= 0 {
+		cfg.ServerListInterval.Duration = defaultServerListInterval
+	}
+	if cfg.MaxServerSnapshotStaleness.Duration <= 0 {
+		cfg.MaxServerSnapshotStaleness.Duration = defaultMaxServerSnapshotStaleness
+	}
+	if cfg.MaxServerListDropPercent == nil {
+		dropPercent := defaultMaxServerListDropPercent
+		cfg.MaxServerListDropPercent = &dropPercent
+	}
+	if *cfg.MaxServerListDropPercent < 0 || *cfg.MaxServerListDropPercent > 100 {
+		return Config{}, fmt.Errorf("maxServerListDropPercent must be between 0 and 100")
+	}
+	if cfg.ServerListDropConfirmations < 0 {
+		return Config{}, fmt.Errorf("serverListDropConfirmations must not be negative")
+	}
+	if cfg.ExternalNetworks == nil {
+		externalNetworks := defaultExternalNetworks
+		cfg.ExternalNetworks = &externalNetworks
+	}
+	if cfg.InternalNetworks == nil {
+		internalNetworks := defaultInternalNetworks
+		cfg.InternalNetworks = &internalNetworks
+	}
+	if cfg.APIQPS == nil {
+		qps := float64(nodecontroller.DefaultKamateraAPIQPS)
+		cfg.APIQPS = &qps
+	}
+	if *cfg.APIQPS < 0 {
+		return Config{}, fmt.Errorf("apiQPS must not be negative")
+	}
+	if cfg.APIBurst == 0 {
+		cfg.APIBurst = nodecontroller.DefaultKamateraAPIBurst
+	}
+	if cfg.APIBurst < 0 {
+		return Config{}, fmt.Errorf("apiBurst must be greater than 0")
+	}
+	if cfg.MaxServerSnapshotStaleness.Duration < cfg.ServerListInterval.Duration {
+		return Config{}, fmt.Errorf("maxServerSnapshotStaleness must not be shorter than serverListInterval")
+	}
+	return cfg, nil
+}
+
+// Cloud implements cloudprovider.Interface with InstancesV2 only. Servers are
+// listed periodically into a server store, so node lifecycle checks do not
+// call the Kamatera API.
+type Cloud struct {
+	servers   *nodecontroller.ServerStateStore
+	refresher *nodecontroller.KamateraServersController
+	instances *Instances
+}
+
+func NewCloud(cfg Config) (*Cloud, error) {
+	filter, err := nodecontroller.NewServerFilter(cfg.ServerDatacenters, cfg.ServerNameGlob)
+	if err != nil {
+		return nil, fmt.Errorf("invalid serverNameGlob: %w", err)
+	}
+	matcher, err := newMatcher(cfg)
+	if err != nil {
+		return nil, err
+	}
+	matchStrategy, err := nodecontroller.ParseMatchStrategy(cfg.MatchStrategy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid matchStrategy: %w", err)
+	}
+	matcher = matcher.WithStrategy(matchStrategy)
+	externalNetworks, internalNetworks := defaultExternalNetworks, defaultInternalNetworks
+	if cfg.ExternalNetworks != nil {
+		externalNetworks = *cfg.ExternalNetworks
+	}
+	if cfg.InternalNetworks != nil {
+		internalNetworks = *cfg.InternalNetworks
+	}
+	addressRules, err := nodecontroller.NewNetworkAddressRules(externalNetworks, internalNetworks)
+	if err != nil {
+		return nil, fmt.Errorf("invalid externalNetworks or internalNetworks: %w", err)
+	}
+	dropPercent := defaultMaxServerListDropPercent
+	if cfg.MaxServerListDropPercent != nil {
+		dropPercent = *cfg.MaxServerListDropPercent
+	}
+	rateLimit := nodecontroller.DefaultKamateraAPIRateLimit()
+	if cfg.APIQPS != nil {
+		rateLimit.QPS = *cfg.APIQPS
+	}
+	if cfg.APIBurst > 0 {
+		rateLimit.Burst = cfg.APIBurst
+	}
+	clock := time.Now
+	servers := nodecontroller.NewServerStateStore()
+	servers.Now = clock
+	return &Cloud{
+		servers: servers,
+		refresher: &nodecontroller.KamateraServersController{
+			Client: nodecontroller.BuildKamateraAPIClient(
+				os.Getenv("KAMATERA_API_CLIENT_ID"),
+				os.Getenv("KAMATERA_API_SECRET"),
+				cfg.APIURL,
+				rateLimit,
+			),
+			Store:             servers,
+			Matcher:           matcher,
+			Filter:            filter,
+			Interval:          cfg.ServerListInterval.Duration,
+			MaxDropPercent:    dropPercent,
+			DropConfirmations: cfg.ServerListDropConfirmations,
+			Log:               klog.Background().WithName("KamateraServers"),
+		},
+		instances: &Instances{
+			Servers:      servers,
+			Matcher:      matcher,
+			AddressRules: addressRules,
+			MaxStaleness: cfg.MaxServerSnapshotStaleness.Duration,
+			Now:          clock,
+		},
+	}, nil
+}
+
+func newMatcher(cfg Config) (nodecontroller.NameMatcher, error) {
+	if len(cfg.MatchRules) == 0 {
+		matcher, err := nodecontroller.NewNameMatcher(cfg.MatchNodeToServerTemplate, cfg.MatchServerToNodeTemplate)
+		if err != nil {
+			return nodecontroller.NameMatcher{}, fmt.Errorf("invalid node/server matching configuration: %w", err)
+		}
+		return matcher, nil
+	}
+	if cfg.MatchNodeToServerTemplate != "" || cfg.MatchServerToNodeTemplate != "" {
+		return nodecontroller.NameMatcher{}, fmt.Errorf("matchRules is mutually exclusive with matchNodeToServerTemplate and matchServerToNodeTemplate")
+	}
+	matcher, err := nodecontroller.NewNameMatcherFromRules(cfg.MatchRules)
+	if err != nil {
+		return nodecontroller.NameMatcher{}, fmt.Errorf("invalid matchRules: %w", err)
+	}
+	return matcher, nil
+}
+
+// Initialize starts listing Kamatera servers until stop is closed.
+func (c *Cloud) Initialize(_ cloudprovider.ControllerClientBuilder, stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+	go func() {
+		_ = c.refresher.Start(ctx)
+	}()
+}
+
+func (c *Cloud) LoadBalancer() (cloudprovider.LoadBalancer, bool) {
+	return nil, false
+}
+
+func (c *Cloud) Instances() (cloudprovider.Instances, bool) {
+	return nil, false
+}
+
+func (c *Cloud) InstancesV2() (cloudprovider.InstancesV2, bool) {
+	return c.instances, true
+}
+
+func (c *Cloud) Zones() (cloudprovider.Zones, bool) {
+	return nil, false
+}
+
+func (c *Cloud) Clusters() (cloudprovider.Clusters, bool) {
+	return nil, false
+}
+
+func (c *Cloud) Routes() (cloudprovider.Routes, bool) {
+	return nil, false
+}
+
+func (c *Cloud) ProviderName() string {
+	return ProviderName
+}
+
+// HasClusterID returns false: Kamatera servers are not tagged with a cluster
+// ID, the server filter (serverDatacenters, serverNameGlob) scopes the servers
+// to the cluster instead. The cloud-controller-manager must run with
+// --allow-untagged-cloud.
+func (c *Cloud) HasClusterID() bool {
+	return false
+}
@@ -0,0 +1,99 @@
+package kamateracloud
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	cloudprovider "k8s.io/cloud-provider"
+
+	nodecontroller "github.com/kamatera/kamatera-rke2-controller/internal/controller"
+)
+
+// Instances implements cloudprovider.InstancesV2 from the server store. A Node
+// is looked up by its Kamatera provider ID when it has one, and otherwise by
+// name with the Matcher.
+//
+// Lookups fail while the server store is stale, for Nodes matching several
+// servers, and, for Nodes without a matching server, while the server list is
+// suspicious, so the node lifecycle controller never deletes Nodes based on
+// missing, ambiguous or outdated data.
+type Instances struct {
+	Servers      *nodecontroller.ServerStateStore
+	Matcher      nodecontroller.NameMatcher
+	AddressRules nodecontroller.NetworkAddressRules
+	MaxStaleness time.Duration
+
+	Now func() time.Time
+}
+
+var _ cloudprovider.InstancesV2 = &Instances{}
+
+// InstanceExists returns true when a server matches the node.
+func (i *Instances) InstanceExists(ctx context.Context, node *corev1.Node) (bool, error) {
+	_, ok, err := i.findServer(node)
+	if err != nil {
+		return false, err
+	}
+	return ok, nil
+}
+
+// InstanceShutdown returns true when the matching server is powered off.
+func (i *Instances) InstanceShutdown(ctx context.Context, node *corev1.Node) (bool, error) {
+	server, ok, err := i.findServer(node)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, cloudprovider.InstanceNotFound
+	}
+	return server.Power == "off", nil
+}
+
+// InstanceMetadata returns the provider ID, instance type, addresses and
+// datacenter of the matching server. The datacenter is used as both region
+// and zone.
+func (i *Instances) InstanceMetadata(ctx context.Context, node *corev1.Node) (*cloudprovider.InstanceMetadata, error) {
+	server, ok, err := i.findServer(node)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, cloudprovider.InstanceNotFound
+	}
+	addresses := i.AddressRules.NodeAddresses(server)
+	addresses = append(addresses, corev1.NodeAddress{Type: corev1.NodeHostName, Address: node.Name})
+	return &cloudprovider.InstanceMetadata{
+		ProviderID:    nodecontroller.KamateraProviderID(server),
+		InstanceType:  nodecontroller.KamateraInstanceType(server),
+		NodeAddresses: addresses,
+		Zone:          server.Datacenter,
+		Region:        server.Datacenter,
+	}, nil
+}
+
+func (i *Instances) findServer(node *corev1.Node) (nodecontroller.KamateraServer, bool, error) {
+	now := time.Now()
+	if i.Now != nil {
+		now = i.Now()
+	}
+	maxStaleness := i.MaxStaleness
+	if maxStaleness <= 0 {
+		maxStaleness = defaultMaxServerSnapshotStaleness
+	}
+	if err := i.Servers.CheckFresh(now, maxStaleness); err != nil {
+		return nodecontroller.KamateraServer{}, false, err
+	}
+	server, outcome := i.Matcher.WithPreferProviderID(true).MatchServerForNode(nodecontroller.NewNodeSnapshot(node, nil, nil), i.Servers)
+	switch outcome {
+	case nodecontroller.MatchUnique:
+		return server, true, nil
+	case nodecontroller.MatchAmbiguous:
+		return nodecontroller.KamateraServer{}, false, fmt.Errorf("node %s matches several Kamatera servers", node.Name)
+	}
+	if reason := i.Servers.Suspicious(); reason != "" {
+		return nodecontroller.KamateraServer{}, false, fmt.Errorf("no Kamatera server found for node %s but %s", node.Name, reason)
+	}
+	return nodecontroller.KamateraServer{}, false, nil
+}
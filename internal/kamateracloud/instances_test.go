@@ -0,0 +1,151 @@
+package kamateracloud
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	cloudprovider "k8s.io/cloud-provider"
+
+	nodecontroller "github.com/kamatera/kamatera-rke2-controller/internal/controller"
+)
+
+func newTestInstances(servers ...nodecontroller.KamateraServer) *Instances {
+	store := nodecontroller.NewServerStateStore()
+	store.Replace(servers)
+	return &Instances{Servers: store, AddressRules: nodecontroller.DefaultNetworkAddressRules(), MaxStaleness: 10 * time.Minute}
+}
+
+func TestInstancesMatchesNodeByName(t *testing.T) {
+	instances := newTestInstances(
+		nodecontroller.KamateraServer{ID: "id-1", Name: "worker1", Datacenter: "EU", Power: "on", CPU: "2B", RAMMB: 4096, Networks: []nodecontroller.KamateraServerNetwork{
+			{Name: "wan-eu", IPs: []string{"203.0.113.10"}},
+			{Name: "lan-12345-private", IPs: []string{"172.16.0.10"}},
+		}},
+		nodecontroller.KamateraServer{ID: "id-2", Name: "worker2", Datacenter: "EU", Power: "off"},
+	)
+	worker1 := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker1"}}
+	worker2 := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker2"}}
+	missing := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "missing"}}
+
+	if exists, err := instances.InstanceExists(context.Background(), worker1); err != nil || !exists {
+		t.Fatalf("expected worker1 to exist, got %v err=%v", exists, err)
+	}
+	if exists, err := instances.InstanceExists(context.Background(), missing); err != nil || exists {
+		t.Fatalf("expected missing node not to exist, got %v err=%v", exists, err)
+	}
+	if shutdown, err := instances.InstanceShutdown(context.Background(), worker1); err != nil || shutdown {
+		t.Fatalf("expected worker1 not to be shut down, got %v err=%v", shutdown, err)
+	}
+	if shutdown, err := instances.InstanceShutdown(context.Background(), worker2); err != nil || !shutdown {
+		t.Fatalf("expected worker2 to be shut down, got %v err=%v", shutdown, err)
+	}
+	if _, err := instances.InstanceShutdown(context.Background(), missing); !errors.Is(err, cloudprovider.InstanceNotFound) {
+		t.Fatalf("expected InstanceNotFound for missing node, got %v", err)
+	}
+
+	metadata, err := instances.InstanceMetadata(context.Background(), worker1)
+	if err != nil {
+		t.Fatalf("metadata: %v", err)
+	}
+	expected := &cloudprovider.InstanceMetadata{
+		ProviderID:   "kamatera://EU/id-1",
+		InstanceType: "2B-4096MB",
+		NodeAddresses: []corev1.NodeAddress{
+			{Type: corev1.NodeInternalIP, Address: "172.16.0.10"},
+			{Type: corev1.NodeExternalIP, Address: "203.0.113.10"},
+			{Type: corev1.NodeHostName, Address: "worker1"},
+		},
+		Zone:   "EU",
+		Region: "EU",
+	}
+	if !reflect.DeepEqual(metadata, expected) {
+		t.Fatalf("expected metadata %+v, got %+v", expected, metadata)
+	}
+}
+
+func TestInstancesPrefersProviderID(t *testing.T) {
+	instances := newTestInstances(
+		nodecontroller.KamateraServer{ID: "id-1", Name: "worker1", Datacenter: "EU", Power: "on"},
+		nodecontroller.KamateraServer{ID: "id-2", Name: "worker1", Datacenter: "US", Power: "off"},
+		nodecontroller.KamateraServer{ID: "id-3", Name: "worker3", Datacenter: "EU", Power: "on"},
+	)
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker1"}, Spec: corev1.NodeSpec{ProviderID: "kamatera://US/id-2"}}
+
+	shutdown, err := instances.InstanceShutdown(context.Background(), node)
+	if err != nil || !shutdown {
+		t.Fatalf("expected the US server to be found by provider ID, got %v err=%v", shutdown, err)
+	}
+
+	node.Spec.ProviderID = "kamatera://US/id-3"
+	if exists, err := instances.InstanceExists(context.Background(), node); err != nil || exists {
+		t.Fatalf("expected unknown provider ID not to exist, got %v err=%v", exists, err)
+	}
+	node.Spec.ProviderID = "rke2://worker3"
+	node.Name = "worker3"
+	if exists, err := instances.InstanceExists(context.Background(), node); err != nil || !exists {
+		t.Fatalf("expected node with a foreign provider ID to be matched by name, got %v err=%v", exists, err)
+	}
+}
+
+func TestInstancesFailsOnAmbiguousMatch(t *testing.T) {
+	instances := newTestInstances(
+		nodecontroller.KamateraServer{ID: "id-1", Name: "worker1", Datacenter: "EU", Power: "off"},
+		nodecontroller.KamateraServer{ID: "id-2", Name: "worker1", Datacenter: "US", Power: "on"},
+	)
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker1"}}
+	if exists, err := instances.InstanceExists(context.Background(), node); err == nil {
+		t.Fatalf("expected an error for a node matching two servers, got exists=%v", exists)
+	}
+}
+
+func TestInstancesFailsOnStaleOrSuspiciousServerList(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker1"}}
+
+	never := &Instances{Servers: nodecontroller.NewServerStateStore()}
+	if _, err := never.InstanceExists(context.Background(), node); err == nil {
+		t.Fatalf("expected an error before the first server list")
+	}
+
+	stale := newTestInstances(nodecontroller.KamateraServer{ID: "id-1", Name: "worker1", Datacenter: "EU", Power: "on"})
+	stale.Now = func() time.Time { return time.Now().Add(time.Hour) }
+	if _, err := stale.InstanceExists(context.Background(), node); err == nil {
+		t.Fatalf("expected an error with a stale server list")
+	}
+
+	suspicious := newTestInstances()
+	suspicious.Servers.ReplaceSuspicious(nil, "the filtered Kamatera server list is empty")
+	if _, err := suspicious.InstanceExists(context.Background(), node); err == nil {
+		t.Fatalf("expected an error for a missing server in a suspicious server list")
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	cfg, err := ReadConfig(strings.NewReader("serverDatacenters: EU\nmatchNodeToServerTemplate: kamatera-%s\nserverListInterval: 30s\n"))
+	if err != nil {
+		t.Fatalf("read config: %v", err)
+	}
+	if cfg.ServerDatacenters != "EU" || cfg.MatchNodeToServerTemplate != "kamatera-%s" || cfg.ServerListInterval.Duration != 30*time.Second || cfg.MaxServerSnapshotStaleness.Duration != defaultMaxServerSnapshotStaleness {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+	if _, err := NewCloud(cfg); err != nil {
+		t.Fatalf("new cloud: %v", err)
+	}
+	if _, err := ReadConfig(strings.NewReader("unknownField: true\n")); err == nil {
+		t.Fatalf("expected unknown fields to be rejected")
+	}
+	if *cfg.APIQPS != nodecontroller.DefaultKamateraAPIQPS || cfg.APIBurst != nodecontroller.DefaultKamateraAPIBurst {
+		t.Fatalf("expected the default API rate limit, got qps=%v burst=%d", *cfg.APIQPS, cfg.APIBurst)
+	}
+	if cfg, err := ReadConfig(strings.NewReader("apiQPS: 0\napiBurst: 3\n")); err != nil || *cfg.APIQPS != 0 || cfg.APIBurst != 3 {
+		t.Fatalf("expected apiQPS 0 to be kept, got %+v err=%v", cfg, err)
+	}
+	if _, err := ReadConfig(strings.NewReader("apiQPS: -1\n")); err == nil {
+		t.Fatalf("expected a negative apiQPS to be rejected")
+	}
+}
@@ -0,0 +1,550 @@
+// Package kamaterafake is an in-process fake of the parts of the Kamatera API
+// used by the controller and the cloud-controller-manager, for tests and local
+// development clusters.
+//
+// API is an http.Handler, so it can be served with httptest:
+//
+//	api := kamaterafake.New(kamaterafake.Server{Name: "worker1", Datacenter: "EU", Power: "on"})
+//	server := httptest.NewServer(api)
+//	defer server.Close()
+//
+// Failures and latency are scripted with Faults. Power and terminate
+// operations are queued as commands that complete after the command duration,
+// only then changing the server.
+package kamaterafake
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NoServersFoundMessage is the error message of the Kamatera API for server
+// lookups and lists without any server.
+const NoServersFoundMessage = "No servers found"
+
+// maxRecordedRequests bounds the requests kept for Requests, so a standalone
+// fake polled for days does not grow without limit.
+const maxRecordedRequests = 1000
+
+// Server is a Kamatera server, in the wire format of the server info endpoint.
+// The servers list only returns the ID, name, datacenter and power, see
+// listedServer.
+type Server struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Datacenter string    `json:"datacenter"`
+	Power      string    `json:"power"`
+	CPU        string    `json:"cpu,omitempty"`
+	RAMMB      int       `json:"ram,omitempty"`
+	DiskSizes  []int     `json:"diskSizes,omitempty"`
+	Networks   []Network `json:"networks,omitempty"`
+	Billing    string    `json:"billing,omitempty"`
+	Tags       []string  `json:"tags,omitempty"`
+	Created    string    `json:"created,omitempty"`
+}
+
+// listedServer is a server in the wire format of the servers list endpoint.
+type listedServer struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Datacenter string `json:"datacenter"`
+	Power      string `json:"power"`
+}
+
+type Network struct {
+	Network string   `json:"network"`
+	IPs     []string `json:"ips"`
+}
+
+// Fault makes matching requests fail or respond slowly. Empty Method and Path
+// match all requests.
+type Fault struct {
+	Method string `json:"method,omitempty"`
+	Path   string `json:"path,omitempty"`
+	// Times is the number of matching requests the fault applies to, 0 for all
+	// requests until the faults are cleared.
+	Times int `json:"times,omitempty"`
+	// Latency delays the response.
+	Latency metav1.Duration `json:"latency,omitempty"`
+	// StatusCode and Message make the request fail with the message as JSON
+	// error. StatusCode defaults to 500, Message to the status text.
+	StatusCode int    `json:"statusCode,omitempty"`
+	Message    string `json:"message,omitempty"`
+	// CommandError accepts power and terminate requests but fails the queued
+	// command with this log.
+	CommandError string `json:"commandError,omitempty"`
+}
+
+// Request is a request received by the fake API.
+type Request struct {
+	Method string
+	Path   string
+	Body   string
+}
+
+type command struct {
+	id          string
+	server      Server
+	action      string
+	completesAt time.Time
+	status      string
+	log         string
+}
+
+// API is the fake Kamatera API. It is safe for concurrent use.
+type API struct {
+	mu              sync.Mutex
+	servers         []Server
+	commands        []*command
+	nextCommandID   int
+	faults          []*Fault
+	latency         time.Duration
+	commandDuration time.Duration
+	clientID        string
+	secret          string
+	requests        []Request
+	now             func() time.Time
+}
+
+// New returns a fake API with the given servers. Commands complete
+// immediately until SetCommandDuration is called.
+func New(servers ...Server) *API {
+	api := &API{nextCommandID: 1000, now: time.Now}
+	api.SetServers(servers)
+	return api
+}
+
+// SetServers replaces all servers.
+func (a *API) SetServers(servers []Server) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.servers = nil
+	for _, server := range servers {
+		a.servers = append(a.servers, copyServer(server))
+	}
+}
+
+// AddServer adds a server.
+func (a *API) AddServer(server Server) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.servers = append(a.servers, copyServer(server))
+}
+
+// RemoveServer removes the servers with the given name, e.g. to simulate a
+// server terminated outside the cluster.
+func (a *API) RemoveServer(name string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.removeServer(name)
+}
+
+// SetPower changes the power state of the servers with the given name
+// directly, e.g. to simulate a server that crashed.
+func (a *API) SetPower(name string, power string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i := range a.servers {
+		if a.servers[i].Name == name {
+			a.servers[i].Power = power
+		}
+	}
+}
+
+// Servers returns the current servers, after completing due commands.
+func (a *API) Servers() []Server {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.completeCommands()
+	servers := make([]Server, 0, len(a.servers))
+	for _, server := range a.servers {
+		servers = append(servers, copyServer(server))
+	}
+	return servers
+}
+
+// SetLatency delays all responses.
+func (a *API) SetLatency(latency time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.latency = latency
+}
+
+// SetCommandDuration sets how long queued power and terminate commands run
+// before they complete and change the server.
+func (a *API) SetCommandDuration(duration time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.commandDuration = duration
+}
+
+// SetCredentials makes requests without these AuthClientId and AuthSecret
+// headers fail. By default all credentials are accepted.
+func (a *API) SetCredentials(clientID string, secret string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.clientID = clientID
+	a.secret = secret
+}
+
+// AddFault adds a fault. Faults are applied in the order they were added,
+// the first matching one wins.
+func (a *API) AddFault(fault Fault) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.faults = append(a.faults, &fault)
+}
+
+// ClearFaults removes all faults.
+func (a *API) ClearFaults() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.faults = nil
+}
+
+// Requests returns the most recent API requests, without the admin requests.
+func (a *API) Requests() []Request {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]Request(nil), a.requests...)
+}
+
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/fake/") {
+		a.serveAdmin(w, r)
+		return
+	}
+	body, err := readBody(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	a.mu.Lock()
+	a.requests = append(a.requests, Request{Method: r.Method, Path: r.URL.Path, Body: strings.TrimSpace(string(body))})
+	if len(a.requests) > maxRecordedRequests {
+		a.requests = append([]Request(nil), a.requests[len(a.requests)-maxRecordedRequests:]...)
+	}
+	fault := a.takeFault(r.Method, r.URL.Path)
+	latency := a.latency
+	authorized := (a.clientID == "" || r.Header.Get("AuthClientId") == a.clientID) && (a.secret == "" || r.Header.Get("AuthSecret") == a.secret)
+	a.mu.Unlock()
+
+	if fault != nil {
+		latency += fault.Latency.Duration
+	}
+	if latency > 0 {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(latency):
+		}
+	}
+	if !authorized {
+		writeError(w, http.StatusUnauthorized, "Authentication failed")
+		return
+	}
+	if fault != nil && (fault.Message != "" || fault.StatusCode != 0) {
+		statusCode, message := fault.StatusCode, fault.Message
+		if statusCode == 0 {
+			statusCode = http.StatusInternalServerError
+		}
+		if message == "" {
+			message = http.StatusText(statusCode)
+		}
+		writeError(w, statusCode, message)
+		return
+	}
+	commandError := ""
+	if fault != nil {
+		commandError = fault.CommandError
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.completeCommands()
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/service/servers":
+		a.listServers(w)
+	case r.Method == http.MethodPost && r.URL.Path == "/service/server/info":
+		a.serverInfo(w, body)
+	case r.Method == http.MethodPost && r.URL.Path == "/service/server/power":
+		a.power(w, body, commandError)
+	case r.Method == http.MethodDelete && r.URL.Path == "/service/server/terminate":
+		a.terminate(w, body, commandError)
+	case r.Method == http.MethodPost && r.URL.Path == "/service/queue":
+		a.queue(w, body)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("%s %s is not supported by the fake Kamatera API", r.Method, r.URL.Path))
+	}
+}
+
+// takeFault returns the first fault matching the request and uses it up.
+// a.mu must be held.
+func (a *API) takeFault(method string, path string) *Fault {
+	for i, fault := range a.faults {
+		if fault.Method != "" && !strings.EqualFold(fault.Method, method) {
+			continue
+		}
+		if fault.Path != "" && "/"+strings.TrimPrefix(fault.Path, "/") != path {
+			continue
+		}
+		taken := *fault
+		if fault.Times > 0 {
+			fault.Times--
+			if fault.Times == 0 {
+				a.faults = append(a.faults[:i:i], a.faults[i+1:]...)
+			}
+		}
+		return &taken
+	}
+	return nil
+}
+
+func (a *API) listServers(w http.ResponseWriter) {
+	if len(a.servers) == 0 {
+		writeError(w, http.StatusInternalServerError, NoServersFoundMessage)
+		return
+	}
+	servers := make([]listedServer, 0, len(a.servers))
+	for _, server := range a.servers {
+		servers = append(servers, listedServer{ID: server.ID, Name: server.Name, Datacenter: server.Datacenter, Power: server.Power})
+	}
+	writeJSON(w, http.StatusOK, servers)
+}
+
+type nameRequest struct {
+	Name  string `json:"name"`
+	Power string `json:"power"`
+	Force bool   `json:"force"`
+}
+
+// serverInfo returns the servers whose name matches the requested name
+// regular expression, like the Kamatera API. Names that are not valid regular
+// expressions match exactly.
+func (a *API) serverInfo(w http.ResponseWriter, body []byte) {
+	var req nameRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	pattern, err := regexp.Compile(req.Name)
+	var servers []Server
+	for _, server := range a.servers {
+		if server.Name == req.Name || (err == nil && pattern.MatchString(server.Name)) {
+			servers = append(servers, server)
+		}
+	}
+	if len(servers) == 0 {
+		writeError(w, http.StatusInternalServerError, NoServersFoundMessage)
+		return
+	}
+	writeJSON(w, http.StatusOK, servers)
+}
+
+func (a *API) power(w http.ResponseWriter, body []byte, commandError string) {
+	var req nameRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Power != "on" && req.Power != "off" && req.Power != "restart" {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid power operation %q", req.Power))
+		return
+	}
+	a.queueCommand(w, req.Name, req.Power, commandError)
+}
+
+func (a *API) terminate(w http.ResponseWriter, body []byte, commandError string) {
+	var req nameRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	a.queueCommand(w, req.Name, "terminate", commandError)
+}
+
+func (a *API) queueCommand(w http.ResponseWriter, name string, action string, commandError string) {
+	var matched []Server
+	for _, server := range a.servers {
+		if server.Name == name {
+			matched = append(matched, server)
+		}
+	}
+	switch len(matched) {
+	case 0:
+		writeError(w, http.StatusInternalServerError, NoServersFoundMessage)
+		return
+	case 1:
+	default:
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Multiple servers found with name %s", name))
+		return
+	}
+	a.nextCommandID++
+	cmd := &command{
+		id:          strconv.Itoa(a.nextCommandID),
+		server:      matched[0],
+		action:      action,
+		completesAt: a.now().Add(a.commandDuration),
+		status:      "pending",
+		log:         commandError,
+	}
+	a.commands = append(a.commands, cmd)
+	a.completeCommands()
+	writeJSON(w, http.StatusOK, []int{a.nextCommandID})
+}
+
+type commandStatus struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Log    string `json:"log,omitempty"`
+}
+
+func (a *API) queue(w http.ResponseWriter, body []byte) {
+	var req struct {
+		ID interface{} `json:"id"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id := fmt.Sprint(req.ID)
+	for _, cmd := range a.commands {
+		if cmd.id == id {
+			writeJSON(w, http.StatusOK, []commandStatus{{ID: cmd.id, Status: cmd.status, Log: cmd.log}})
+			return
+		}
+	}
+	writeError(w, http.StatusInternalServerError, fmt.Sprintf("Command %s not found", id))
+}
+
+// completeCommands completes the commands that are due, in order, and applies
+// them to their servers. Running commands are reported as running. a.mu must
+// be held.
+func (a *API) completeCommands() {
+	now := a.now()
+	for _, cmd := range a.commands {
+		if cmd.status != "pending" && cmd.status != "running" {
+			continue
+		}
+		if now.Before(cmd.completesAt) {
+			cmd.status = "running"
+			continue
+		}
+		if cmd.log != "" {
+			cmd.status = "error"
+			continue
+		}
+		cmd.status = "complete"
+		switch cmd.action {
+		case "terminate":
+			a.removeServer(cmd.server.Name)
+		case "restart":
+			a.setServerPower(cmd.server, "on")
+		default:
+			a.setServerPower(cmd.server, cmd.action)
+		}
+	}
+}
+
+func (a *API) setServerPower(target Server, power string) {
+	for i := range a.servers {
+		if a.servers[i].Name == target.Name && a.servers[i].Datacenter == target.Datacenter {
+			a.servers[i].Power = power
+		}
+	}
+}
+
+func (a *API) removeServer(name string) {
+	servers := a.servers[:0]
+	for _, server := range a.servers {
+		if server.Name != name {
+			servers = append(servers, server)
+		}
+	}
+	a.servers = servers
+}
+
+// serveAdmin serves the endpoints used to script a standalone fake API:
+// GET and PUT /fake/servers, and POST and DELETE /fake/faults.
+func (a *API) serveAdmin(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/fake/servers" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, a.Servers())
+	case r.URL.Path == "/fake/servers" && r.Method == http.MethodPut:
+		var servers []Server
+		if err := decodeStrict(r, &servers); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		a.SetServers(servers)
+		w.WriteHeader(http.StatusNoContent)
+	case r.URL.Path == "/fake/faults" && r.Method == http.MethodPost:
+		var fault Fault
+		if err := decodeStrict(r, &fault); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		a.AddFault(fault)
+		w.WriteHeader(http.StatusNoContent)
+	case r.URL.Path == "/fake/faults" && r.Method == http.MethodDelete:
+		a.ClearFaults()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("%s %s is not supported by the fake Kamatera API", r.Method, r.URL.Path))
+	}
+}
+
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+	defer r.Body.Close()
+	var body json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("invalid request body: %w", err)
+	}
+	return body, nil
+}
+
+func decodeStrict(r *http.Request, out interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(out)
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, statusCode int, message string) {
+	writeJSON(w, statusCode, map[string]string{"message": message})
+}
+
+func copyServer(server Server) Server {
+	server.DiskSizes = append([]int(nil), server.DiskSizes...)
+	server.Tags = append([]string(nil), server.Tags...)
+	if server.Networks != nil {
+		networks := make([]Network, len(server.Networks))
+		for i, network := range server.Networks {
+			networks[i] = Network{Network: network.Network, IPs: append([]string(nil), network.IPs...)}
+		}
+		server.Networks = networks
+	}
+	return server
+}
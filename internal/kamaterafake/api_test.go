@@ -0,0 +1,157 @@
+package kamaterafake
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func doRequest(t *testing.T, url string, method string, path string, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequestWithContext(context.Background(), method, url+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer res.Body.Close()
+	var decoded interface{}
+	if err := json.NewDecoder(res.Body).Decode(&decoded); err != nil {
+		return res.StatusCode, ""
+	}
+	encoded, _ := json.Marshal(decoded)
+	return res.StatusCode, string(encoded)
+}
+
+func TestAPIListsServersAndServerInfo(t *testing.T) {
+	api := New(Server{ID: "1", Name: "worker1", Datacenter: "EU", Power: "on", Networks: []Network{{Network: "wan-eu", IPs: []string{"203.0.113.1"}}}})
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	status, body := doRequest(t, server.URL, http.MethodGet, "/service/servers", "")
+	if status != http.StatusOK || body != `[{"datacenter":"EU","id":"1","name":"worker1","power":"on"}]` {
+		t.Fatalf("unexpected servers response %d %s", status, body)
+	}
+	status, body = doRequest(t, server.URL, http.MethodPost, "/service/server/info", `{"name":"^(?:worker0|worker1)$"}`)
+	if status != http.StatusOK || body != `[{"datacenter":"EU","id":"1","name":"worker1","networks":[{"ips":["203.0.113.1"],"network":"wan-eu"}],"power":"on"}]` {
+		t.Fatalf("unexpected server info response %d %s", status, body)
+	}
+	status, body = doRequest(t, server.URL, http.MethodPost, "/service/server/info", `{"name":"missing"}`)
+	if status != http.StatusInternalServerError || body != `{"message":"No servers found"}` {
+		t.Fatalf("unexpected server info response %d %s", status, body)
+	}
+
+	api.SetServers(nil)
+	if status, body := doRequest(t, server.URL, http.MethodGet, "/service/servers", ""); status != http.StatusInternalServerError || body != `{"message":"No servers found"}` {
+		t.Fatalf("expected No servers found for an empty list, got %d %s", status, body)
+	}
+}
+
+func TestAPIPowerCommandsChangeServersWhenComplete(t *testing.T) {
+	api := New(Server{ID: "1", Name: "worker1", Datacenter: "EU", Power: "on"})
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	api.now = func() time.Time { return now }
+	api.SetCommandDuration(time.Minute)
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	status, body := doRequest(t, server.URL, http.MethodPost, "/service/server/power", `{"name":"worker1","power":"off"}`)
+	if status != http.StatusOK || body != `[1001]` {
+		t.Fatalf("unexpected power response %d %s", status, body)
+	}
+	if _, body := doRequest(t, server.URL, http.MethodPost, "/service/queue", `{"id":"1001"}`); body != `[{"id":"1001","status":"running"}]` {
+		t.Fatalf("expected a running command, got %s", body)
+	}
+	if servers := api.Servers(); servers[0].Power != "on" {
+		t.Fatalf("expected power to change only when the command completes, got %+v", servers)
+	}
+
+	now = now.Add(time.Minute)
+	if _, body := doRequest(t, server.URL, http.MethodPost, "/service/queue", `{"id":1001}`); body != `[{"id":"1001","status":"complete"}]` {
+		t.Fatalf("expected a complete command, got %s", body)
+	}
+	if servers := api.Servers(); servers[0].Power != "off" {
+		t.Fatalf("expected the server to be powered off, got %+v", servers)
+	}
+
+	doRequest(t, server.URL, http.MethodDelete, "/service/server/terminate", `{"name":"worker1","force":true}`)
+	now = now.Add(time.Minute)
+	if servers := api.Servers(); len(servers) != 0 {
+		t.Fatalf("expected the server to be terminated, got %+v", servers)
+	}
+}
+
+func TestAPIFaults(t *testing.T) {
+	api := New(Server{ID: "1", Name: "worker1", Datacenter: "EU", Power: "on"})
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	api.AddFault(Fault{Path: "service/servers", Times: 2, Message: NoServersFoundMessage})
+	for i := 0; i < 2; i++ {
+		if status, body := doRequest(t, server.URL, http.MethodGet, "/service/servers", ""); status != http.StatusInternalServerError || body != `{"message":"No servers found"}` {
+			t.Fatalf("expected fault response, got %d %s", status, body)
+		}
+	}
+	if status, _ := doRequest(t, server.URL, http.MethodGet, "/service/servers", ""); status != http.StatusOK {
+		t.Fatalf("expected the fault to be used up, got %d", status)
+	}
+
+	api.AddFault(Fault{Method: http.MethodPost, Path: "/service/server/power", CommandError: "server is locked"})
+	_, body := doRequest(t, server.URL, http.MethodPost, "/service/server/power", `{"name":"worker1","power":"restart"}`)
+	if _, body := doRequest(t, server.URL, http.MethodPost, "/service/queue", fmt.Sprintf(`{"id":%s}`, strings.Trim(body, "[]"))); body != `[{"id":"1001","log":"server is locked","status":"error"}]` {
+		t.Fatalf("expected a failed command, got %s", body)
+	}
+
+	api.ClearFaults()
+	api.AddFault(Fault{StatusCode: http.StatusBadGateway, Latency: metav1.Duration{Duration: 20 * time.Millisecond}})
+	startedAt := time.Now()
+	if status, _ := doRequest(t, server.URL, http.MethodGet, "/service/servers", ""); status != http.StatusBadGateway {
+		t.Fatalf("expected bad gateway, got %d", status)
+	}
+	if elapsed := time.Since(startedAt); elapsed < 20*time.Millisecond {
+		t.Fatalf("expected the fault latency, got %s", elapsed)
+	}
+}
+
+func TestAPIChecksCredentials(t *testing.T) {
+	api := New(Server{ID: "1", Name: "worker1", Datacenter: "EU", Power: "on"})
+	api.SetCredentials("client-id", "secret")
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	if status, _ := doRequest(t, server.URL, http.MethodGet, "/service/servers", ""); status != http.StatusUnauthorized {
+		t.Fatalf("expected unauthorized without credentials, got %d", status)
+	}
+}
+
+func TestAPIAdminEndpoints(t *testing.T) {
+	api := New()
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	if status, _ := doRequest(t, server.URL, http.MethodPut, "/fake/servers", `[{"id":"1","name":"worker1","datacenter":"EU","power":"off"}]`); status != http.StatusNoContent {
+		t.Fatalf("unexpected set servers status %d", status)
+	}
+	if status, _ := doRequest(t, server.URL, http.MethodPost, "/fake/faults", `{"path":"/service/servers","statusCode":503}`); status != http.StatusNoContent {
+		t.Fatalf("unexpected add fault status %d", status)
+	}
+	if status, _ := doRequest(t, server.URL, http.MethodGet, "/service/servers", ""); status != http.StatusServiceUnavailable {
+		t.Fatalf("expected the fault, got %d", status)
+	}
+	doRequest(t, server.URL, http.MethodDelete, "/fake/faults", "")
+	if status, body := doRequest(t, server.URL, http.MethodGet, "/fake/servers", ""); status != http.StatusOK || body != `[{"datacenter":"EU","id":"1","name":"worker1","power":"off"}]` {
+		t.Fatalf("unexpected admin servers response %d %s", status, body)
+	}
+	if requests := api.Requests(); len(requests) != 1 || requests[0].Path != "/service/servers" {
+		t.Fatalf("expected only the API request to be recorded, got %+v", requests)
+	}
+}